@@ -1,16 +1,46 @@
-package main
-
-import (
-	"flag"
-	"progression1/cmd/pkg/cli"
-)
-
-func main() {
-	method := flag.String("method", "GET", "HTTP method")
-	endpoint := flag.String("endpoint", "/", "API endpoint")
-	data := flag.String("data", "", "JSON payload")
-	host := flag.String("host", "http://localhost:8080", "API host")
-	flag.Parse()
-	client := cli.NewClient(*host)
-	client.Request(*method, *endpoint, *data)
-}
+package main
+
+import (
+	"flag"
+	"progression1/cmd/pkg/cli"
+)
+
+func main() {
+	method := flag.String("method", "GET", "HTTP method")
+	endpoint := flag.String("endpoint", "/", "API endpoint")
+	data := flag.String("data", "", "JSON payload")
+	host := flag.String("host", "http://localhost:8080", "API host")
+	deleteSegment := flag.String("delete-segment", "", "Slug of the segment to delete")
+	actor := flag.String("actor", "cli", "Actor recorded in the membership history (X-Actor header)")
+	importFile := flag.String("import-file", "", "CSV file with user IDs to add to -import-segments")
+	importSegments := flag.String("import-segments", "", "Comma-separated slugs of the segments to import users into")
+	importTTL := flag.Int("import-ttl-hours", 0, "TTL of imported assignments in hours (0 - permanent)")
+	reason := flag.String("reason", "", "Reason recorded in the membership history")
+	exportSegment := flag.String("export-segment", "", "Slug of the segment whose users to export to -output")
+	exportFormat := flag.String("export-format", "ndjson", "Export format: ndjson or csv")
+	output := flag.String("output", "", "File to save the exported segment users to (default segment_users.<export-format>)")
+	flag.Parse()
+	client := cli.NewClient(*host)
+	client.Actor = *actor
+	if *importFile != "" {
+		client.ImportSegmentUsers(*importFile, *importSegments, *importTTL, *reason)
+		return
+	}
+	if *exportSegment != "" {
+		if *output == "" {
+			// Без -export-format сервис отдаёт ndjson
+			extension := *exportFormat
+			if extension == "" {
+				extension = "ndjson"
+			}
+			*output = "segment_users." + extension
+		}
+		client.ExportSegmentUsers(*exportSegment, *exportFormat, *output)
+		return
+	}
+	if *deleteSegment != "" {
+		client.DeleteSegment(*deleteSegment)
+		return
+	}
+	client.Request(*method, *endpoint, *data)
+}
@@ -1,51 +1,210 @@
-package main
-
-import (
-	"context"
-	"log"
-	"log/slog"
-	"os"
-	"os/signal"
-	_ "progression1/docs"
-	"progression1/internal/repository"
-	"progression1/internal/service"
-	"progression1/internal/transport/https"
-	"syscall"
-
-	"github.com/joho/godotenv"
-)
-
-// @title Сервис динамической сегментации пользователей
-// @version 1.0
-// @host localhost:8080
-// @BasePath /
-func main() {
-	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
-	if err := godotenv.Load(); err != nil {
-		slog.Default().Warn("Could not load .env file. Using OS environment variables.", "err", err)
-	}
-	db, err := repository.ConnectToBase()
-	if err != nil {
-		log.Fatal("DB connection failed:", err)
-	}
-	npsri := repository.NewPgxSegmentRepo(db)
-	userService := service.NewUserService(npsri)
-	httpHandlers := https.NewHTTPHandlers(userService)
-	port := os.Getenv("APP_PORT")
-	if port == "" {
-		log.Fatal("APP_PORT not set in environment or .env file.")
-	}
-	srv := https.NewHTTPServer(httpHandlers, port)
-	if err := repository.RunMigrations(db); err != nil {
-		log.Fatal("Failed to run database migrations: ", err)
-	}
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
-	shutdownTimeout := os.Getenv("SHUTDOWN_TIMEOUT")
-	if shutdownTimeout == "" {
-		log.Fatal("SHUTDOWN_TIMEOUT not set in environment or .env file.")
-	}
-	if err := https.StartServer(ctx, srv, db, shutdownTimeout); err != nil {
-		log.Fatal(err)
-	}
-}
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	_ "progression1/docs"
+	"progression1/internal/repository"
+	"progression1/internal/service"
+	"progression1/internal/transport/https"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// @title Сервис динамической сегментации пользователей
+// @version 1.0
+// @host localhost:8080
+// @BasePath /
+func main() {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	if err := godotenv.Load(); err != nil {
+		slog.Default().Warn("Could not load .env file. Using OS environment variables.", "err", err)
+	}
+	db, err := repository.ConnectToBase()
+	if err != nil {
+		log.Fatal("DB connection failed:", err)
+	}
+	npsri := repository.NewPgxSegmentRepo(db)
+	cacheTTL, err := segmentCacheConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	segmentCache := repository.NewSegmentCache(cacheTTL)
+	expvar.Publish("segment_cache", expvar.Func(func() any { return segmentCache.Stats() }))
+	userService := service.NewUserService(repository.NewCachedSegmentRepo(npsri, segmentCache))
+	if userService.UserBase, err = userBaseConfig(); err != nil {
+		log.Fatal(err)
+	}
+	reportsDir, reportsRetention, err := reportsConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	reportService := service.NewReportService(npsri, reportsDir, reportsRetention)
+	httpHandlers := https.NewHTTPHandlers(userService, reportService)
+	port := os.Getenv("APP_PORT")
+	if port == "" {
+		log.Fatal("APP_PORT not set in environment or .env file.")
+	}
+	srv := https.NewHTTPServer(httpHandlers, port)
+	if err := repository.RunMigrations(db); err != nil {
+		log.Fatal("Failed to run database migrations: ", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	shutdownTimeout := os.Getenv("SHUTDOWN_TIMEOUT")
+	if shutdownTimeout == "" {
+		log.Fatal("SHUTDOWN_TIMEOUT not set in environment or .env file.")
+	}
+	sweepInterval, sweepBatchSize, err := ttlSweeperConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	sweeper := service.NewTTLSweeper(npsri, sweepInterval, sweepBatchSize)
+	sweeperDone := make(chan struct{})
+	go func() {
+		defer close(sweeperDone)
+		sweeper.Run(ctx)
+	}()
+	materializeInterval, materializeBatchSize, err := materializerConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	materializer := service.NewMaterializer(npsri, materializeInterval, materializeBatchSize)
+	materializerDone := make(chan struct{})
+	go func() {
+		defer close(materializerDone)
+		materializer.Run(ctx)
+	}()
+	cacheDone := make(chan struct{})
+	go func() {
+		defer close(cacheDone)
+		segmentCache.Listen(ctx, db)
+	}()
+	cleanupDone := make(chan struct{})
+	go func() {
+		defer close(cleanupDone)
+		reportService.RunCleanup(ctx, reportsCleanupInterval)
+	}()
+	// Метрики (/debug/vars) отдаются только на внутреннем адресе ADMIN_ADDR; без него не публикуются
+	adminDone := make(chan struct{})
+	go func() {
+		defer close(adminDone)
+		if addr := os.Getenv("ADMIN_ADDR"); addr != "" {
+			https.StartAdminServer(ctx, https.NewAdminServer(addr))
+		}
+	}()
+	stopWorkers := func() {
+		<-sweeperDone
+		<-materializerDone
+		<-cacheDone
+		<-cleanupDone
+		<-adminDone
+	}
+	if err := https.StartServer(ctx, srv, db, shutdownTimeout, stopWorkers); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// reportsCleanupInterval — как часто удаляются отчёты с истёкшим сроком хранения
+const reportsCleanupInterval = 10 * time.Minute
+
+// reportsConfig читает каталог CSV-отчётов (REPORTS_DIR, по умолчанию ./reports)
+// и срок их хранения (REPORTS_RETENTION, по умолчанию 24h). Отчёт пишется на локальный
+// диск и отдаётся через /reports/ тем же процессом, который его сформировал, поэтому
+// сервис рассчитан на один экземпляр: при нескольких репликах ссылка на отчёт открывается,
+// только если запрос попал на ту же реплику (или REPORTS_DIR — общий для всех реплик том)
+func reportsConfig() (string, time.Duration, error) {
+	dir := os.Getenv("REPORTS_DIR")
+	if dir == "" {
+		dir = "./reports"
+	}
+	retention := 24 * time.Hour
+	if v := os.Getenv("REPORTS_RETENTION"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			return "", 0, fmt.Errorf("REPORTS_RETENTION must be a positive duration, got %q", v)
+		}
+		retention = parsed
+	}
+	return dir, retention, nil
+}
+
+// segmentCacheConfig читает срок жизни кэша каталога сегментов (SEGMENT_CACHE_TTL, по умолчанию 1m);
+// обычно кэш сбрасывается раньше — по NOTIFY при изменении сегментов
+func segmentCacheConfig() (time.Duration, error) {
+	ttl := time.Minute
+	if v := os.Getenv("SEGMENT_CACHE_TTL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			return 0, fmt.Errorf("SEGMENT_CACHE_TTL must be a positive duration, got %q", v)
+		}
+		ttl = parsed
+	}
+	return ttl, nil
+}
+
+// userBaseConfig читает общее число пользователей для оценки численности сегментов
+// (USER_BASE_TOTAL); без значения оценка строится по реестру users
+func userBaseConfig() (int64, error) {
+	v := os.Getenv("USER_BASE_TOTAL")
+	if v == "" {
+		return 0, nil
+	}
+	total, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || total <= 0 {
+		return 0, fmt.Errorf("USER_BASE_TOTAL must be a positive integer, got %q", v)
+	}
+	return total, nil
+}
+
+// ttlSweeperConfig читает интервал (TTL_SWEEP_INTERVAL, по умолчанию 1m) и размер
+// пачки (TTL_SWEEP_BATCH_SIZE, по умолчанию 1000) фоновой очистки истёкших назначений
+func ttlSweeperConfig() (time.Duration, int, error) {
+	interval := time.Minute
+	if v := os.Getenv("TTL_SWEEP_INTERVAL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			return 0, 0, fmt.Errorf("TTL_SWEEP_INTERVAL must be a positive duration, got %q", v)
+		}
+		interval = parsed
+	}
+	batchSize := 1000
+	if v := os.Getenv("TTL_SWEEP_BATCH_SIZE"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			return 0, 0, fmt.Errorf("TTL_SWEEP_BATCH_SIZE must be a positive integer, got %q", v)
+		}
+		batchSize = parsed
+	}
+	return interval, batchSize, nil
+}
+
+// materializerConfig читает интервал (MATERIALIZE_INTERVAL, по умолчанию 30s) и размер страницы
+// реестра пользователей (MATERIALIZE_BATCH_SIZE, по умолчанию 1000) фоновой материализации сегментов
+func materializerConfig() (time.Duration, int, error) {
+	interval := 30 * time.Second
+	if v := os.Getenv("MATERIALIZE_INTERVAL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			return 0, 0, fmt.Errorf("MATERIALIZE_INTERVAL must be a positive duration, got %q", v)
+		}
+		interval = parsed
+	}
+	batchSize := 1000
+	if v := os.Getenv("MATERIALIZE_BATCH_SIZE"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			return 0, 0, fmt.Errorf("MATERIALIZE_BATCH_SIZE must be a positive integer, got %q", v)
+		}
+		batchSize = parsed
+	}
+	return interval, batchSize, nil
+}
@@ -1,53 +1,140 @@
-package cli
-
-import (
-	"encoding/json"
-	"fmt"
-	"io"
-	"log"
-	"net/http"
-	"strings"
-)
-
-type Client struct {
-	Host string
-}
-
-func NewClient(host string) *Client {
-	return &Client{Host: host}
-}
-
-func (c *Client) Request(method, endpoint, data string) {
-	url := c.Host + endpoint
-	var req *http.Request
-	var err error
-	if data != "" {
-		req, err = http.NewRequest(method, url, strings.NewReader(data))
-	} else {
-		req, err = http.NewRequest(method, url, nil)
-	}
-	if err != nil {
-		log.Fatal("Failed to create request:", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Fatal("Request failed:", err)
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatal("Failed to read response:", err)
-	}
-	var prettyJSON map[string]interface{}
-	if err := json.Unmarshal(body, &prettyJSON); err == nil {
-		prettyBody, _ := json.MarshalIndent(prettyJSON, "", "  ")
-		fmt.Printf("Status: %s\n", resp.Status)
-		fmt.Println("Response:")
-		fmt.Println(string(prettyBody))
-	} else {
-		fmt.Printf("Status: %s\n", resp.Status)
-		fmt.Printf("Response: %s\n", string(body))
-	}
-}
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type Client struct {
+	Host string
+	// Actor передаётся в заголовке X-Actor и попадает в историю изменений
+	Actor string
+}
+
+func NewClient(host string) *Client {
+	return &Client{Host: host}
+}
+
+func (c *Client) Request(method, endpoint, data string) {
+	url := c.Host + endpoint
+	var req *http.Request
+	var err error
+	if data != "" {
+		req, err = http.NewRequest(method, url, strings.NewReader(data))
+	} else {
+		req, err = http.NewRequest(method, url, nil)
+	}
+	if err != nil {
+		log.Fatal("Failed to create request:", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c.do(req)
+}
+
+// do отправляет запрос и печатает ответ
+func (c *Client) do(req *http.Request) {
+	if c.Actor != "" {
+		req.Header.Set("X-Actor", c.Actor)
+	}
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal("Request failed:", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal("Failed to read response:", err)
+	}
+	var prettyJSON map[string]interface{}
+	if err := json.Unmarshal(body, &prettyJSON); err == nil {
+		prettyBody, _ := json.MarshalIndent(prettyJSON, "", "  ")
+		fmt.Printf("Status: %s\n", resp.Status)
+		fmt.Println("Response:")
+		fmt.Println(string(prettyBody))
+	} else {
+		fmt.Printf("Status: %s\n", resp.Status)
+		fmt.Printf("Response: %s\n", string(body))
+	}
+}
+
+func (c *Client) DeleteSegment(slug string) {
+	c.Request(http.MethodDelete, "/segments/"+url.PathEscape(slug), "")
+}
+
+// ImportSegmentUsers загружает CSV-файл с ID пользователей в сегменты segments (через запятую).
+// Файл передаётся потоком, без чтения в память; ttlHours <= 0 — бессрочные назначения.
+func (c *Client) ImportSegmentUsers(path, segments string, ttlHours int, reason string) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatal("Failed to open import file:", err)
+	}
+	defer file.Close()
+	query := url.Values{"segments": {segments}}
+	if ttlHours > 0 {
+		query.Set("ttl_hours", strconv.Itoa(ttlHours))
+	}
+	if reason != "" {
+		query.Set("reason", reason)
+	}
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		part, err := form.CreateFormFile("file", filepath.Base(path))
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	req, err := http.NewRequest(http.MethodPost, c.Host+"/segments/import?"+query.Encode(), body)
+	if err != nil {
+		log.Fatal("Failed to create request:", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	c.do(req)
+}
+
+// ExportSegmentUsers сохраняет участников сегмента в файл path в формате format (ndjson или csv).
+// Ответ копируется в файл потоком, поэтому выгрузка не ограничена памятью клиента.
+func (c *Client) ExportSegmentUsers(slug, format, path string) {
+	query := url.Values{}
+	if format != "" {
+		query.Set("format", format)
+	}
+	resp, err := http.Get(c.Host + "/segments/" + url.PathEscape(slug) + "/users?" + query.Encode())
+	if err != nil {
+		log.Fatal("Request failed:", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Printf("Status: %s\n", resp.Status)
+		fmt.Printf("Response: %s\n", string(body))
+		return
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		log.Fatal("Failed to create output file:", err)
+	}
+	written, err := io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatal("Failed to write output file:", err)
+	}
+	fmt.Printf("Status: %s\n", resp.Status)
+	fmt.Printf("Saved %d bytes to %s\n", written, path)
+}
@@ -15,9 +15,120 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/layers": {
+            "get": {
+                "description": "Получает все слои с диапазонами бакетов, занятыми их сегментами",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "layer"
+                ],
+                "summary": "Получить все слои",
+                "responses": {
+                    "200": {
+                        "description": "Успешная операция",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.LayerDTO"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Создаёт слой взаимоисключающих экспериментов. Пользователь попадает максимум в один сегмент слоя.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "layer"
+                ],
+                "summary": "Создать слой",
+                "parameters": [
+                    {
+                        "description": "Параметры слоя",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.LayerDTO"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Успешная операция",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный запрос",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            }
+        },
+        "/reports/{name}": {
+            "get": {
+                "description": "Отдаёт CSV-файл, сформированный POST /segments/history/report",
+                "produces": [
+                    "text/csv"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Скачать CSV-отчёт",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Имя файла отчёта",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "CSV-отчёт",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "404": {
+                        "description": "Отчёт не найден или срок его хранения истёк",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            }
+        },
         "/segments": {
             "get": {
-                "description": "Получает все существующие сегменты",
+                "description": "Получает все существующие сегменты с метаданными и состоянием жизненного цикла (scheduled, active, ended). Список можно отфильтровать по тегу и владельцу.",
                 "consumes": [
                     "application/json"
                 ],
@@ -28,13 +139,27 @@ const docTemplate = `{
                     "segment"
                 ],
                 "summary": "Получить все сегменты",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Тег сегмента",
+                        "name": "tag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Владелец сегмента",
+                        "name": "owner",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "Успешная операция",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "type": "string"
+                                "$ref": "#/definitions/model.SegmentInfoDTO"
                             }
                         }
                     },
@@ -47,7 +172,7 @@ const docTemplate = `{
                 }
             },
             "post": {
-                "description": "Добавляет сегмент",
+                "description": "Добавляет сегмент. С \"materialize\": true известные пользователи (реестр users), попадающие в auto_percent и правило таргетинга (с учётом include/exclude переопределений), записываются в сегмент фоновой задачей с операцией AUTO_ADDED в истории; при изменении конфигурации или переопределений сегмента эти строки пересчитываются.",
                 "consumes": [
                     "application/json"
                 ],
@@ -67,6 +192,12 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/model.SegmentDTO"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Кто вносит изменение (сохраняется в истории)",
+                        "name": "X-Actor",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -77,7 +208,19 @@ const docTemplate = `{
                         }
                     },
                     "400": {
-                        "description": "Невалидный запрос или конфликт",
+                        "description": "Невалидный запрос",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "404": {
+                        "description": "Слой не найден",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "409": {
+                        "description": "Сегмент с таким slug уже существует",
                         "schema": {
                             "$ref": "#/definitions/model.ErrorDTO"
                         }
@@ -93,10 +236,7 @@ const docTemplate = `{
         },
         "/segments/history": {
             "get": {
-                "description": "Получает историю добавления/удаления сегментов у пользователя. Если указаны параметры 'year' и 'month', возвращает данные за период. Иначе возвращает всю доступную историю.",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "История добавления/удаления сегментов по возрастанию (created_at, id). Фильтры необязательны и комбинируются; 'year' и 'month' — сокращение для диапазона завершённого месяца. Без 'cursor' и 'limit' ответ, как и прежде, — массив всех подходящих записей без ограничения. С 'cursor' или 'limit' ответ постраничный: {items, next_cursor}, по умолчанию 100 записей на странице; для следующей страницы передайте next_cursor в 'cursor' с теми же фильтрами, на последней странице next_cursor отсутствует. Ответ передаётся потоком, без буферизации.",
                 "produces": [
                     "application/json"
                 ],
@@ -105,6 +245,42 @@ const docTemplate = `{
                 ],
                 "summary": "Получить историю операций с сегментами",
                 "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID пользователя",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Slug сегмента",
+                        "name": "slug",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Операция (ADDED, REMOVED, AUTO_ADDED, ...)",
+                        "name": "operation",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Кто внёс изменение (значение X-Actor)",
+                        "name": "actor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Начало диапазона включительно (RFC3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Конец диапазона не включительно (RFC3339)",
+                        "name": "to",
+                        "in": "query"
+                    },
                     {
                         "type": "integer",
                         "description": "Год для фильтрации истории (например, 2024)",
@@ -116,20 +292,29 @@ const docTemplate = `{
                         "description": "Месяц для фильтрации истории (1-12)",
                         "name": "month",
                         "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Курсор следующей страницы",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Размер страницы (1-1000, по умолчанию 100)",
+                        "name": "limit",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Успешная операция. Возвращает список записей истории.",
+                        "description": "Страница истории (с cursor или limit); без них — массив model.HistoryTableDTO",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/model.HistoryTableDTO"
-                            }
+                            "$ref": "#/definitions/model.HistoryPageDTO"
                         }
                     },
                     "400": {
-                        "description": "Невалидный запрос: неверный формат года/месяца или невалидный период.",
+                        "description": "Невалидные фильтры, период или курсор",
                         "schema": {
                             "$ref": "#/definitions/model.ErrorDTO"
                         }
@@ -143,9 +328,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/user/{user_id}": {
-            "get": {
-                "description": "Получает активные сегменты пользователя",
+        "/segments/history/report": {
+            "post": {
+                "description": "Формирует CSV-файл с историей операций за месяц (user_id;segment;operation;created_at;actor;reason) и возвращает ссылку на него. Ссылка действует в течение срока хранения отчётов.",
                 "consumes": [
                     "application/json"
                 ],
@@ -153,36 +338,29 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "segment"
                 ],
-                "summary": "Получить сегменты пользователя",
+                "summary": "Сформировать CSV-отчёт по истории",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "ID пользователя",
-                        "name": "user_id",
-                        "in": "path",
-                        "required": true
+                        "description": "Год и месяц отчёта",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.ReportRequestDTO"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Список активных сегментов пользователя",
+                        "description": "Ссылка на отчёт",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/model.SegmentUserDataDTO"
-                            }
+                            "$ref": "#/definitions/model.ReportDTO"
                         }
                     },
                     "400": {
-                        "description": "Невалидный ID пользователя",
-                        "schema": {
-                            "$ref": "#/definitions/model.ErrorDTO"
-                        }
-                    },
-                    "404": {
-                        "description": "Пользователь не найден",
+                        "description": "Невалидный период",
                         "schema": {
                             "$ref": "#/definitions/model.ErrorDTO"
                         }
@@ -194,11 +372,13 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
+            }
+        },
+        "/segments/import": {
             "post": {
-                "description": "Добавляет пользователя к существующему сегменту",
+                "description": "Добавляет пользователей из загруженного файла во все перечисленные сегменты. Файл передаётся частью 'file' в multipart/form-data и читается потоком; ID пользователя берётся из первой колонки (разделители \",\", \";\" или табуляция), первая нечисловая строка считается заголовком. Строки с невалидным ID отклоняются и перечисляются в ответе (первые 100). Каждое назначение пишется в историю с операцией ADDED. Повторный импорт безопасен: существующим назначениям обновляется срок действия.",
                 "consumes": [
-                    "application/json"
+                    "multipart/form-data"
                 ],
                 "produces": [
                     "application/json"
@@ -206,42 +386,72 @@ const docTemplate = `{
                 "tags": [
                     "user"
                 ],
-                "summary": "Добавить пользователя к сегменту",
+                "summary": "Импортировать пользователей в сегменты из CSV",
                 "parameters": [
                     {
-                        "description": "Параметры для добавления/удаления сегментов",
-                        "name": "input",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/model.SegmentDTO"
-                        }
+                        "type": "string",
+                        "description": "SLUG сегментов через запятую",
+                        "name": "segments",
+                        "in": "query",
+                        "required": true
                     },
                     {
                         "type": "integer",
-                        "description": "ID пользователя",
-                        "name": "user_id",
-                        "in": "path",
+                        "description": "Время жизни назначений в часах (1-719)",
+                        "name": "ttl_hours",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Причина изменения (сохраняется в истории)",
+                        "name": "reason",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Кто вносит изменение (сохраняется в истории)",
+                        "name": "X-Actor",
+                        "in": "header"
+                    },
+                    {
+                        "type": "file",
+                        "description": "CSV-файл с ID пользователей",
+                        "name": "file",
+                        "in": "formData",
                         "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Успешная операция",
+                        "description": "Итог импорта",
                         "schema": {
-                            "$ref": "#/definitions/model.UserResponseDTO"
+                            "$ref": "#/definitions/model.ImportResultDTO"
                         }
                     },
                     "400": {
-                        "description": "Невалидный запрос",
+                        "description": "Невалидные параметры, сегмент слоя или нет файла",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "404": {
+                        "description": "Сегмент не найден",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера; уже импортированные пачки сохраняются",
                         "schema": {
                             "$ref": "#/definitions/model.ErrorDTO"
                         }
                     }
                 }
-            },
-            "patch": {
-                "description": "Добавляет или удаляет сегменты у пользователя, опционально устанавливая TTL.",
+            }
+        },
+        "/segments/{slug}": {
+            "delete": {
+                "description": "Удаляет сегмент вместе со всеми назначениями пользователей. Для каждого затронутого пользователя в историю пишется операция REMOVED.",
                 "consumes": [
                     "application/json"
                 ],
@@ -249,93 +459,1765 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "segment"
                 ],
-                "summary": "Обновить сегменты пользователю",
+                "summary": "Удалить сегмент",
                 "parameters": [
                     {
-                        "description": "Параметры для добавления/удаления сегментов у пользователя",
-                        "name": "input",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/model.SegmentUpdateUserDTO"
-                        }
-                    },
-                    {
-                        "type": "integer",
-                        "description": "ID пользователя",
-                        "name": "user_id",
+                        "type": "string",
+                        "description": "SLUG сегмента",
+                        "name": "slug",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Причина удаления (сохраняется в истории)",
+                        "name": "reason",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Кто вносит изменение (сохраняется в истории)",
+                        "name": "X-Actor",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Операция прошла успешно",
+                        "description": "Успешная операция",
                         "schema": {
                             "type": "string"
                         }
                     },
                     "400": {
-                        "description": "Невалидный запрос или конфликт",
+                        "description": "Невалидный SLUG",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "404": {
+                        "description": "Сегмент не найден",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
                         "schema": {
                             "$ref": "#/definitions/model.ErrorDTO"
                         }
                     }
                 }
-            }
-        }
-    },
-    "definitions": {
-        "model.ErrorDTO": {
-            "type": "object",
+            },
+            "patch": {
+                "description": "Меняет auto_percent и окно активности (active_from/active_until) сегмента. clear_active_from и clear_active_until снимают соответствующую границу окна. Пользователи, уже попавшие в сегмент, остаются в нём при увеличении процента. Каждое изменение пишется в аудит конфигурации.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Изменить сегмент",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "SLUG сегмента",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Новые параметры сегмента",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.SegmentPatchDTO"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Успешная операция",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный запрос",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "404": {
+                        "description": "Сегмент не найден",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            }
+        },
+        "/segments/{slug}/config": {
+            "get": {
+                "description": "Возвращает историю изменений auto_percent сегмента, начиная с создания",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Получить аудит конфигурации сегмента",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "SLUG сегмента",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Успешная операция",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.SegmentConfigHistoryDTO"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный SLUG",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            }
+        },
+        "/segments/{slug}/overrides": {
+            "get": {
+                "description": "Возвращает пользователей, явно включённых в сегмент (include) и исключённых из него (exclude)",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Получить переопределения сегмента",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "SLUG сегмента",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Успешная операция",
+                        "schema": {
+                            "$ref": "#/definitions/model.SegmentOverridesDTO"
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный SLUG",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "404": {
+                        "description": "Сегмент не найден",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "Явно включает пользователей в сегмент или исключает из него независимо от auto_percent и правил таргетинга. Каждое изменение пишется в историю (INCLUDE_ADDED, INCLUDE_REMOVED, EXCLUDE_ADDED, EXCLUDE_REMOVED).",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Изменить переопределения сегмента",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "SLUG сегмента",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Изменения переопределений",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.SegmentOverridesPatchDTO"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Кто вносит изменение (сохраняется в истории)",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Успешная операция",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный запрос",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "404": {
+                        "description": "Сегмент не найден",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            }
+        },
+        "/segments/{slug}/reshuffle": {
+            "post": {
+                "description": "Меняет соль бакетирования сегмента: при том же auto_percent в сегмент попадает новая случайная выборка пользователей. Вход и выход известных пользователей пишутся в историю (RESHUFFLE_ADDED, RESHUFFLE_REMOVED); у сегмента с materialize автоматические строки пересчитывает фоновая материализация (AUTO_ADDED, AUTO_REMOVED). Ручные назначения и переопределения не меняются. Сегменты слоёв не перемешиваются.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Перемешать выборку сегмента",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "SLUG сегмента",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Причина перемешивания (сохраняется в истории)",
+                        "name": "reason",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Кто вносит изменение (сохраняется в истории)",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Успешная операция",
+                        "schema": {
+                            "$ref": "#/definitions/model.ReshuffleResultDTO"
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный SLUG или сегмент слоя",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "404": {
+                        "description": "Сегмент не найден",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            }
+        },
+        "/segments/{slug}/rollout": {
+            "get": {
+                "description": "Возвращает шаги плана раскатки, состояние паузы и действующий сейчас auto_percent",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rollout"
+                ],
+                "summary": "Получить план раскатки сегмента",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "SLUG сегмента",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Успешная операция",
+                        "schema": {
+                            "$ref": "#/definitions/model.RolloutPlanDTO"
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный SLUG",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "404": {
+                        "description": "Сегмент не найден",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Полностью заменяет шаги плана раскатки. Пустой список шагов удаляет план.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rollout"
+                ],
+                "summary": "Задать план раскатки сегмента",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "SLUG сегмента",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Шаги плана раскатки",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.RolloutStepsDTO"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Успешная операция",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный запрос",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "404": {
+                        "description": "Сегмент не найден",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "На паузе действует процент последнего шага, наступившего до момента паузы",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rollout"
+                ],
+                "summary": "Поставить план раскатки на паузу или возобновить",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "SLUG сегмента",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Состояние паузы",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.RolloutPauseDTO"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Успешная операция",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный запрос",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "404": {
+                        "description": "Сегмент не найден",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            }
+        },
+        "/segments/{slug}/stats": {
+            "get": {
+                "description": "Возвращает число действующих ручных участников (и из них — с TTL), оценку численности по действующему auto_percent от реестра пользователей или USER_BASE_TOTAL и динамику ручного членства по дням (UTC) из истории (ADDED, REMOVED, EXPIRED; материализованные AUTO_* не учитываются): добавленные, удалённые и участники на конец дня. Правило таргетинга в оценке не учитывается.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Получить статистику сегмента",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "SLUG сегмента",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Число дней динамики, включая текущий (1-365, по умолчанию 30)",
+                        "name": "days",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Успешная операция",
+                        "schema": {
+                            "$ref": "#/definitions/model.SegmentStatsDTO"
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный SLUG или days",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "404": {
+                        "description": "Сегмент не найден",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            }
+        },
+        "/segments/{slug}/users": {
+            "get": {
+                "description": "Потоком отдаёт пользователей, вручную назначенных в сегмент (с действующим сроком), по возрастанию user_id: NDJSON (по объекту на строку) или CSV (user_id;assigned_at;expires_at). Для постраничной выгрузки передайте limit и в следующем запросе after = user_id последней строки; страница короче limit — последняя. Без limit выгружаются все участники.",
+                "produces": [
+                    "application/json",
+                    "text/csv"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Выгрузить участников сегмента",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "SLUG сегмента",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Формат: ndjson (по умолчанию) или csv",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Выгружать пользователей с user_id больше указанного",
+                        "name": "after",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Размер страницы (1-100000)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Участники сегмента (NDJSON или CSV)",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.SegmentMemberDTO"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидные параметры",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "404": {
+                        "description": "Сегмент не найден",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/{user_id}": {
+            "get": {
+                "description": "Получает активные сегменты пользователя. Query-параметры считаются атрибутами пользователя для сегментов с правилами таргетинга (например, ?country=RU\u0026platform=ios). С as_of сегменты восстанавливаются на указанный момент: ручные назначения (с их сроком действия) и переопределения — по истории операций, auto_percent, окно активности, правило, план раскатки и слой — по действовавшей тогда конфигурации; атрибуты пользователя берутся текущие.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Получить сегменты пользователя",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID пользователя",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Момент времени в прошлом (RFC3339)",
+                        "name": "as_of",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Список активных сегментов пользователя",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.SegmentUserDataDTO"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный ID пользователя или as_of",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "404": {
+                        "description": "Пользователь не найден",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Добавляет пользователя к существующему сегменту",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Добавить пользователя к сегменту",
+                "parameters": [
+                    {
+                        "description": "Параметры для добавления/удаления сегментов",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.SegmentDTO"
+                        }
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID пользователя",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Кто вносит изменение (сохраняется в истории)",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Успешная операция",
+                        "schema": {
+                            "$ref": "#/definitions/model.UserResponseDTO"
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный запрос",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "Добавляет или удаляет сегменты у пользователя. Срок действия задаётся общим ttl_hours для addslugs или для каждого сегмента в add (ttl_hours от 1 до 719 либо expires_at в будущем). update продлевает (ttl_hours, expires_at) или снимает (clear_ttl) TTL существующего назначения. Некорректный срок отклоняется с ошибкой.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Обновить сегменты пользователю",
+                "parameters": [
+                    {
+                        "description": "Параметры для добавления/удаления сегментов у пользователя",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.SegmentUpdateUserDTO"
+                        }
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID пользователя",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Кто вносит изменение (сохраняется в истории)",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Операция прошла успешно",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный запрос или конфликт",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/{user_id}/explain": {
+            "get": {
+                "description": "Возвращает решение по каждому сегменту с причиной: manual, expired_ttl, bucket_hit/bucket_miss (бакет против auto_percent), rule_not_matched, no_auto_percent, inactive, override_include/override_exclude. Query-параметры считаются атрибутами пользователя, как в GET /user/{user_id}.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Объяснить сегменты пользователя",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID пользователя",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Решения по сегментам",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.SegmentDecisionDTO"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный ID пользователя",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/{user_id}/segments": {
+            "get": {
+                "description": "Получает активные сегменты пользователя. Query-параметры считаются атрибутами пользователя для сегментов с правилами таргетинга (например, ?country=RU\u0026platform=ios). С as_of сегменты восстанавливаются на указанный момент: ручные назначения (с их сроком действия) и переопределения — по истории операций, auto_percent, окно активности, правило, план раскатки и слой — по действовавшей тогда конфигурации; атрибуты пользователя берутся текущие.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Получить сегменты пользователя",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID пользователя",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Момент времени в прошлом (RFC3339)",
+                        "name": "as_of",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Список активных сегментов пользователя",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.SegmentUserDataDTO"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный ID пользователя или as_of",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "404": {
+                        "description": "Пользователь не найден",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Получает активные сегменты пользователя с учётом атрибутов из тела запроса для сегментов с правилами таргетинга",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Получить сегменты пользователя по контексту",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID пользователя",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Атрибуты пользователя",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.UserContextDTO"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Список активных сегментов пользователя",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.SegmentUserDataDTO"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный запрос",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/{user_id}/segments/{slug}": {
+            "get": {
+                "description": "Отвечает, состоит ли пользователь в одном сегменте, с источником членства (manual — ручное назначение или явное включение, auto — по auto_percent, rule — по правилу таргетинга) и сроком ручного назначения. Читается только запрошенный сегмент. Query-параметры считаются атрибутами пользователя, как в GET /user/{user_id}.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Проверить членство пользователя в сегменте",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID пользователя",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "SLUG сегмента",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Членство в сегменте",
+                        "schema": {
+                            "$ref": "#/definitions/model.UserSegmentMembershipDTO"
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный ID пользователя или SLUG",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "404": {
+                        "description": "Сегмент не найден",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/segments": {
+            "post": {
+                "description": "Вычисляет активные сегменты до 1000 пользователей за один запрос; результат совпадает с GET /user/{user_id} без атрибутов в запросе (правила таргетинга проверяются по атрибутам из реестра). Повторяющиеся ID учитываются один раз.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Получить сегменты нескольких пользователей",
+                "parameters": [
+                    {
+                        "description": "ID пользователей",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.BatchUserSegmentsRequestDTO"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Активные сегменты по ID пользователя",
+                        "schema": {
+                            "$ref": "#/definitions/model.BatchUserSegmentsDTO"
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный запрос",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{user_id}/attributes": {
+            "get": {
+                "description": "Возвращает сохранённые атрибуты пользователя, используемые правилами таргетинга",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Получить атрибуты пользователя",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID пользователя",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Успешная операция",
+                        "schema": {
+                            "$ref": "#/definitions/model.UserAttributesDTO"
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный ID пользователя",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Полностью заменяет сохранённые атрибуты пользователя. Значения — строки, числа или булевы.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Заменить атрибуты пользователя",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID пользователя",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Атрибуты пользователя",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.UserContextDTO"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Успешная операция",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный запрос",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "Добавляет или меняет переданные атрибуты пользователя, остальные не трогает. Значение null удаляет атрибут.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Дополнить атрибуты пользователя",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID пользователя",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Изменяемые атрибуты пользователя",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.UserContextDTO"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Успешная операция",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Невалидный запрос",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка сервера",
+                        "schema": {
+                            "$ref": "#/definitions/model.ErrorDTO"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "model.BatchUserSegmentsDTO": {
+            "type": "object",
+            "properties": {
+                "users": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "array",
+                        "items": {
+                            "$ref": "#/definitions/model.SegmentUserDataDTO"
+                        }
+                    }
+                }
+            }
+        },
+        "model.BatchUserSegmentsRequestDTO": {
+            "type": "object",
+            "properties": {
+                "user_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "model.ErrorDTO": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.HistoryPageDTO": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.HistoryTableDTO"
+                    }
+                },
+                "next_cursor": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.HistoryTableDTO": {
+            "type": "object",
+            "properties": {
+                "actor": {
+                    "description": "Actor и Reason — кто и почему изменил членство (пусто для записей до их появления)",
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "operation": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "segment_slug": {
+                    "type": "string"
+                },
+                "user_ID": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.ImportLineErrorDTO": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "line": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.ImportResultDTO": {
+            "type": "object",
+            "properties": {
+                "accepted": {
+                    "type": "integer"
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.ImportLineErrorDTO"
+                    }
+                },
+                "rejected": {
+                    "type": "integer"
+                },
+                "segments": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "model.LayerDTO": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "segments": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.LayerSegmentDTO"
+                    }
+                },
+                "slug": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.LayerSegmentDTO": {
+            "type": "object",
+            "properties": {
+                "auto_percent": {
+                    "type": "integer"
+                },
+                "layer_offset": {
+                    "type": "integer"
+                },
+                "max_percent": {
+                    "description": "Максимальный процент с учётом плана раскатки — столько бакетов зарезервировано",
+                    "type": "integer"
+                },
+                "segment_slug": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.ReportDTO": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.ReportRequestDTO": {
+            "type": "object",
+            "properties": {
+                "month": {
+                    "type": "integer"
+                },
+                "year": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.ReshuffleResultDTO": {
+            "type": "object",
+            "properties": {
+                "added": {
+                    "type": "integer"
+                },
+                "removed": {
+                    "type": "integer"
+                },
+                "segment_slug": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.RolloutPauseDTO": {
+            "type": "object",
+            "properties": {
+                "paused": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "model.RolloutPlanDTO": {
+            "type": "object",
+            "properties": {
+                "auto_percent": {
+                    "type": "number"
+                },
+                "effective_percent": {
+                    "type": "number"
+                },
+                "paused_at": {
+                    "type": "string"
+                },
+                "segment_slug": {
+                    "type": "string"
+                },
+                "steps": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.RolloutStepDTO"
+                    }
+                }
+            }
+        },
+        "model.RolloutStepDTO": {
+            "type": "object",
+            "properties": {
+                "percent": {
+                    "description": "Percent — auto_percent шага с точностью до 0.01; у сегментов слоя только целый",
+                    "type": "number"
+                },
+                "starts_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.RolloutStepsDTO": {
+            "type": "object",
+            "properties": {
+                "steps": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.RolloutStepDTO"
+                    }
+                }
+            }
+        },
+        "model.SegmentAssignmentDTO": {
+            "type": "object",
             "properties": {
-                "error": {
+                "clear_ttl": {
+                    "type": "boolean"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "slug": {
                     "type": "string"
+                },
+                "ttl_hours": {
+                    "type": "integer"
                 }
             }
         },
-        "model.HistoryTableDTO": {
+        "model.SegmentConfigHistoryDTO": {
             "type": "object",
             "properties": {
+                "active_from": {
+                    "type": "string"
+                },
+                "active_until": {
+                    "type": "string"
+                },
+                "auto_percent": {
+                    "type": "number"
+                },
                 "created_at": {
                     "type": "string"
                 },
+                "deleted": {
+                    "description": "Deleted — запись об удалении сегмента",
+                    "type": "boolean"
+                },
                 "id": {
                     "type": "integer"
                 },
-                "operation": {
+                "previous_auto_percent": {
+                    "type": "number"
+                },
+                "segment_slug": {
+                    "type": "string"
+                },
+                "targeting_rule": {
+                    "$ref": "#/definitions/model.TargetingRuleDTO"
+                }
+            }
+        },
+        "model.SegmentDTO": {
+            "type": "object",
+            "properties": {
+                "active_from": {
+                    "type": "string"
+                },
+                "active_until": {
+                    "type": "string"
+                },
+                "auto_percent": {
+                    "type": "number"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "layer": {
+                    "type": "string"
+                },
+                "layer_offset": {
+                    "type": "integer"
+                },
+                "materialize": {
+                    "description": "Materialize записывает в сегмент известных пользователей, попавших в auto_percent,\nфоновой задачей и поддерживает эти строки при изменении конфигурации сегмента",
+                    "type": "boolean"
+                },
+                "owner": {
+                    "type": "string"
+                },
+                "reason": {
+                    "description": "Reason — причина изменения членства для истории",
+                    "type": "string"
+                },
+                "slug": {
+                    "type": "string"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "targeting_rule": {
+                    "$ref": "#/definitions/model.TargetingRuleDTO"
+                }
+            }
+        },
+        "model.SegmentDailyStatsDTO": {
+            "type": "object",
+            "properties": {
+                "added": {
+                    "type": "integer"
+                },
+                "date": {
+                    "type": "string"
+                },
+                "members": {
+                    "type": "integer"
+                },
+                "removed": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.SegmentDecisionDTO": {
+            "type": "object",
+            "properties": {
+                "auto_percent": {
+                    "description": "Действующий auto_percent с учётом плана раскатки",
+                    "type": "number"
+                },
+                "bucket": {
+                    "description": "Бакет пользователя в процентах (0..99.99); для сегмента слоя — бакет слоя,\nсегмент занимает [layer_offset, layer_offset + auto_percent)",
+                    "type": "number"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "layer": {
+                    "type": "string"
+                },
+                "layer_offset": {
+                    "type": "integer"
+                },
+                "member": {
+                    "type": "boolean"
+                },
+                "reason": {
                     "type": "string"
                 },
                 "segment_slug": {
                     "type": "string"
+                }
+            }
+        },
+        "model.SegmentInfoDTO": {
+            "type": "object",
+            "properties": {
+                "active_from": {
+                    "type": "string"
                 },
-                "user_ID": {
+                "active_until": {
+                    "type": "string"
+                },
+                "auto_percent": {
+                    "type": "number"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "layer": {
+                    "type": "string"
+                },
+                "layer_offset": {
                     "type": "integer"
+                },
+                "owner": {
+                    "type": "string"
+                },
+                "slug": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "targeting_rule": {
+                    "$ref": "#/definitions/model.TargetingRuleDTO"
+                },
+                "updated_at": {
+                    "type": "string"
                 }
             }
         },
-        "model.SegmentDTO": {
+        "model.SegmentMemberDTO": {
+            "type": "object",
+            "properties": {
+                "assigned_at": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.SegmentOverridesDTO": {
+            "type": "object",
+            "properties": {
+                "exclude": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "include": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "segment_slug": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.SegmentOverridesPatchDTO": {
+            "type": "object",
+            "properties": {
+                "exclude": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "include": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "remove": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "model.SegmentPatchDTO": {
+            "type": "object",
+            "properties": {
+                "active_from": {
+                    "type": "string"
+                },
+                "active_until": {
+                    "type": "string"
+                },
+                "auto_percent": {
+                    "type": "number"
+                },
+                "clear_active_from": {
+                    "description": "Снимают границу окна активности; нельзя сочетать с новым значением той же границы",
+                    "type": "boolean"
+                },
+                "clear_active_until": {
+                    "type": "boolean"
+                },
+                "targeting_rule": {
+                    "description": "Правило с пустым списком условий удаляет таргетинг",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.TargetingRuleDTO"
+                        }
+                    ]
+                }
+            }
+        },
+        "model.SegmentStatsDTO": {
             "type": "object",
             "properties": {
                 "auto_percent": {
+                    "description": "Действующий процент (с учётом окна активности и плана раскатки) и оценка численности по нему",
+                    "type": "number"
+                },
+                "daily": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.SegmentDailyStatsDTO"
+                    }
+                },
+                "estimated_auto_members": {
+                    "type": "integer"
+                },
+                "has_targeting_rule": {
+                    "description": "С правилом таргетинга оценка — верхняя граница: правило не учитывается",
+                    "type": "boolean"
+                },
+                "manual_members": {
+                    "type": "integer"
+                },
+                "members_with_ttl": {
                     "type": "integer"
                 },
                 "slug": {
                     "type": "string"
+                },
+                "user_base": {
+                    "type": "integer"
+                },
+                "user_base_source": {
+                    "type": "string"
                 }
             }
         },
         "model.SegmentUpdateUserDTO": {
             "type": "object",
             "properties": {
+                "add": {
+                    "description": "Add — добавляемые сегменты с индивидуальным сроком действия",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.SegmentAssignmentDTO"
+                    }
+                },
                 "addslugs": {
                     "type": "array",
                     "items": {
                         "type": "string"
                     }
                 },
+                "reason": {
+                    "description": "Reason — причина изменения для истории",
+                    "type": "string"
+                },
                 "removeslugs": {
                     "type": "array",
                     "items": {
@@ -343,7 +2225,15 @@ const docTemplate = `{
                     }
                 },
                 "ttl_hours": {
+                    "description": "TTLHours применяется к сегментам из AddSlugs",
                     "type": "integer"
+                },
+                "update": {
+                    "description": "Update — продление или снятие TTL у существующих назначений без переназначения",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.SegmentAssignmentDTO"
+                    }
                 }
             }
         },
@@ -351,8 +2241,9 @@ const docTemplate = `{
             "type": "object",
             "properties": {
                 "autoPercent": {
-                    "description": "Процент для автоматического назначения (из segments)",
-                    "type": "integer"
+                    "description": "Процент для автоматического назначения (из segments), с точностью до 0.01",
+                    "type": "number",
+                    "format": "float64"
                 },
                 "expiresAt": {
                     "type": "string"
@@ -367,6 +2258,58 @@ const docTemplate = `{
                 }
             }
         },
+        "model.TargetingConditionDTO": {
+            "type": "object",
+            "properties": {
+                "attribute": {
+                    "type": "string"
+                },
+                "op": {
+                    "type": "string"
+                },
+                "value": {
+                    "type": "string"
+                },
+                "values": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "model.TargetingRuleDTO": {
+            "type": "object",
+            "properties": {
+                "all": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.TargetingConditionDTO"
+                    }
+                }
+            }
+        },
+        "model.UserAttributesDTO": {
+            "type": "object",
+            "properties": {
+                "attributes": {
+                    "type": "object",
+                    "additionalProperties": {}
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.UserContextDTO": {
+            "type": "object",
+            "properties": {
+                "attributes": {
+                    "type": "object",
+                    "additionalProperties": {}
+                }
+            }
+        },
         "model.UserResponseDTO": {
             "type": "object",
             "properties": {
@@ -381,6 +2324,26 @@ const docTemplate = `{
                     "format": "int64"
                 }
             }
+        },
+        "model.UserSegmentMembershipDTO": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "type": "string"
+                },
+                "member": {
+                    "type": "boolean"
+                },
+                "segment_slug": {
+                    "type": "string"
+                },
+                "source": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
         }
     }
 }`
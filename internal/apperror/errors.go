@@ -1,28 +1,90 @@
-package apperror
-
-import "errors"
-
-var (
-	ErrSegmentNotFound     = errors.New("segment not found")
-	ErrSegmentConflict     = errors.New("segment cannot be in both 'add' and 'remove' lists")
-	ErrUserSegmentNotFound = errors.New("user segment not found")
-
-	ErrCannotInsertT  = errors.New("cannot insert into table")
-	ErrCannotDeleteFT = errors.New("failed to remove from table")
-	ErrCannotCreateT  = errors.New("cannot create table")
-
-	ErrDuringRowsIteration = errors.New("error during rows iteration")
-
-	ErrUserIDInvalid = errors.New("user id must be positive")
-	ErrPercentAbove  = errors.New("percent must be less than 100")
-	ErrPercentLess   = errors.New("percent must be above than 0")
-
-	ErrSlugNotFound    = errors.New("slug not found")
-	ErrEmptySlug       = errors.New("slug cannot be empty")
-	ErrSlugLength      = errors.New("slug length must be between 3 and 50 characters")
-	ErrSlugRegex       = errors.New("slug must contain only latin letters, digits, and underscores")
-	ErrTooManySegments = errors.New("cannot update more than 100 segments in one request")
-
-	ErrFailedBTransaction = errors.New("failed to begin transaction")
-	ErrFailedCTransaction = errors.New("failed to commit transaction")
-)
+package apperror
+
+import "errors"
+
+var (
+	ErrSegmentNotFound     = errors.New("segment not found")
+	ErrSegmentExists       = errors.New("segment already exists")
+	ErrSegmentConflict     = errors.New("segment cannot be in both 'add' and 'remove' lists")
+	ErrUserSegmentNotFound = errors.New("user segment not found")
+	ErrSegmentDuplicate    = errors.New("segment can appear only once across 'add', 'remove' and 'update' lists")
+
+	ErrTTLRange       = errors.New("ttl_hours must be between 1 and 719")
+	ErrExpiresAtPast  = errors.New("expires_at must be in the future")
+	ErrTTLConflict    = errors.New("only one of ttl_hours, expires_at and clear_ttl can be set")
+	ErrTTLUpdateEmpty = errors.New("update entry must set one of ttl_hours, expires_at or clear_ttl")
+
+	ErrCannotInsertT  = errors.New("cannot insert into table")
+	ErrCannotDeleteFT = errors.New("failed to remove from table")
+	ErrCannotCreateT  = errors.New("cannot create table")
+
+	ErrDuringRowsIteration = errors.New("error during rows iteration")
+
+	ErrUserIDInvalid    = errors.New("user id must be positive")
+	ErrPercentAbove     = errors.New("percent must be less than 100")
+	ErrPercentLess      = errors.New("percent must be above than 0")
+	ErrPercentPrecision = errors.New("percent must have at most two decimal places")
+	ErrEmptyPatch       = errors.New("nothing to update")
+	ErrActiveWindow     = errors.New("active_from must be before active_until")
+	ErrActiveClear      = errors.New("active_from or active_until cannot be both set and cleared")
+
+	ErrTooManyRolloutSteps   = errors.New("rollout plan cannot have more than 50 steps")
+	ErrRolloutStepTime       = errors.New("rollout step must have starts_at")
+	ErrRolloutStepsDuplicate = errors.New("rollout steps must have distinct starts_at")
+
+	ErrSlugNotFound    = errors.New("slug not found")
+	ErrEmptySlug       = errors.New("slug cannot be empty")
+	ErrSlugLength      = errors.New("slug length must be between 3 and 50 characters")
+	ErrSlugRegex       = errors.New("slug must contain only latin letters, digits, and underscores")
+	ErrSlugReserved    = errors.New("slug is reserved")
+	ErrTooManySegments = errors.New("cannot update more than 100 segments in one request")
+
+	ErrDescriptionLength = errors.New("description must be at most 1000 characters")
+	ErrOwnerLength       = errors.New("owner must be at most 100 characters")
+	ErrTooManyTags       = errors.New("segment cannot have more than 20 tags")
+	ErrTagInvalid        = errors.New("tag must be between 1 and 50 characters")
+
+	ErrLayerNotFound  = errors.New("layer not found")
+	ErrLayerOverflow  = errors.New("segment does not fit into free buckets of its layer")
+	ErrLayerConflict  = errors.New("user cannot be in two segments of the same layer")
+	ErrLayerOffset    = errors.New("layer offset must be between 0 and 99")
+	ErrLayerReshuffle = errors.New("segment in a layer uses the layer bucket space and cannot be reshuffled")
+	ErrLayerPercent   = errors.New("segment in a layer must have a whole auto_percent")
+	ErrLayerRule      = errors.New("segment in a layer with a targeting rule must have auto_percent")
+
+	ErrRuleTooManyConditions = errors.New("targeting rule cannot have more than 20 conditions")
+	ErrRuleAttribute         = errors.New("targeting condition attribute must be between 1 and 50 characters")
+	ErrRuleOperator          = errors.New("targeting condition operator must be one of eq, neq, in, not_in, gt, gte, lt, lte")
+	ErrRuleValue             = errors.New("targeting condition must have value for comparison or values for in/not_in")
+	ErrAttributeValue        = errors.New("user attribute value must be a string, number or boolean")
+	ErrAttributeKey          = errors.New("user attribute name must be between 1 and 50 characters")
+	ErrTooManyAttributes     = errors.New("user cannot have more than 100 attributes")
+
+	ErrTooManyOverrides = errors.New("cannot change more than 1000 overrides in one request")
+	ErrOverrideConflict = errors.New("user cannot be in more than one of 'include', 'exclude' and 'remove' lists")
+
+	ErrActorLength  = errors.New("actor must be at most 100 characters")
+	ErrReasonLength = errors.New("reason must be at most 500 characters")
+
+	ErrMembersLimit  = errors.New("members limit must be between 1 and 100000")
+	ErrMembersFormat = errors.New("members format must be ndjson or csv")
+
+	ErrImportNoSegments = errors.New("import requires at least one segment")
+	ErrImportLayer      = errors.New("users cannot be imported into a segment of a layer")
+
+	ErrAsOfFuture = errors.New("as_of cannot be in the future")
+
+	ErrStatsDays = errors.New("stats days must be between 1 and 365")
+
+	ErrBatchSize = errors.New("user_ids must contain between 1 and 1000 users")
+
+	ErrHistoryCursor = errors.New("invalid history cursor")
+	ErrHistoryLimit  = errors.New("history limit must be between 1 and 1000")
+	ErrHistoryRange  = errors.New("history 'from' must be earlier than 'to'")
+
+	ErrReportPeriod   = errors.New("invalid report period")
+	ErrReportNotFound = errors.New("report not found or expired")
+
+	ErrFailedBTransaction = errors.New("failed to begin transaction")
+	ErrFailedCTransaction = errors.New("failed to commit transaction")
+)
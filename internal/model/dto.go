@@ -1,40 +1,460 @@
-package model
-
-import "time"
-
-type HistoryTableDTO struct {
-	ID           int
-	User_ID      int
-	Segment_slug string
-	Operation    string
-	Created_at   time.Time
-}
-
-type ErrorDTO struct {
-	Error string `json:"error"`
-}
-
-type SegmentDTO struct {
-	Slug         string `json:"slug"`
-	Auto_percent *int   `json:"auto_percent,omitempty"`
-}
-
-type SegmentUpdateUserDTO struct {
-	AddSlugs    []string `json:"addslugs"`
-	RemoveSlugs []string `json:"removeslugs"`
-	TTLHours    *int     `json:"ttl_hours,omitempty"`
-}
-
-type UserResponseDTO struct {
-	Received bool
-	UserID   int64
-	Slug     string
-}
-
-type SegmentUserDataDTO struct {
-	Slug        string // Имя сегмента (из segments)
-	AutoPercent int    // Процент для автоматического назначения (из segments)
-	// Эти поля будут NULL/false, если пользователь не состоит в сегменте вручную
-	IsManuallyAssigned bool
-	ExpiresAt          *time.Time
-}
+package model
+
+import "time"
+
+// Типы операций в истории user_segment_history
+const (
+	OperationAdded          = "ADDED"
+	OperationRemoved        = "REMOVED"
+	OperationAutoAdded      = "AUTO_ADDED"
+	OperationAutoRemoved    = "AUTO_REMOVED"
+	OperationExpired        = "EXPIRED"
+	OperationIncludeAdded   = "INCLUDE_ADDED"
+	OperationIncludeRemoved = "INCLUDE_REMOVED"
+	OperationExcludeAdded   = "EXCLUDE_ADDED"
+	OperationExcludeRemoved = "EXCLUDE_REMOVED"
+	// Вход и выход по auto_percent после смены соли; строк user_segments они не меняют
+	OperationReshuffleAdded   = "RESHUFFLE_ADDED"
+	OperationReshuffleRemoved = "RESHUFFLE_REMOVED"
+	// Продление или снятие TTL действующего назначения; членство не меняется
+	OperationTTLUpdated = "TTL_UPDATED"
+)
+
+// Режимы явных переопределений членства в сегменте
+const (
+	OverrideInclude = "INCLUDE"
+	OverrideExclude = "EXCLUDE"
+)
+
+type HistoryTableDTO struct {
+	ID           int
+	User_ID      int
+	Segment_slug string
+	Operation    string
+	Created_at   time.Time
+	// Actor и Reason — кто и почему изменил членство (пусто для записей до их появления)
+	Actor  string
+	Reason string
+}
+
+// AuditDTO — кто и почему меняет членство; сохраняется в истории вместе с операцией
+type AuditDTO struct {
+	Actor  string
+	Reason string
+}
+
+// ActorTTLSweeper — actor записей EXPIRED, которые пишет фоновая очистка TTL
+const ActorTTLSweeper = "ttl_sweeper"
+
+// ActorMaterializer — actor записей AUTO_ADDED и AUTO_REMOVED фоновой материализации
+const ActorMaterializer = "materializer"
+
+// MaterializationDTO — состояние фоновой материализации сегмента. Key — конфигурация,
+// по которой идёт или завершился проход; Cursor — последний обработанный user_id, nil — проход завершён
+type MaterializationDTO struct {
+	Slug   string
+	Key    string
+	Cursor *int64
+}
+
+// MaterializationPageDTO — результат обработки страницы реестра пользователей (AfterID, LastID]:
+// Members — пользователи страницы, попадающие в сегмент; Done — страница последняя
+type MaterializationPageDTO struct {
+	Slug    string
+	Key     string
+	AfterID int64
+	LastID  int64
+	Done    bool
+	Members []int64
+}
+
+// HistoryCursorDTO — позиция в истории по ключу (created_at, id)
+type HistoryCursorDTO struct {
+	Created_at time.Time
+	ID         int
+}
+
+// HistoryFilterDTO — фильтры чтения истории; пустые поля не фильтруют.
+// Записи возвращаются по возрастанию (created_at, id) строго после After.
+type HistoryFilterDTO struct {
+	User_ID   *int64
+	Slug      string
+	Operation string
+	Actor     string
+	// From — включительно, To — не включительно
+	From  *time.Time
+	To    *time.Time
+	After *HistoryCursorDTO
+	// Limit ограничивает число записей; 0 — без ограничения
+	Limit int
+}
+
+// HistoryPageDTO — страница истории; next_cursor передаётся в следующий запрос
+type HistoryPageDTO struct {
+	Items       []HistoryTableDTO `json:"items"`
+	Next_cursor string            `json:"next_cursor,omitempty"`
+}
+
+// ReportRequestDTO — период месячного отчёта по истории
+type ReportRequestDTO struct {
+	Year  int `json:"year"`
+	Month int `json:"month"`
+}
+
+// ReportDTO — ссылка на сформированный CSV-отчёт
+type ReportDTO struct {
+	URL        string    `json:"url"`
+	Expires_at time.Time `json:"expires_at"`
+}
+
+type ErrorDTO struct {
+	Error string `json:"error"`
+}
+
+type SegmentDTO struct {
+	Slug           string            `json:"slug"`
+	Auto_percent   *float64          `json:"auto_percent,omitempty"`
+	Description    string            `json:"description,omitempty"`
+	Owner          string            `json:"owner,omitempty"`
+	Tags           []string          `json:"tags,omitempty"`
+	Active_from    *time.Time        `json:"active_from,omitempty"`
+	Active_until   *time.Time        `json:"active_until,omitempty"`
+	Layer          string            `json:"layer,omitempty"`
+	Layer_offset   *int              `json:"layer_offset,omitempty"`
+	Targeting_rule *TargetingRuleDTO `json:"targeting_rule,omitempty"`
+	// Materialize записывает в сегмент известных пользователей, попавших в auto_percent,
+	// фоновой задачей и поддерживает эти строки при изменении конфигурации сегмента
+	Materialize bool `json:"materialize,omitempty"`
+	// Reason — причина изменения членства для истории
+	Reason string `json:"reason,omitempty"`
+}
+
+// TargetingRuleDTO — правило таргетинга: все условия должны выполняться (AND).
+// Сегмент с правилом выдаётся пользователям, чьи атрибуты подходят под правило,
+// с учётом auto_percent; если auto_percent не задан, выдаётся всем подходящим.
+type TargetingRuleDTO struct {
+	All []TargetingConditionDTO `json:"all"`
+}
+
+// TargetingConditionDTO — условие на атрибут пользователя.
+// Op: eq, neq, in, not_in (по Values), gt, gte, lt, lte (сравнение версий/чисел)
+type TargetingConditionDTO struct {
+	Attribute string   `json:"attribute"`
+	Op        string   `json:"op"`
+	Value     string   `json:"value,omitempty"`
+	Values    []string `json:"values,omitempty"`
+}
+
+// UserContextDTO — атрибуты пользователя, передаваемые при вычислении сегментов.
+// Переданные атрибуты дополняют и переопределяют сохранённые в реестре пользователей.
+type UserContextDTO struct {
+	Attributes map[string]any `json:"attributes"`
+}
+
+// UserAttributesDTO — сохранённые атрибуты пользователя
+type UserAttributesDTO struct {
+	UserID     int64          `json:"user_id"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+// Состояния жизненного цикла сегмента относительно окна активности
+const (
+	SegmentStateScheduled = "scheduled"
+	SegmentStateActive    = "active"
+	SegmentStateEnded     = "ended"
+)
+
+// SegmentInfoDTO — полное описание сегмента для GET /segments
+type SegmentInfoDTO struct {
+	Slug           string            `json:"slug"`
+	Auto_percent   *float64          `json:"auto_percent,omitempty"`
+	Description    string            `json:"description"`
+	Owner          string            `json:"owner"`
+	Tags           []string          `json:"tags"`
+	Active_from    *time.Time        `json:"active_from,omitempty"`
+	Active_until   *time.Time        `json:"active_until,omitempty"`
+	State          string            `json:"state"`
+	Layer          string            `json:"layer,omitempty"`
+	Layer_offset   *int              `json:"layer_offset,omitempty"`
+	Targeting_rule *TargetingRuleDTO `json:"targeting_rule,omitempty"`
+	Created_at     time.Time         `json:"created_at"`
+	Updated_at     time.Time         `json:"updated_at"`
+}
+
+// SegmentPatchDTO — изменяемые параметры сегмента, nil означает «не менять»
+type SegmentPatchDTO struct {
+	Auto_percent *float64   `json:"auto_percent,omitempty"`
+	Active_from  *time.Time `json:"active_from,omitempty"`
+	Active_until *time.Time `json:"active_until,omitempty"`
+	// Снимают границу окна активности; нельзя сочетать с новым значением той же границы
+	Clear_active_from  bool `json:"clear_active_from,omitempty"`
+	Clear_active_until bool `json:"clear_active_until,omitempty"`
+	// Правило с пустым списком условий удаляет таргетинг
+	Targeting_rule *TargetingRuleDTO `json:"targeting_rule,omitempty"`
+}
+
+// SegmentConfigHistoryDTO — запись аудита изменений конфигурации сегмента
+type SegmentConfigHistoryDTO struct {
+	ID                    int               `json:"id"`
+	Segment_slug          string            `json:"segment_slug"`
+	Previous_auto_percent *float64          `json:"previous_auto_percent,omitempty"`
+	Auto_percent          *float64          `json:"auto_percent,omitempty"`
+	Active_from           *time.Time        `json:"active_from,omitempty"`
+	Active_until          *time.Time        `json:"active_until,omitempty"`
+	Targeting_rule        *TargetingRuleDTO `json:"targeting_rule,omitempty"`
+	// Deleted — запись об удалении сегмента
+	Deleted    bool      `json:"deleted,omitempty"`
+	Created_at time.Time `json:"created_at"`
+}
+
+// SegmentFilterDTO — фильтры списка сегментов, пустое поле не фильтрует
+type SegmentFilterDTO struct {
+	Tag   string
+	Owner string
+}
+
+type SegmentUpdateUserDTO struct {
+	AddSlugs    []string `json:"addslugs"`
+	RemoveSlugs []string `json:"removeslugs"`
+	// TTLHours применяется к сегментам из AddSlugs
+	TTLHours *int `json:"ttl_hours,omitempty"`
+	// Add — добавляемые сегменты с индивидуальным сроком действия
+	Add []SegmentAssignmentDTO `json:"add,omitempty"`
+	// Update — продление или снятие TTL у существующих назначений без переназначения
+	Update []SegmentAssignmentDTO `json:"update,omitempty"`
+	// Reason — причина изменения для истории
+	Reason string `json:"reason,omitempty"`
+}
+
+// SegmentAssignmentDTO — назначение сегмента со сроком действия: задаётся ttl_hours
+// или expires_at; clear_ttl делает существующее назначение бессрочным
+type SegmentAssignmentDTO struct {
+	Slug       string     `json:"slug"`
+	TTL_hours  *int       `json:"ttl_hours,omitempty"`
+	Expires_at *time.Time `json:"expires_at,omitempty"`
+	Clear_ttl  bool       `json:"clear_ttl,omitempty"`
+}
+
+// SegmentMemberDTO — пользователь, вручную назначенный в сегмент
+type SegmentMemberDTO struct {
+	User_id     int64      `json:"user_id"`
+	Assigned_at *time.Time `json:"assigned_at,omitempty"`
+	Expires_at  *time.Time `json:"expires_at,omitempty"`
+}
+
+// ImportRequestDTO — параметры массового импорта пользователей в сегменты
+type ImportRequestDTO struct {
+	Segments  []string
+	TTL_hours *int
+}
+
+// ImportLineErrorDTO — отклонённая строка файла импорта
+type ImportLineErrorDTO struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportResultDTO — итог импорта: число принятых и отклонённых строк и первые ошибки
+type ImportResultDTO struct {
+	Segments []string             `json:"segments"`
+	Accepted int                  `json:"accepted"`
+	Rejected int                  `json:"rejected"`
+	Errors   []ImportLineErrorDTO `json:"errors,omitempty"`
+}
+
+type UserResponseDTO struct {
+	Received bool
+	UserID   int64
+	Slug     string
+}
+
+type SegmentUserDataDTO struct {
+	Slug        string  // Имя сегмента (из segments)
+	AutoPercent float64 // Процент для автоматического назначения (из segments), с точностью до 0.01
+	// Эти поля будут NULL/false, если пользователь не состоит в сегменте вручную
+	IsManuallyAssigned bool
+	ExpiresAt          *time.Time
+	// План раскатки сегмента, используется для вычисления действующего процента
+	RolloutSteps    []RolloutStepDTO `json:"-"`
+	RolloutPausedAt *time.Time       `json:"-"`
+	// Окно активности сегмента
+	ActiveFrom  *time.Time `json:"-"`
+	ActiveUntil *time.Time `json:"-"`
+	// Слой сегмента: бакет считается по слою, сегмент занимает [LayerOffset, LayerOffset + AutoPercent)
+	Layer       string `json:"-"`
+	LayerOffset int    `json:"-"`
+	// Правило таргетинга; сегмент с правилом выдаётся только при совпадении атрибутов
+	TargetingRule *TargetingRuleDTO `json:"-"`
+	// Явное переопределение для пользователя: OverrideInclude, OverrideExclude или пусто
+	Override string `json:"-"`
+	// Соль бакетирования; пустая соль — исторический бакет по "userID:slug"
+	Salt string `json:"-"`
+}
+
+// UserAssignmentDTO — ручное назначение (Manual) или переопределение (Override) пользователя в сегменте
+type UserAssignmentDTO struct {
+	UserID    int64
+	Slug      string
+	Manual    bool
+	ExpiresAt *time.Time
+	Override  string
+}
+
+// BatchUserSegmentsRequestDTO — пользователи для пакетного вычисления сегментов
+type BatchUserSegmentsRequestDTO struct {
+	User_ids []int64 `json:"user_ids"`
+}
+
+// BatchUserSegmentsDTO — активные сегменты каждого пользователя из запроса
+type BatchUserSegmentsDTO struct {
+	Users map[int64][]SegmentUserDataDTO `json:"users"`
+}
+
+// Источник членства пользователя в сегменте (GET /user/{id}/segments/{slug})
+const (
+	SourceManual = "manual" // ручное назначение или явное включение
+	SourceAuto   = "auto"   // бакет в пределах auto_percent
+	SourceRule   = "rule"   // совпадение правила таргетинга и бакет в пределах auto_percent
+)
+
+// UserSegmentMembershipDTO — состоит ли пользователь в одном сегменте, по какой причине и до какого времени
+type UserSegmentMembershipDTO struct {
+	User_id      int64      `json:"user_id"`
+	Segment_slug string     `json:"segment_slug"`
+	Member       bool       `json:"member"`
+	Source       string     `json:"source,omitempty"`
+	Expires_at   *time.Time `json:"expires_at,omitempty"`
+}
+
+// SegmentCacheStatsDTO — счётчики кэша каталога сегментов (публикуются в /debug/vars)
+type SegmentCacheStatsDTO struct {
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	Hit_ratio     float64 `json:"hit_ratio"`
+	Invalidations int64   `json:"invalidations"`
+	Segments      int     `json:"segments"`
+}
+
+// Причины решения о членстве пользователя в сегменте (GET /user/{id}/explain)
+const (
+	ReasonInactive        = "inactive"         // вне окна активности сегмента
+	ReasonOverrideInclude = "override_include" // явно включён в сегмент
+	ReasonOverrideExclude = "override_exclude" // явно исключён из сегмента
+	ReasonManual          = "manual"           // назначен вручную
+	ReasonExpired         = "expired_ttl"      // ручное назначение истекло
+	ReasonRuleNotMatched  = "rule_not_matched" // атрибуты не подходят под правило таргетинга
+	ReasonNoAutoPercent   = "no_auto_percent"  // auto_percent не задан или равен нулю
+	ReasonBucketHit       = "bucket_hit"       // бакет попал в auto_percent
+	ReasonBucketMiss      = "bucket_miss"      // бакет не попал в auto_percent
+)
+
+// SegmentDecisionDTO — решение о членстве пользователя в сегменте с причиной
+type SegmentDecisionDTO struct {
+	Segment_slug string     `json:"segment_slug"`
+	Member       bool       `json:"member"`
+	Reason       string     `json:"reason"`
+	Expires_at   *time.Time `json:"expires_at,omitempty"`
+	// Действующий auto_percent с учётом плана раскатки
+	Auto_percent float64 `json:"auto_percent"`
+	// Бакет пользователя в процентах (0..99.99); для сегмента слоя — бакет слоя,
+	// сегмент занимает [layer_offset, layer_offset + auto_percent)
+	Bucket       *float64 `json:"bucket,omitempty"`
+	Layer        string   `json:"layer,omitempty"`
+	Layer_offset *int     `json:"layer_offset,omitempty"`
+}
+
+// ReshuffleResultDTO — результат смены соли сегмента: сколько известных пользователей
+// вошло в сегмент и вышло из него по auto_percent
+type ReshuffleResultDTO struct {
+	Segment_slug string `json:"segment_slug"`
+	Added        int    `json:"added"`
+	Removed      int    `json:"removed"`
+}
+
+// SegmentOverridesDTO — списки явно включённых и исключённых пользователей сегмента
+type SegmentOverridesDTO struct {
+	Segment_slug string  `json:"segment_slug"`
+	Include      []int64 `json:"include"`
+	Exclude      []int64 `json:"exclude"`
+}
+
+// SegmentOverridesPatchDTO — изменение переопределений: пользователь из include/exclude
+// переводится в соответствующий режим, из remove — лишается переопределения
+type SegmentOverridesPatchDTO struct {
+	Include []int64 `json:"include,omitempty"`
+	Exclude []int64 `json:"exclude,omitempty"`
+	Remove  []int64 `json:"remove,omitempty"`
+	Reason  string  `json:"reason,omitempty"`
+}
+
+// LayerDTO — слой взаимоисключающих экспериментов
+type LayerDTO struct {
+	Slug        string            `json:"slug"`
+	Description string            `json:"description,omitempty"`
+	Created_at  time.Time         `json:"created_at"`
+	Segments    []LayerSegmentDTO `json:"segments,omitempty"`
+}
+
+// LayerSegmentDTO — диапазон бакетов, занимаемый сегментом в слое
+type LayerSegmentDTO struct {
+	Segment_slug string `json:"segment_slug"`
+	Layer_offset int    `json:"layer_offset"`
+	Auto_percent int    `json:"auto_percent"`
+	// Максимальный процент с учётом плана раскатки — столько бакетов зарезервировано
+	Max_percent int `json:"max_percent"`
+}
+
+// RolloutStepDTO — шаг плана раскатки: с момента starts_at действует percent
+type RolloutStepDTO struct {
+	// Percent — auto_percent шага с точностью до 0.01; у сегментов слоя только целый
+	Percent   float64   `json:"percent"`
+	Starts_at time.Time `json:"starts_at"`
+}
+
+// RolloutPlanDTO — план раскатки сегмента
+type RolloutPlanDTO struct {
+	Segment_slug      string           `json:"segment_slug"`
+	Auto_percent      *float64         `json:"auto_percent,omitempty"`
+	Effective_percent float64          `json:"effective_percent"`
+	Paused_at         *time.Time       `json:"paused_at,omitempty"`
+	Steps             []RolloutStepDTO `json:"steps"`
+}
+
+// RolloutStepsDTO — тело запроса на замену шагов плана
+type RolloutStepsDTO struct {
+	Steps []RolloutStepDTO `json:"steps"`
+}
+
+// RolloutPauseDTO — тело запроса на паузу/возобновление плана
+type RolloutPauseDTO struct {
+	Paused bool `json:"paused"`
+}
+
+// Источник размера пользовательской базы для оценки auto_percent
+const (
+	UserBaseRegistry = "registry" // число пользователей в реестре users
+	UserBaseConfig   = "config"   // значение USER_BASE_TOTAL
+)
+
+// SegmentStatsDTO — численность сегмента: ручные участники, оценка по auto_percent и динамика по дням
+type SegmentStatsDTO struct {
+	Slug             string `json:"slug"`
+	Manual_members   int64  `json:"manual_members"`
+	Members_with_ttl int64  `json:"members_with_ttl"`
+	// Действующий процент (с учётом окна активности и плана раскатки) и оценка численности по нему
+	Auto_percent           float64 `json:"auto_percent"`
+	User_base              int64   `json:"user_base"`
+	User_base_source       string  `json:"user_base_source"`
+	Estimated_auto_members int64   `json:"estimated_auto_members"`
+	// С правилом таргетинга оценка — верхняя граница: правило не учитывается
+	Has_targeting_rule bool                   `json:"has_targeting_rule"`
+	Daily              []SegmentDailyStatsDTO `json:"daily"`
+}
+
+// SegmentDailyStatsDTO — изменения членства за день (UTC) по истории и число участников на конец дня
+type SegmentDailyStatsDTO struct {
+	Date    string `json:"date"`
+	Added   int64  `json:"added"`
+	Removed int64  `json:"removed"`
+	Members int64  `json:"members"`
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"progression1/internal/model"
+	"time"
+)
+
+// segmentsAsOfQuery собирает данные сегментов на момент $2 в порядке колонок querySegmentsData:
+// конфигурация, план раскатки, пауза и слой — последняя запись segment_config_history, ручное
+// назначение (со сроком действия) и переопределение — последняя соответствующая операция
+// пользователя в user_segment_history. Для записей аудита, сделанных до историзации плана раскатки и слоя
+// (rollout_steps IS NULL), эти поля берутся из текущего сегмента.
+const segmentsAsOfQuery = `
+	WITH config AS (
+		SELECT DISTINCT ON (segment_slug) segment_slug, auto_percent, active_from, active_until, targeting_rule, hash_salt, deleted,
+			rollout_steps, rollout_paused_at, layer_slug, layer_offset
+		FROM segment_config_history
+		WHERE created_at <= $2
+		ORDER BY segment_slug, created_at DESC, id DESC
+	),
+	manual AS (
+		SELECT DISTINCT ON (segment_slug) segment_slug, operation, expires_at
+		FROM user_segment_history
+		WHERE user_id = $1 AND created_at <= $2 AND operation = ANY($3::text[])
+		ORDER BY segment_slug, created_at DESC, id DESC
+	),
+	override AS (
+		SELECT DISTINCT ON (segment_slug) segment_slug, operation
+		FROM user_segment_history
+		WHERE user_id = $1 AND created_at <= $2 AND operation = ANY($4::text[])
+		ORDER BY segment_slug, created_at DESC, id DESC
+	)
+	SELECT
+		c.segment_slug,
+		c.auto_percent,
+		CASE WHEN m.operation = ANY($5::text[]) THEN m.expires_at END,
+		COALESCE(m.operation = ANY($5::text[]), FALSE),
+		CASE WHEN c.rollout_steps IS NULL THEN s.rollout_paused_at ELSE c.rollout_paused_at END,
+		CASE WHEN c.rollout_steps IS NULL THEN
+			(SELECT jsonb_agg(jsonb_build_object('percent', rs.percent, 'starts_at', rs.starts_at) ORDER BY rs.starts_at)
+			 FROM segment_rollout_steps rs WHERE rs.segment_slug = s.slug)
+		ELSE c.rollout_steps END,
+		c.active_from,
+		c.active_until,
+		COALESCE(CASE WHEN c.rollout_steps IS NULL THEN s.layer_slug ELSE c.layer_slug END, ''),
+		COALESCE(CASE WHEN c.rollout_steps IS NULL THEN s.layer_offset ELSE c.layer_offset END, 0),
+		c.targeting_rule,
+		CASE o.operation WHEN $6::text THEN $7::text WHEN $8::text THEN $9::text ELSE '' END,
+		c.hash_salt
+	FROM config c
+	-- Сегмент, пересозданный после $2, к восстанавливаемому моменту отношения не имеет
+	LEFT JOIN segments s ON s.slug = c.segment_slug AND s.created_at <= $2
+	LEFT JOIN manual m ON m.segment_slug = c.segment_slug
+	LEFT JOIN override o ON o.segment_slug = c.segment_slug
+	WHERE NOT c.deleted
+	ORDER BY c.segment_slug
+`
+
+// GetSegmentsDataAsOf восстанавливает данные сегментов пользователя на момент asOf по истории.
+// Срок действия ручного назначения берётся из последней операции ADDED или TTL_UPDATED, поэтому
+// назначение, истёкшее до asOf, не выдаётся и до записи EXPIRED фоновой очисткой; у записей,
+// сделанных до историзации срока, он неизвестен, и истечение учитывается по EXPIRED. Операции AUTO_* и RESHUFFLE_* в ручные
+// назначения не входят: автоматическое членство, как и в текущем ответе, вычисляется по
+// действовавшей конфигурации.
+func (r *pgxSegmentRepo) GetSegmentsDataAsOf(ctx context.Context, userID int64, asOf time.Time) ([]model.SegmentUserDataDTO, error) {
+	manualOps := []string{model.OperationAdded, model.OperationTTLUpdated, model.OperationRemoved, model.OperationExpired}
+	assignedOps := []string{model.OperationAdded, model.OperationTTLUpdated}
+	overrideOps := []string{model.OperationIncludeAdded, model.OperationIncludeRemoved, model.OperationExcludeAdded, model.OperationExcludeRemoved}
+	rows, err := r.db.QueryContext(ctx, segmentsAsOfQuery, userID, asOf, manualOps, overrideOps,
+		assignedOps,
+		model.OperationIncludeAdded, model.OverrideInclude,
+		model.OperationExcludeAdded, model.OverrideExclude)
+	if err != nil {
+		return nil, fmt.Errorf("db query failed: %w", err)
+	}
+	defer rows.Close()
+	return scanSegmentsData(rows)
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"slices"
+)
+
+// GetSegmentsConfig возвращает конфигурацию всех сегментов без назначений пользователей
+func (r *pgxSegmentRepo) GetSegmentsConfig(ctx context.Context) ([]model.SegmentUserDataDTO, error) {
+	// ID пользователей положительные, поэтому userID 0 не совпадает ни с одним назначением
+	return querySegmentsData(ctx, r.db, 0, "")
+}
+
+// GetUsersAssignments одним запросом выбирает ручные назначения (включая истёкшие, их
+// отбрасывает вычисление сегментов) и переопределения пользователей userIDs
+func (r *pgxSegmentRepo) GetUsersAssignments(ctx context.Context, userIDs []int64) ([]model.UserAssignmentDTO, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT user_id, segment_slug, TRUE, expires_at, '' FROM user_segments WHERE user_id = ANY($1::bigint[]) AND source = 'manual'
+		UNION ALL
+		SELECT user_id, segment_slug, FALSE, NULL, mode FROM segment_overrides WHERE user_id = ANY($1::bigint[])
+		`, userIDs)
+	if err != nil {
+		return nil, fmt.Errorf("db query failed for users assignments: %w", err)
+	}
+	defer rows.Close()
+	var assignments []model.UserAssignmentDTO
+	for rows.Next() {
+		var dto model.UserAssignmentDTO
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&dto.UserID, &dto.Slug, &dto.Manual, &expiresAt, &dto.Override); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		dto.ExpiresAt = nullTimeToPtr(expiresAt)
+		assignments = append(assignments, dto)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", apperror.ErrDuringRowsIteration, err)
+	}
+	return assignments, nil
+}
+
+// ApplyUserAssignments возвращает копию каталога config с ручными назначениями и переопределениями
+// одного пользователя; назначения сегментов, которых нет в каталоге, пропускаются
+func ApplyUserAssignments(config []model.SegmentUserDataDTO, assignments []model.UserAssignmentDTO) []model.SegmentUserDataDTO {
+	segments := slices.Clone(config)
+	if len(assignments) == 0 {
+		return segments
+	}
+	position := make(map[string]int, len(segments))
+	for i, segment := range segments {
+		position[segment.Slug] = i
+	}
+	for _, assignment := range assignments {
+		i, ok := position[assignment.Slug]
+		if !ok {
+			continue
+		}
+		if assignment.Manual {
+			segments[i].IsManuallyAssigned = true
+			segments[i].ExpiresAt = assignment.ExpiresAt
+		} else {
+			segments[i].Override = assignment.Override
+		}
+	}
+	return segments
+}
+
+// GetUsersAttributes возвращает сохранённые атрибуты пользователей userIDs; неизвестных
+// пользователей в результате нет
+func (r *pgxSegmentRepo) GetUsersAttributes(ctx context.Context, userIDs []int64) (map[int64]map[string]any, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT user_id, attributes FROM users WHERE user_id = ANY($1::bigint[])", userIDs)
+	if err != nil {
+		return nil, fmt.Errorf("db query failed: %w", err)
+	}
+	defer rows.Close()
+	result := make(map[int64]map[string]any)
+	for rows.Next() {
+		var userID int64
+		var raw []byte
+		if err := rows.Scan(&userID, &raw); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		attrs := make(map[string]any)
+		if err := json.Unmarshal(raw, &attrs); err != nil {
+			return nil, fmt.Errorf("failed to decode user attributes: %w", err)
+		}
+		result[userID] = attrs
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", apperror.ErrDuringRowsIteration, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log/slog"
+	"progression1/internal/model"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// segmentsChannel — канал NOTIFY, в который триггеры на segments и segment_rollout_steps
+// сообщают об изменении каталога
+const segmentsChannel = "segments_changed"
+
+// segmentCacheListenRetry — пауза перед повторной подпиской после обрыва соединения
+const segmentCacheListenRetry = 5 * time.Second
+
+// SegmentCache хранит конфигурацию всех сегментов (без назначений пользователей) в памяти
+// процесса. Кэш сбрасывается по NOTIFY segments_changed от любой реплики и, на случай
+// пропущенного уведомления, по истечении ttl.
+type SegmentCache struct {
+	ttl time.Duration
+
+	mu       sync.RWMutex
+	segments []model.SegmentUserDataDTO
+	loadedAt time.Time
+	// generation растёт при каждом сбросе; загрузка, начатая до сброса, не сохраняется
+	generation uint64
+	// loading — текущая загрузка каталога; одновременные промахи ждут её, а не идут в базу сами
+	loading *segmentCacheLoad
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	invalidations atomic.Int64
+}
+
+func NewSegmentCache(ttl time.Duration) *SegmentCache {
+	return &SegmentCache{ttl: ttl}
+}
+
+// segmentCacheLoad — загрузка каталога, общая для всех промахов одного поколения кэша
+type segmentCacheLoad struct {
+	done     chan struct{}
+	segments []model.SegmentUserDataDTO
+	err      error
+}
+
+// get возвращает копию каталога из кэша или загружает его через load. Одновременные промахи
+// разделяют одну загрузку; она не прерывается отменой ctx отдельного запроса.
+func (c *SegmentCache) get(ctx context.Context, load func(context.Context) ([]model.SegmentUserDataDTO, error)) ([]model.SegmentUserDataDTO, error) {
+	c.mu.RLock()
+	segments, loadedAt := c.segments, c.loadedAt
+	c.mu.RUnlock()
+	if segments != nil && time.Since(loadedAt) < c.ttl {
+		c.hits.Add(1)
+		return slices.Clone(segments), nil
+	}
+	c.misses.Add(1)
+	c.mu.Lock()
+	flight := c.loading
+	if flight == nil {
+		flight = &segmentCacheLoad{done: make(chan struct{})}
+		c.loading = flight
+		go c.load(context.WithoutCancel(ctx), flight, c.generation, load)
+	}
+	c.mu.Unlock()
+	select {
+	case <-flight.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if flight.err != nil {
+		return nil, flight.err
+	}
+	return slices.Clone(flight.segments), nil
+}
+
+func (c *SegmentCache) load(ctx context.Context, flight *segmentCacheLoad, generation uint64, load func(context.Context) ([]model.SegmentUserDataDTO, error)) {
+	defer close(flight.done)
+	loaded, err := load(ctx)
+	// Пустой каталог тоже кэшируется: nil означает «не загружен»
+	if err == nil && loaded == nil {
+		loaded = []model.SegmentUserDataDTO{}
+	}
+	flight.segments, flight.err = loaded, err
+	c.mu.Lock()
+	if c.loading == flight {
+		c.loading = nil
+	}
+	if err == nil && c.generation == generation {
+		c.segments, c.loadedAt = loaded, time.Now()
+	}
+	c.mu.Unlock()
+}
+
+// Invalidate сбрасывает кэш; следующее чтение загрузит каталог из базы
+func (c *SegmentCache) Invalidate() {
+	c.mu.Lock()
+	c.segments = nil
+	c.generation++
+	// Загрузка, начатая до сброса, может вернуть устаревший каталог: новые промахи её не ждут
+	c.loading = nil
+	c.mu.Unlock()
+	c.invalidations.Add(1)
+}
+
+// Stats возвращает счётчики попаданий, промахов и сбросов кэша
+func (c *SegmentCache) Stats() model.SegmentCacheStatsDTO {
+	stats := model.SegmentCacheStatsDTO{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Invalidations: c.invalidations.Load(),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.Hit_ratio = float64(stats.Hits) / float64(total)
+	}
+	c.mu.RLock()
+	stats.Segments = len(c.segments)
+	c.mu.RUnlock()
+	return stats
+}
+
+// Listen подписывается на segments_changed на отдельном соединении и сбрасывает кэш при каждом
+// уведомлении. После обрыва подписка восстанавливается, а кэш сбрасывается, так как уведомления
+// за это время потеряны. Возвращается после отмены ctx.
+func (c *SegmentCache) Listen(ctx context.Context, db *sql.DB) {
+	slog.Default().Info("segment cache listener started", "channel", segmentsChannel)
+	for {
+		err := c.listen(ctx, db)
+		if ctx.Err() != nil {
+			slog.Default().Info("segment cache listener stopped")
+			return
+		}
+		slog.Default().Error("segment cache listener failed", "error", err)
+		select {
+		case <-ctx.Done():
+			slog.Default().Info("segment cache listener stopped")
+			return
+		case <-time.After(segmentCacheListenRetry):
+		}
+	}
+}
+
+func (c *SegmentCache) listen(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire listener connection: %w", err)
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn any) error {
+		pgxConn := driverConn.(*stdlib.Conn).Conn()
+		if _, err := pgxConn.Exec(ctx, "LISTEN "+segmentsChannel); err != nil {
+			return fmt.Errorf("%w: listen failed: %w", driver.ErrBadConn, err)
+		}
+		// Изменения, сделанные до подписки, могли пройти мимо кэша
+		c.Invalidate()
+		for {
+			if _, err := pgxConn.WaitForNotification(ctx); err != nil {
+				// Соединение с подпиской не возвращается в пул
+				return fmt.Errorf("%w: %w", driver.ErrBadConn, err)
+			}
+			c.Invalidate()
+		}
+	})
+}
+
+// cachedSegmentRepo читает каталог сегментов из SegmentCache; остальные методы делегируются repo.
+// Изменения сегментов через этот репозиторий сбрасывают кэш сразу, не дожидаясь NOTIFY.
+type cachedSegmentRepo struct {
+	SegmentRepo
+	cache *SegmentCache
+}
+
+func NewCachedSegmentRepo(repo SegmentRepo, cache *SegmentCache) SegmentRepo {
+	return &cachedSegmentRepo{SegmentRepo: repo, cache: cache}
+}
+
+func (r *cachedSegmentRepo) GetSegmentsConfig(ctx context.Context) ([]model.SegmentUserDataDTO, error) {
+	return r.cache.get(ctx, r.SegmentRepo.GetSegmentsConfig)
+}
+
+// GetAllSegmentsData собирает сегменты пользователя из закэшированного каталога и его назначений,
+// прочитанных по ключу user_id, вместо соединения всей таблицы segments
+func (r *cachedSegmentRepo) GetAllSegmentsData(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error) {
+	segments, err := r.GetSegmentsConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	assignments, err := r.SegmentRepo.GetUsersAssignments(ctx, []int64{userID})
+	if err != nil {
+		return nil, err
+	}
+	return ApplyUserAssignments(segments, assignments), nil
+}
+
+func (r *cachedSegmentRepo) CreateSegment(ctx context.Context, segment model.SegmentDTO, audit model.AuditDTO) error {
+	defer r.cache.Invalidate()
+	return r.SegmentRepo.CreateSegment(ctx, segment, audit)
+}
+
+func (r *cachedSegmentRepo) DeleteSegment(ctx context.Context, slug string, audit model.AuditDTO) error {
+	defer r.cache.Invalidate()
+	return r.SegmentRepo.DeleteSegment(ctx, slug, audit)
+}
+
+func (r *cachedSegmentRepo) UpdateSegment(ctx context.Context, slug string, patch model.SegmentPatchDTO) error {
+	defer r.cache.Invalidate()
+	return r.SegmentRepo.UpdateSegment(ctx, slug, patch)
+}
+
+func (r *cachedSegmentRepo) ReshuffleSegment(ctx context.Context, slug string, audit model.AuditDTO, batchSize int, changes ReshuffleChangesFunc) (model.ReshuffleResultDTO, error) {
+	defer r.cache.Invalidate()
+	return r.SegmentRepo.ReshuffleSegment(ctx, slug, audit, batchSize, changes)
+}
+
+func (r *cachedSegmentRepo) SetRolloutSteps(ctx context.Context, slug string, steps []model.RolloutStepDTO) error {
+	defer r.cache.Invalidate()
+	return r.SegmentRepo.SetRolloutSteps(ctx, slug, steps)
+}
+
+func (r *cachedSegmentRepo) SetRolloutPaused(ctx context.Context, slug string, paused bool) error {
+	defer r.cache.Invalidate()
+	return r.SegmentRepo.SetRolloutPaused(ctx, slug, paused)
+}
@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"time"
+)
+
+// ImportUserSegments одним запросом добавляет пользователей userIDs во все сегменты slugs
+// (существующим назначениям обновляется expires_at), регистрирует пользователей в реестре
+// и пишет операции ADDED в историю
+func (r *pgxSegmentRepo) ImportUserSegments(ctx context.Context, userIDs []int64, slugs []string, expiresAt *time.Time, audit model.AuditDTO) error {
+	if _, err := r.db.ExecContext(ctx, `
+		WITH ids AS (SELECT DISTINCT unnest($1::bigint[]) AS user_id),
+		known AS (INSERT INTO users(user_id) SELECT user_id FROM ids ON CONFLICT DO NOTHING),
+		assigned AS (
+			INSERT INTO user_segments(user_id, segment_slug, expires_at)
+			SELECT ids.user_id, slug, $3 FROM ids CROSS JOIN unnest($2::text[]) AS slug
+			ON CONFLICT (user_id, segment_slug) DO UPDATE SET expires_at = EXCLUDED.expires_at, source = 'manual'
+			RETURNING user_id, segment_slug)
+		INSERT INTO user_segment_history(user_id, segment_slug, operation, actor, reason, expires_at)
+		SELECT user_id, segment_slug, $4, $5, $6, $3 FROM assigned
+		`, userIDs, slugs, expiresAt, model.OperationAdded, audit.Actor, audit.Reason); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+	}
+	return nil
+}
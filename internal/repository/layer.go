@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+)
+
+// Запрос диапазонов сегментов слоя: занятая ширина — максимум из auto_percent и шагов плана раскатки.
+// Сегменты слоя занимают целое число процентов, поэтому auto_percent приводится к целому
+const layerSegmentsQuery = `
+	SELECT s.slug, s.layer_offset, COALESCE(s.auto_percent, 0)::int,
+	       GREATEST(COALESCE(s.auto_percent, 0)::int,
+	                COALESCE((SELECT CEIL(MAX(rs.percent))::int FROM segment_rollout_steps rs WHERE rs.segment_slug = s.slug), 0))
+	FROM segments s
+	WHERE s.layer_slug = $1
+	ORDER BY s.layer_offset, s.slug
+`
+
+// LayerRangeFree проверяет, что диапазон [offset, offset+width) помещается в слой
+// и не пересекается с диапазонами других сегментов слоя
+func LayerRangeFree(segments []model.LayerSegmentDTO, self string, offset, width int) bool {
+	if offset < 0 || offset+width > 100 {
+		return false
+	}
+	for _, segment := range segments {
+		if segment.Segment_slug == self || segment.Max_percent == 0 || width == 0 {
+			continue
+		}
+		if offset < segment.Layer_offset+segment.Max_percent && segment.Layer_offset < offset+width {
+			return false
+		}
+	}
+	return true
+}
+
+// AllocateLayerOffset ищет наименьшее смещение, начиная с которого width бакетов свободны
+func AllocateLayerOffset(segments []model.LayerSegmentDTO, self string, width int) (int, bool) {
+	for offset := 0; offset+width <= 100 && offset < 100; offset++ {
+		if LayerRangeFree(segments, self, offset, width) {
+			return offset, true
+		}
+	}
+	return 0, false
+}
+
+// lockLayerSegments блокирует строку слоя до конца транзакции и читает диапазоны его сегментов.
+// Размещение и расширение сегментов одного слоя идут строго по очереди, поэтому параллельные
+// запросы не занимают пересекающиеся диапазоны
+func lockLayerSegments(ctx context.Context, tx *sql.Tx, layer string) ([]model.LayerSegmentDTO, error) {
+	var id int
+	err := tx.QueryRowContext(ctx, "SELECT id FROM layers WHERE slug = $1 FOR UPDATE", layer).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperror.ErrLayerNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("db query failed: %w", err)
+	}
+	rows, err := tx.QueryContext(ctx, layerSegmentsQuery, layer)
+	if err != nil {
+		return nil, fmt.Errorf("db query failed: %w", err)
+	}
+	defer rows.Close()
+	return scanLayerSegments(rows)
+}
+
+// placeInLayer под блокировкой слоя проверяет явное смещение нового сегмента
+// или выбирает для него наименьшее свободное
+func placeInLayer(ctx context.Context, tx *sql.Tx, segment model.SegmentDTO) (int, error) {
+	segments, err := lockLayerSegments(ctx, tx, segment.Layer)
+	if err != nil {
+		return 0, err
+	}
+	width := 0
+	if segment.Auto_percent != nil {
+		width = int(*segment.Auto_percent)
+	}
+	if segment.Layer_offset != nil {
+		if !LayerRangeFree(segments, segment.Slug, *segment.Layer_offset, width) {
+			return 0, apperror.ErrLayerOverflow
+		}
+		return *segment.Layer_offset, nil
+	}
+	offset, ok := AllocateLayerOffset(segments, segment.Slug, width)
+	if !ok {
+		return 0, apperror.ErrLayerOverflow
+	}
+	return offset, nil
+}
+
+// checkLayerRange под блокировкой слоя проверяет, что изменённый в транзакции сегмент slug
+// с учётом auto_percent и шагов раскатки не пересекается с другими сегментами слоя
+func checkLayerRange(ctx context.Context, tx *sql.Tx, layer, slug string) error {
+	segments, err := lockLayerSegments(ctx, tx, layer)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment.Segment_slug == slug && !LayerRangeFree(segments, slug, segment.Layer_offset, segment.Max_percent) {
+			return apperror.ErrLayerOverflow
+		}
+	}
+	return nil
+}
+
+func (r *pgxSegmentRepo) CreateLayer(ctx context.Context, layer model.LayerDTO) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO layers(slug, description) VALUES($1, $2)", layer.Slug, layer.Description)
+	return err
+}
+
+func (r *pgxSegmentRepo) GetLayers(ctx context.Context) ([]model.LayerDTO, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT slug, description, created_at FROM layers ORDER BY slug")
+	if err != nil {
+		return nil, fmt.Errorf("db query failed: %w", err)
+	}
+	defer rows.Close()
+	var layers []model.LayerDTO
+	for rows.Next() {
+		var layer model.LayerDTO
+		if err := rows.Scan(&layer.Slug, &layer.Description, &layer.Created_at); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		layers = append(layers, layer)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", apperror.ErrDuringRowsIteration, err)
+	}
+	for i := range layers {
+		segments, err := r.GetLayerSegments(ctx, layers[i].Slug)
+		if err != nil {
+			return nil, err
+		}
+		layers[i].Segments = segments
+	}
+	return layers, nil
+}
+
+func (r *pgxSegmentRepo) LayerExists(ctx context.Context, slug string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM layers WHERE slug = $1)", slug).Scan(&exists)
+	return exists, err
+}
+
+func (r *pgxSegmentRepo) GetLayerSegments(ctx context.Context, layer string) ([]model.LayerSegmentDTO, error) {
+	rows, err := r.db.QueryContext(ctx, layerSegmentsQuery, layer)
+	if err != nil {
+		return nil, fmt.Errorf("db query failed: %w", err)
+	}
+	defer rows.Close()
+	return scanLayerSegments(rows)
+}
+
+func scanLayerSegments(rows *sql.Rows) ([]model.LayerSegmentDTO, error) {
+	var segments []model.LayerSegmentDTO
+	for rows.Next() {
+		var dto model.LayerSegmentDTO
+		if err := rows.Scan(&dto.Segment_slug, &dto.Layer_offset, &dto.Auto_percent, &dto.Max_percent); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		segments = append(segments, dto)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", apperror.ErrDuringRowsIteration, err)
+	}
+	return segments, nil
+}
+
+// GetSegmentLayer возвращает слой сегмента или пустую строку, если сегмент вне слоёв
+func (r *pgxSegmentRepo) GetSegmentLayer(ctx context.Context, slug string) (string, error) {
+	var layer sql.NullString
+	err := r.db.QueryRowContext(ctx, "SELECT layer_slug FROM segments WHERE slug = $1", slug).Scan(&layer)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", apperror.ErrSegmentNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("db query failed: %w", err)
+	}
+	return layer.String, nil
+}
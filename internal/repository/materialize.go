@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+)
+
+// GetMaterializations возвращает состояние материализации всех сегментов, созданных с materialize
+func (r *pgxSegmentRepo) GetMaterializations(ctx context.Context) ([]model.MaterializationDTO, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT segment_slug, materialize_key, cursor FROM segment_materializations ORDER BY segment_slug")
+	if err != nil {
+		return nil, fmt.Errorf("db query failed: %w", err)
+	}
+	defer rows.Close()
+	var materializations []model.MaterializationDTO
+	for rows.Next() {
+		var dto model.MaterializationDTO
+		var key sql.NullString
+		var cursor sql.NullInt64
+		if err := rows.Scan(&dto.Slug, &key, &cursor); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		dto.Key = key.String
+		if cursor.Valid {
+			dto.Cursor = &cursor.Int64
+		}
+		materializations = append(materializations, dto)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", apperror.ErrDuringRowsIteration, err)
+	}
+	return materializations, nil
+}
+
+// StartMaterialization начинает новый проход по реестру пользователей с конфигурацией key.
+// Возвращает false, если состояние уже изменила другая реплика
+func (r *pgxSegmentRepo) StartMaterialization(ctx context.Context, slug, previousKey, key string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE segment_materializations SET materialize_key = $3, cursor = 0, updated_at = NOW()
+		WHERE segment_slug = $1 AND COALESCE(materialize_key, '') = $2
+		`, slug, previousKey, key)
+	if err != nil {
+		return false, fmt.Errorf("db update failed: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("db update failed: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// ApplyMaterialization в одной транзакции сдвигает курсор прохода и приводит автоматические
+// строки сегмента в диапазоне страницы к page.Members: недостающие добавляются (AUTO_ADDED),
+// лишние удаляются (AUTO_REMOVED). Ручные назначения не меняются. Возвращает false без
+// изменений, если курсор или конфигурация прохода уже не совпадают со страницей
+func (r *pgxSegmentRepo) ApplyMaterialization(ctx context.Context, page model.MaterializationPageDTO) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", apperror.ErrFailedBTransaction, err)
+	}
+	defer tx.Rollback()
+	// NULL вместо пустого массива сделал бы условие <> ALL неопределённым
+	members := page.Members
+	if members == nil {
+		members = []int64{}
+	}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE segment_materializations
+		SET cursor = CASE WHEN $5 THEN NULL ELSE $4::bigint END, updated_at = NOW()
+		WHERE segment_slug = $1 AND materialize_key = $2 AND cursor = $3
+		`, page.Slug, page.Key, page.AfterID, page.LastID, page.Done)
+	if err != nil {
+		return false, fmt.Errorf("db update failed: %w", err)
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return false, fmt.Errorf("db update failed: %w", err)
+	} else if affected == 0 {
+		return false, nil
+	}
+	// На последней странице удаляются и строки пользователей после неё
+	if _, err := tx.ExecContext(ctx, `
+		WITH removed AS (
+			DELETE FROM user_segments
+			WHERE segment_slug = $1 AND source = 'auto' AND user_id > $2 AND ($4 OR user_id <= $3)
+			  AND user_id <> ALL($5::bigint[])
+			RETURNING user_id, segment_slug)
+		INSERT INTO user_segment_history(user_id, segment_slug, operation, actor, reason)
+		SELECT user_id, segment_slug, $6, $7, '' FROM removed
+		`, page.Slug, page.AfterID, page.LastID, page.Done, members, model.OperationAutoRemoved, model.ActorMaterializer); err != nil {
+		return false, fmt.Errorf("%w: %w", apperror.ErrCannotDeleteFT, err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		WITH added AS (
+			INSERT INTO user_segments(user_id, segment_slug, source)
+			SELECT unnest($2::bigint[]), $1, 'auto'
+			ON CONFLICT (user_id, segment_slug) DO NOTHING
+			RETURNING user_id, segment_slug)
+		INSERT INTO user_segment_history(user_id, segment_slug, operation, actor, reason)
+		SELECT user_id, segment_slug, $3, $4, '' FROM added
+		`, page.Slug, members, model.OperationAutoAdded, model.ActorMaterializer); err != nil {
+		return false, fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("%w: %w", apperror.ErrFailedCTransaction, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+)
+
+// StreamSegmentMembers передаёт в fn действующие ручные назначения сегмента по возрастанию
+// user_id, начиная после afterUserID; limit 0 — без ограничения. Ошибка fn прерывает чтение.
+func (r *pgxSegmentRepo) StreamSegmentMembers(ctx context.Context, slug string, afterUserID int64, limit int, fn func(model.SegmentMemberDTO) error) error {
+	var limitArg any
+	if limit > 0 {
+		limitArg = limit
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT user_id, assigned_at, expires_at
+		FROM user_segments
+		WHERE segment_slug = $1 AND source = 'manual' AND user_id > $2 AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY user_id
+		LIMIT $3
+		`, slug, afterUserID, limitArg)
+	if err != nil {
+		return fmt.Errorf("db query failed for segment members: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var dto model.SegmentMemberDTO
+		var assignedAt, expiresAt sql.NullTime
+		if err := rows.Scan(&dto.User_id, &assignedAt, &expiresAt); err != nil {
+			return fmt.Errorf("scan failed: %w", err)
+		}
+		dto.Assigned_at = nullTimeToPtr(assignedAt)
+		dto.Expires_at = nullTimeToPtr(expiresAt)
+		if err := fn(dto); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrDuringRowsIteration, err)
+	}
+	return nil
+}
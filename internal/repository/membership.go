@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+)
+
+// GetUserSegmentData возвращает конфигурацию одного сегмента с назначением и переопределением
+// пользователя в порядке колонок querySegmentsData. В отличие от GetAllSegmentsData все
+// соединения идут по ключам (segments.slug, PK user_segments и segment_overrides), поэтому
+// запрос читает не больше одной строки каждой таблицы.
+func (r *pgxSegmentRepo) GetUserSegmentData(ctx context.Context, userID int64, slug string) (model.SegmentUserDataDTO, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT
+			s.slug,
+			s.auto_percent,
+			us.expires_at,
+			us.user_id IS NOT NULL,
+			s.rollout_paused_at,
+			(SELECT json_agg(json_build_object('percent', rs.percent, 'starts_at', rs.starts_at) ORDER BY rs.starts_at)
+			 FROM segment_rollout_steps rs WHERE rs.segment_slug = s.slug),
+			s.active_from,
+			s.active_until,
+			COALESCE(s.layer_slug, ''),
+			s.layer_offset,
+			s.targeting_rule,
+			COALESCE(so.mode, ''),
+			s.hash_salt
+		FROM segments s
+		LEFT JOIN user_segments us ON us.user_id = $1 AND us.segment_slug = s.slug AND us.source = 'manual'
+		LEFT JOIN segment_overrides so ON so.segment_slug = s.slug AND so.user_id = $1
+		WHERE s.slug = $2
+		`, userID, slug)
+	if err != nil {
+		return model.SegmentUserDataDTO{}, fmt.Errorf("db query failed: %w", err)
+	}
+	defer rows.Close()
+	segments, err := scanSegmentsData(rows)
+	if err != nil {
+		return model.SegmentUserDataDTO{}, err
+	}
+	if len(segments) == 0 {
+		return model.SegmentUserDataDTO{}, apperror.ErrSegmentNotFound
+	}
+	return segments[0], nil
+}
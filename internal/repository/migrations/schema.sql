@@ -1,24 +1,233 @@
--- Таблица сегментов
-CREATE TABLE IF NOT EXISTS segments (
-    id SERIAL PRIMARY KEY,
-    slug TEXT UNIQUE NOT NULL,
-    auto_percent INTEGER NULL CHECK (auto_percent >= 0 AND auto_percent <= 100)
-);
-
--- Таблица связей пользователей и сегментов
-CREATE TABLE IF NOT EXISTS user_segments (
-    user_id BIGINT NOT NULL,
-    segment_id INTEGER NOT NULL REFERENCES segments(id) ON DELETE CASCADE,
-    assigned_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-    expires_at TIMESTAMP WITH TIME ZONE NULL,
-    PRIMARY KEY (user_id, segment_id)
-);
-
--- Таблица для истории операций
-CREATE TABLE IF NOT EXISTS operation_history (
-    id SERIAL PRIMARY KEY,
-    user_id BIGINT NOT NULL,
-    segment_slug TEXT NOT NULL,
-    operation_type TEXT NOT NULL, -- 'ADDED' или 'REMOVED'
-    operation_time TIMESTAMP WITH TIME ZONE DEFAULT NOW()
-);
\ No newline at end of file
+-- Таблица сегментов
+CREATE TABLE IF NOT EXISTS segments (
+    id SERIAL PRIMARY KEY,
+    slug TEXT UNIQUE NOT NULL,
+    auto_percent INTEGER NULL CHECK (auto_percent >= 0 AND auto_percent <= 100)
+);
+
+-- Метаданные сегмента: назначение, владелец и теги
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT '';
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW();
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW();
+CREATE INDEX IF NOT EXISTS segments_tags_idx ON segments USING GIN (tags);
+
+-- Таблица связей пользователей и сегментов
+CREATE TABLE IF NOT EXISTS user_segments (
+    user_id BIGINT NOT NULL,
+    segment_id INTEGER NOT NULL REFERENCES segments(id) ON DELETE CASCADE,
+    assigned_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NULL,
+    PRIMARY KEY (user_id, segment_id)
+);
+
+-- Назначения ссылаются на сегмент по slug вместо id: пока есть колонка segment_id,
+-- она заменяется на segment_slug, заполненный по segments
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_schema = current_schema() AND table_name = 'user_segments' AND column_name = 'segment_id') THEN
+        ALTER TABLE user_segments ADD COLUMN IF NOT EXISTS segment_slug TEXT NULL REFERENCES segments(slug) ON DELETE CASCADE;
+        UPDATE user_segments us SET segment_slug = s.slug FROM segments s WHERE s.id = us.segment_id;
+        ALTER TABLE user_segments DROP CONSTRAINT user_segments_pkey;
+        ALTER TABLE user_segments DROP COLUMN segment_id;
+        ALTER TABLE user_segments ALTER COLUMN segment_slug SET NOT NULL;
+        ALTER TABLE user_segments ADD PRIMARY KEY (user_id, segment_slug);
+    END IF;
+END
+$$;
+
+-- Таблица для истории операций. В базах, созданных до переименования, она называлась
+-- operation_history (operation_type, operation_time) и переименовывается на месте; если новая
+-- таблица уже создана рядом со старой, записи старой переносятся в исходном порядке
+DO $$
+BEGIN
+    IF to_regclass('operation_history') IS NOT NULL THEN
+        IF to_regclass('user_segment_history') IS NULL THEN
+            ALTER TABLE operation_history RENAME COLUMN operation_type TO operation;
+            ALTER TABLE operation_history RENAME COLUMN operation_time TO created_at;
+            ALTER TABLE operation_history RENAME TO user_segment_history;
+        ELSE
+            INSERT INTO user_segment_history(user_id, segment_slug, operation, created_at)
+            SELECT user_id, segment_slug, operation_type, operation_time FROM operation_history ORDER BY id;
+            DROP TABLE operation_history;
+        END IF;
+    END IF;
+END
+$$;
+CREATE TABLE IF NOT EXISTS user_segment_history (
+    id SERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    segment_slug TEXT NOT NULL,
+    operation TEXT NOT NULL, -- 'ADDED' или 'REMOVED'
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Аудит изменений конфигурации сегментов (auto_percent и т.д.)
+CREATE TABLE IF NOT EXISTS segment_config_history (
+    id SERIAL PRIMARY KEY,
+    segment_slug TEXT NOT NULL,
+    previous_auto_percent INTEGER NULL,
+    auto_percent INTEGER NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS segment_config_history_slug_idx ON segment_config_history (segment_slug, created_at);
+
+-- План раскатки: шаги auto_percent по расписанию
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS rollout_paused_at TIMESTAMP WITH TIME ZONE NULL;
+CREATE TABLE IF NOT EXISTS segment_rollout_steps (
+    segment_slug TEXT NOT NULL REFERENCES segments(slug) ON DELETE CASCADE,
+    percent INTEGER NOT NULL CHECK (percent >= 0 AND percent <= 100),
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (segment_slug, starts_at)
+);
+
+-- Окно активности сегмента: вне окна сегмент не выдаётся ни вручную, ни по auto_percent
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS active_from TIMESTAMP WITH TIME ZONE NULL;
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS active_until TIMESTAMP WITH TIME ZONE NULL;
+ALTER TABLE segment_config_history ADD COLUMN IF NOT EXISTS active_from TIMESTAMP WITH TIME ZONE NULL;
+ALTER TABLE segment_config_history ADD COLUMN IF NOT EXISTS active_until TIMESTAMP WITH TIME ZONE NULL;
+
+-- Слои взаимоисключающих экспериментов: пользователь попадает максимум в один сегмент слоя
+CREATE TABLE IF NOT EXISTS layers (
+    id SERIAL PRIMARY KEY,
+    slug TEXT UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+-- Сегмент слоя занимает бакеты [layer_offset, layer_offset + auto_percent) общего пространства слоя
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS layer_slug TEXT NULL REFERENCES layers(slug);
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS layer_offset INTEGER NOT NULL DEFAULT 0 CHECK (layer_offset >= 0 AND layer_offset < 100);
+CREATE INDEX IF NOT EXISTS segments_layer_idx ON segments (layer_slug);
+
+-- Правило таргетинга по атрибутам пользователя (JSON: {"all": [{"attribute", "op", "value"/"values"}]})
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS targeting_rule JSONB NULL;
+ALTER TABLE segment_config_history ADD COLUMN IF NOT EXISTS targeting_rule JSONB NULL;
+
+-- Реестр пользователей с атрибутами для правил таргетинга
+CREATE TABLE IF NOT EXISTS users (
+    user_id BIGINT PRIMARY KEY,
+    attributes JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Разовые заполнения данных: имя выполненного заполнения записывается, и при следующих запусках оно пропускается
+CREATE TABLE IF NOT EXISTS schema_backfills (
+    name TEXT PRIMARY KEY,
+    applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+-- Пользователи, уже назначенные в сегменты, считаются известными. Полный проход по user_segments
+-- выполняется один раз; дальше реестр пополняют сами назначения
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM schema_backfills WHERE name = 'users_from_user_segments') THEN
+        INSERT INTO users(user_id) SELECT DISTINCT user_id FROM user_segments ON CONFLICT DO NOTHING;
+        INSERT INTO schema_backfills(name) VALUES ('users_from_user_segments');
+    END IF;
+END
+$$;
+
+-- Явные включения (INCLUDE) и исключения (EXCLUDE) пользователей в сегмент поверх auto_percent
+CREATE TABLE IF NOT EXISTS segment_overrides (
+    segment_slug TEXT NOT NULL REFERENCES segments(slug) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL,
+    mode TEXT NOT NULL CHECK (mode IN ('INCLUDE', 'EXCLUDE')),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (segment_slug, user_id)
+);
+
+-- Соль бакетирования сегмента; пустая строка сохраняет исторический бакет по "userID:slug"
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS hash_salt TEXT NOT NULL DEFAULT '';
+
+-- auto_percent с точностью до 0.01% (базисный пункт); целые значения сохраняют прежнюю выборку
+ALTER TABLE segments ALTER COLUMN auto_percent TYPE NUMERIC(5, 2);
+ALTER TABLE segment_config_history ALTER COLUMN previous_auto_percent TYPE NUMERIC(5, 2);
+ALTER TABLE segment_config_history ALTER COLUMN auto_percent TYPE NUMERIC(5, 2);
+
+-- Поиск назначений с истёкшим TTL фоновой очисткой
+CREATE INDEX IF NOT EXISTS user_segments_expires_at_idx ON user_segments (expires_at) WHERE expires_at IS NOT NULL;
+
+-- Постраничное чтение истории по ключу (created_at, id), в том числе с фильтром по пользователю или сегменту
+CREATE INDEX IF NOT EXISTS user_segment_history_created_at_idx ON user_segment_history (created_at, id);
+CREATE INDEX IF NOT EXISTS user_segment_history_user_idx ON user_segment_history (user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS user_segment_history_slug_idx ON user_segment_history (segment_slug, created_at, id);
+
+-- Кто и почему изменил членство (X-Actor и reason запроса); у старых записей пусто
+ALTER TABLE user_segment_history ADD COLUMN IF NOT EXISTS actor TEXT NOT NULL DEFAULT '';
+ALTER TABLE user_segment_history ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT '';
+-- Срок действия назначения после ADDED и TTL_UPDATED: as_of учитывает истечение TTL до записи EXPIRED.
+-- У старых записей срок не сохранён (NULL)
+ALTER TABLE user_segment_history ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE NULL;
+
+-- Соль и удаление сегмента в аудите конфигурации нужны для восстановления членства на момент времени (as_of).
+-- У записей до появления колонки соль пустая — это выборка до первого перемешивания
+ALTER TABLE segment_config_history ADD COLUMN IF NOT EXISTS hash_salt TEXT NOT NULL DEFAULT '';
+ALTER TABLE segment_config_history ADD COLUMN IF NOT EXISTS deleted BOOLEAN NOT NULL DEFAULT FALSE;
+-- Сегменты без записей аудита получают начальную запись на момент создания
+INSERT INTO segment_config_history(segment_slug, auto_percent, active_from, active_until, targeting_rule, hash_salt, created_at)
+SELECT s.slug, s.auto_percent, s.active_from, s.active_until, s.targeting_rule, s.hash_salt, s.created_at FROM segments s
+WHERE NOT EXISTS (SELECT 1 FROM segment_config_history h WHERE h.segment_slug = s.slug);
+
+-- Выгрузка участников сегмента по возрастанию user_id
+CREATE INDEX IF NOT EXISTS user_segments_slug_user_idx ON user_segments (segment_slug, user_id);
+
+-- Переопределения пользователя для пакетного вычисления сегментов
+CREATE INDEX IF NOT EXISTS segment_overrides_user_idx ON segment_overrides (user_id);
+
+-- Уведомление реплик об изменении каталога сегментов: кэш каталога сбрасывается по NOTIFY segments_changed
+CREATE OR REPLACE FUNCTION notify_segments_changed() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('segments_changed', TG_TABLE_NAME);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE TRIGGER segments_changed_notify
+    AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON segments
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_segments_changed();
+CREATE OR REPLACE TRIGGER segment_rollout_steps_changed_notify
+    AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON segment_rollout_steps
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_segments_changed();
+
+-- Источник назначения: manual — ручное, auto — материализованное по auto_percent фоновой задачей.
+-- Автоматические строки не дают членства сами по себе: оно по-прежнему вычисляется из конфигурации
+ALTER TABLE user_segments ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT 'manual' CHECK (source IN ('manual', 'auto'));
+
+-- Состояние фоновой материализации сегментов, созданных с materialize: true. materialize_key —
+-- конфигурация (соль, процент, слой, правило), по которой идёт или завершился проход по реестру
+-- users; cursor — последний обработанный user_id, NULL — проход завершён. Отдельная таблица,
+-- чтобы продвижение курсора не сбрасывало кэш каталога сегментов
+CREATE TABLE IF NOT EXISTS segment_materializations (
+    segment_slug TEXT PRIMARY KEY REFERENCES segments(slug) ON DELETE CASCADE,
+    materialize_key TEXT NULL,
+    cursor BIGINT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Назначения, последней операцией которых было AUTO_ADDED, созданы материализацией до появления source
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM schema_backfills WHERE name = 'user_segments_auto_source') THEN
+        UPDATE user_segments us SET source = 'auto'
+        WHERE us.expires_at IS NULL AND (
+            SELECT h.operation FROM user_segment_history h
+            WHERE h.user_id = us.user_id AND h.segment_slug = us.segment_slug
+            ORDER BY h.created_at DESC, h.id DESC LIMIT 1) = 'AUTO_ADDED';
+        INSERT INTO segment_materializations(segment_slug)
+        SELECT DISTINCT segment_slug FROM user_segments WHERE source = 'auto'
+        ON CONFLICT DO NOTHING;
+        INSERT INTO schema_backfills(name) VALUES ('user_segments_auto_source');
+    END IF;
+END
+$$;
+
+-- План раскатки, пауза и слой в аудите конфигурации для запросов as_of. rollout_steps NULL —
+-- запись сделана до появления колонок, для неё эти поля берутся из текущего сегмента
+ALTER TABLE segment_config_history ADD COLUMN IF NOT EXISTS rollout_steps JSONB NULL;
+ALTER TABLE segment_config_history ADD COLUMN IF NOT EXISTS rollout_paused_at TIMESTAMP WITH TIME ZONE NULL;
+ALTER TABLE segment_config_history ADD COLUMN IF NOT EXISTS layer_slug TEXT NULL;
+ALTER TABLE segment_config_history ADD COLUMN IF NOT EXISTS layer_offset INTEGER NULL;
+
+-- Шаги раскатки с точностью до 0.01%, как auto_percent
+ALTER TABLE segment_rollout_steps ALTER COLUMN percent TYPE NUMERIC(5, 2);
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+)
+
+func (r *pgxSegmentRepo) GetSegmentOverrides(ctx context.Context, slug string) (model.SegmentOverridesDTO, error) {
+	overrides := model.SegmentOverridesDTO{Segment_slug: slug, Include: []int64{}, Exclude: []int64{}}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT user_id, mode FROM segment_overrides
+		WHERE segment_slug = $1
+		ORDER BY user_id
+		`, slug)
+	if err != nil {
+		return overrides, fmt.Errorf("db query failed: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID int64
+		var mode string
+		if err := rows.Scan(&userID, &mode); err != nil {
+			return overrides, fmt.Errorf("scan failed: %w", err)
+		}
+		if mode == model.OverrideInclude {
+			overrides.Include = append(overrides.Include, userID)
+		} else {
+			overrides.Exclude = append(overrides.Exclude, userID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return overrides, fmt.Errorf("%w: %w", apperror.ErrDuringRowsIteration, err)
+	}
+	return overrides, nil
+}
+
+// UpdateSegmentOverrides применяет изменения переопределений и пишет каждое в историю
+// с отдельным типом операции (INCLUDE_ADDED, EXCLUDE_REMOVED и т.д.). Смена режима
+// пишется двумя записями: снятие прежнего режима и добавление нового.
+func (r *pgxSegmentRepo) UpdateSegmentOverrides(ctx context.Context, slug string, patch model.SegmentOverridesPatchDTO, audit model.AuditDTO) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrFailedBTransaction, err)
+	}
+	defer tx.Rollback()
+	// Возвращает прежний режим переопределения (NULL, если его не было)
+	stmtUpsert, err := tx.PrepareContext(ctx, `
+		WITH previous AS (
+			SELECT mode FROM segment_overrides WHERE segment_slug = $1 AND user_id = $2 FOR UPDATE)
+		INSERT INTO segment_overrides(segment_slug, user_id, mode) VALUES($1, $2, $3)
+		ON CONFLICT (segment_slug, user_id)
+		DO UPDATE SET mode = EXCLUDED.mode, created_at = NOW()
+		RETURNING (SELECT mode FROM previous)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare upsert statement: %w", err)
+	}
+	defer stmtUpsert.Close()
+	stmtRemove, err := tx.PrepareContext(ctx, "DELETE FROM segment_overrides WHERE segment_slug = $1 AND user_id = $2 RETURNING mode")
+	if err != nil {
+		return fmt.Errorf("failed to prepare remove statement: %w", err)
+	}
+	defer stmtRemove.Close()
+	stmtHistory, err := tx.PrepareContext(ctx, "INSERT INTO user_segment_history(user_id, segment_slug, operation, actor, reason) VALUES($1, $2, $3, $4, $5)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare history statement: %w", err)
+	}
+	defer stmtHistory.Close()
+	upserts := []struct {
+		userIDs   []int64
+		mode      string
+		operation string
+		// Операция снятия противоположного режима, если переопределение сменило режим
+		replaced string
+	}{
+		{patch.Include, model.OverrideInclude, model.OperationIncludeAdded, model.OperationExcludeRemoved},
+		{patch.Exclude, model.OverrideExclude, model.OperationExcludeAdded, model.OperationIncludeRemoved},
+	}
+	for _, upsert := range upserts {
+		for _, userID := range upsert.userIDs {
+			var previous sql.NullString
+			if err := stmtUpsert.QueryRowContext(ctx, slug, userID, upsert.mode).Scan(&previous); err != nil {
+				return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+			}
+			if previous.Valid && previous.String != upsert.mode {
+				if _, err := stmtHistory.ExecContext(ctx, userID, slug, upsert.replaced, audit.Actor, audit.Reason); err != nil {
+					return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+				}
+			}
+			if _, err := stmtHistory.ExecContext(ctx, userID, slug, upsert.operation, audit.Actor, audit.Reason); err != nil {
+				return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+			}
+		}
+	}
+	for _, userID := range patch.Remove {
+		var mode string
+		err := stmtRemove.QueryRowContext(ctx, slug, userID).Scan(&mode)
+		// Удаление несуществующего переопределения в историю не пишем
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", apperror.ErrCannotDeleteFT, err)
+		}
+		operation := model.OperationExcludeRemoved
+		if mode == model.OverrideInclude {
+			operation = model.OperationIncludeRemoved
+		}
+		if _, err := stmtHistory.ExecContext(ctx, userID, slug, operation, audit.Actor, audit.Reason); err != nil {
+			return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrFailedCTransaction, err)
+	}
+	return nil
+}
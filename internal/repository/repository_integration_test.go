@@ -1,112 +1,293 @@
-package repository_test
-
-import (
-	"context"
-	"database/sql"
-	"log"
-	"os"
-	"progression1/internal/repository"
-	"strconv"
-	"sync"
-	"testing"
-
-	_ "github.com/jackc/pgx/v5/stdlib"
-)
-
-var testDB *sql.DB
-
-const (
-	NumGoroutines = 100 // Количество одновременно запускаемых горутин
-)
-
-func TestMain(m *testing.M) {
-	connStr := os.Getenv("DATABASE_URL")
-	if connStr == "" {
-		log.Fatal("Переменная DATABASE_URL не установлена. Запустите docker-compose up.")
-	}
-	db, err := sql.Open("pgx", connStr)
-	if err != nil {
-		log.Fatalf("Не удалось создать подключение к тестовой БД: %v", err)
-	}
-	if err := db.Ping(); err != nil {
-		log.Fatalf("Не удалось подключиться к тестовой БД: %v", err)
-	}
-	testDB = db
-	exitCode := m.Run()
-	testDB.Close()
-	os.Exit(exitCode)
-}
-
-func setupTest(t *testing.T) (*sql.DB, repository.SegmentRepo) {
-	if testDB == nil {
-		t.Skip("Skipping test because global testDB connection is not initialized (TestMain failed or DATABASE_URL is missing).")
-		return nil, nil
-	}
-	db := testDB
-	_, err := db.ExecContext(context.Background(), "DELETE FROM segments")
-	if err != nil {
-		t.Fatalf("Не удалось очистить таблицу segments: %v", err)
-	}
-	return db, repository.NewPgxSegmentRepo(db)
-}
-
-func TestConcurrentSegmentCreation(t *testing.T) {
-	db, repo := setupTest(t)
-	var wg sync.WaitGroup
-	ctx := context.Background()
-	// 1. Запуск 100 горутин
-	for i := 0; i < NumGoroutines; i++ {
-		wg.Add(1)
-		// Создаем уникальный SLUG для каждого сегмента
-		slug := "CONCURRENT_SEGMENT_" + strconv.Itoa(i)
-		go func(slug string) {
-			defer wg.Done()
-			// 2. Вызов функции создания сегмента
-			// NOTE: Нужно передать nil в качестве auto_percent, как в вашей функции CreateSegment
-			err := repo.CreateSegment(ctx, slug, nil)
-			if err != nil {
-				t.Errorf("Goroutine failed to create segment %s: %v", slug, err)
-			}
-		}(slug)
-	}
-	wg.Wait()
-	// 3. Проверка результата: Должно быть ровно 100 сегментов
-	var count int
-	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM segments").Scan(&count)
-	if err != nil {
-		t.Fatalf("Failed to count segments after concurrency test: %v", err)
-	}
-	if count != NumGoroutines {
-		t.Errorf("Concurrency test failed: Expected %d segments, got %d. Check DB locks or unique constraint violations.", NumGoroutines, count)
-	} else {
-		log.Printf("SUCCESS: Concurrency test passed. Created %d unique segments.", count)
-	}
-}
-
-func TestRepository_UpdateUserSegments_Success(t *testing.T) {
-	ctx := context.Background()
-	slugToAdd := "AVITO_TEST_1"
-	userID := int64(9999)
-	_, err := testDB.ExecContext(ctx, "INSERT INTO segments (slug) VALUES ($1)", slugToAdd)
-	if err != nil {
-		t.Fatalf("Не удалось создать тестовый сегмент (ошибка: %v)", err)
-	}
-	defer testDB.ExecContext(ctx, "DELETE FROM segments WHERE slug = $1", slugToAdd)
-	repo := repository.NewPgxSegmentRepo(testDB)
-	//duration := time.Duration(1) * time.Hour
-	//expiresAt := time.Now().Add(duration)
-	err = repo.UpdateUserSegments(ctx, userID, []string{slugToAdd}, []string{}, nil)
-	if err != nil {
-		t.Fatalf("UpdateUserSegments упал с ошибкой: %v", err)
-	}
-	var count int
-	err = testDB.QueryRowContext(ctx, "SELECT COUNT(*) FROM user_segments WHERE user_id = $1 AND segment_slug = $2", userID, slugToAdd).Scan(&count)
-	if err != nil {
-		t.Fatalf("Не удалось проверить сегмент: %v", err)
-	}
-	if count != 1 {
-		t.Errorf("Ожидалось 1 добавленный сегмент, получено %d", count)
-	}
-	// НЕ ВЫЗЫВАЕМ tx.Commit()
-	// При выходе defer tx.Rollback() откатит все изменения. База данных останется чистой
-}
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"progression1/internal/repository"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+var testDB *sql.DB
+
+const (
+	NumGoroutines = 100 // Количество одновременно запускаемых горутин
+)
+
+func TestMain(m *testing.M) {
+	connStr := os.Getenv("DATABASE_URL")
+	if connStr == "" {
+		log.Fatal("Переменная DATABASE_URL не установлена. Запустите docker-compose up.")
+	}
+	db, err := sql.Open("pgx", connStr)
+	if err != nil {
+		log.Fatalf("Не удалось создать подключение к тестовой БД: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		log.Fatalf("Не удалось подключиться к тестовой БД: %v", err)
+	}
+	testDB = db
+	exitCode := m.Run()
+	testDB.Close()
+	os.Exit(exitCode)
+}
+
+func setupTest(t *testing.T) (*sql.DB, repository.SegmentRepo) {
+	if testDB == nil {
+		t.Skip("Skipping test because global testDB connection is not initialized (TestMain failed or DATABASE_URL is missing).")
+		return nil, nil
+	}
+	db := testDB
+	_, err := db.ExecContext(context.Background(), "DELETE FROM segments")
+	if err != nil {
+		t.Fatalf("Не удалось очистить таблицу segments: %v", err)
+	}
+	return db, repository.NewPgxSegmentRepo(db)
+}
+
+func TestConcurrentSegmentCreation(t *testing.T) {
+	db, repo := setupTest(t)
+	var wg sync.WaitGroup
+	ctx := context.Background()
+	// 1. Запуск 100 горутин
+	for i := 0; i < NumGoroutines; i++ {
+		wg.Add(1)
+		// Создаем уникальный SLUG для каждого сегмента
+		slug := "CONCURRENT_SEGMENT_" + strconv.Itoa(i)
+		go func(slug string) {
+			defer wg.Done()
+			// 2. Вызов функции создания сегмента
+			// NOTE: auto_percent не задан, сегмент создаётся без автоматического назначения
+			err := repo.CreateSegment(ctx, model.SegmentDTO{Slug: slug}, model.AuditDTO{})
+			if err != nil {
+				t.Errorf("Goroutine failed to create segment %s: %v", slug, err)
+			}
+		}(slug)
+	}
+	wg.Wait()
+	// 3. Проверка результата: Должно быть ровно 100 сегментов
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM segments").Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to count segments after concurrency test: %v", err)
+	}
+	if count != NumGoroutines {
+		t.Errorf("Concurrency test failed: Expected %d segments, got %d. Check DB locks or unique constraint violations.", NumGoroutines, count)
+	} else {
+		log.Printf("SUCCESS: Concurrency test passed. Created %d unique segments.", count)
+	}
+}
+
+func TestConcurrentLayerPlacement(t *testing.T) {
+	db, repo := setupTest(t)
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "INSERT INTO layers(slug) VALUES('CONCURRENT_LAYER') ON CONFLICT DO NOTHING"); err != nil {
+		t.Fatalf("Не удалось создать тестовый слой: %v", err)
+	}
+	// Пять сегментов по 25% в одном слое: помещаются ровно четыре, диапазоны не пересекаются
+	percent := 25.0
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(slug string) {
+			defer wg.Done()
+			errs <- repo.CreateSegment(ctx, model.SegmentDTO{Slug: slug, Auto_percent: &percent, Layer: "CONCURRENT_LAYER"}, model.AuditDTO{})
+		}("CONCURRENT_LAYER_" + strconv.Itoa(i))
+	}
+	wg.Wait()
+	close(errs)
+	overflows := 0
+	for err := range errs {
+		if errors.Is(err, apperror.ErrLayerOverflow) {
+			overflows++
+		} else if err != nil {
+			t.Errorf("CreateSegment failed: %v", err)
+		}
+	}
+	var distinct int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(DISTINCT layer_offset) FROM segments WHERE layer_slug = 'CONCURRENT_LAYER'").Scan(&distinct); err != nil {
+		t.Fatalf("Failed to count layer offsets: %v", err)
+	}
+	if overflows != 1 || distinct != 4 {
+		t.Errorf("Expected 4 segments with distinct offsets and 1 overflow, got %d offsets and %d overflows", distinct, overflows)
+	}
+}
+
+func TestRepository_UpdateUserSegments_Success(t *testing.T) {
+	ctx := context.Background()
+	slugToAdd := "AVITO_TEST_1"
+	userID := int64(9999)
+	_, err := testDB.ExecContext(ctx, "INSERT INTO segments (slug) VALUES ($1)", slugToAdd)
+	if err != nil {
+		t.Fatalf("Не удалось создать тестовый сегмент (ошибка: %v)", err)
+	}
+	defer testDB.ExecContext(ctx, "DELETE FROM segments WHERE slug = $1", slugToAdd)
+	repo := repository.NewPgxSegmentRepo(testDB)
+	//duration := time.Duration(1) * time.Hour
+	//expiresAt := time.Now().Add(duration)
+	err = repo.UpdateUserSegments(ctx, userID, []model.SegmentAssignmentDTO{{Slug: slugToAdd}}, []string{}, nil, model.AuditDTO{Actor: "integration-test"})
+	if err != nil {
+		t.Fatalf("UpdateUserSegments упал с ошибкой: %v", err)
+	}
+	var count int
+	err = testDB.QueryRowContext(ctx, "SELECT COUNT(*) FROM user_segments WHERE user_id = $1 AND segment_slug = $2", userID, slugToAdd).Scan(&count)
+	if err != nil {
+		t.Fatalf("Не удалось проверить сегмент: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Ожидалось 1 добавленный сегмент, получено %d", count)
+	}
+	// НЕ ВЫЗЫВАЕМ tx.Commit()
+	// При выходе defer tx.Rollback() откатит все изменения. База данных останется чистой
+}
+
+func TestRepository_UpdateSegment_ClearActiveWindow(t *testing.T) {
+	_, repo := setupTest(t)
+	ctx := context.Background()
+	from := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	if err := repo.CreateSegment(ctx, model.SegmentDTO{Slug: "WINDOW_CLEAR_TEST", Active_from: &from, Active_until: &until}, model.AuditDTO{}); err != nil {
+		t.Fatalf("CreateSegment упал с ошибкой: %v", err)
+	}
+	if err := repo.UpdateSegment(ctx, "WINDOW_CLEAR_TEST", model.SegmentPatchDTO{Clear_active_from: true, Clear_active_until: true}); err != nil {
+		t.Fatalf("UpdateSegment упал с ошибкой: %v", err)
+	}
+	segments, err := repo.GetAllSegments(ctx, model.SegmentFilterDTO{})
+	if err != nil {
+		t.Fatalf("GetAllSegments упал с ошибкой: %v", err)
+	}
+	if len(segments) != 1 || segments[0].Active_from != nil || segments[0].Active_until != nil {
+		t.Errorf("Ожидалось снятое окно активности, получено %+v", segments)
+	}
+}
+
+func TestRepository_UpdateSegmentOverrides_ModeChange(t *testing.T) {
+	db, repo := setupTest(t)
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "DELETE FROM user_segment_history WHERE segment_slug = 'OVERRIDE_MODE_TEST'"); err != nil {
+		t.Fatalf("Не удалось очистить историю: %v", err)
+	}
+	if err := repo.CreateSegment(ctx, model.SegmentDTO{Slug: "OVERRIDE_MODE_TEST"}, model.AuditDTO{}); err != nil {
+		t.Fatalf("CreateSegment упал с ошибкой: %v", err)
+	}
+	for _, patch := range []model.SegmentOverridesPatchDTO{{Include: []int64{1000}}, {Exclude: []int64{1000}}} {
+		if err := repo.UpdateSegmentOverrides(ctx, "OVERRIDE_MODE_TEST", patch, model.AuditDTO{}); err != nil {
+			t.Fatalf("UpdateSegmentOverrides упал с ошибкой: %v", err)
+		}
+	}
+	var operations []string
+	err := repo.StreamHistory(ctx, model.HistoryFilterDTO{Slug: "OVERRIDE_MODE_TEST"}, func(entry model.HistoryTableDTO) error {
+		operations = append(operations, entry.Operation)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamHistory упал с ошибкой: %v", err)
+	}
+	want := []string{model.OperationIncludeAdded, model.OperationIncludeRemoved, model.OperationExcludeAdded}
+	if len(operations) != len(want) || operations[0] != want[0] || operations[1] != want[1] || operations[2] != want[2] {
+		t.Errorf("Ожидались операции %v, получено %v", want, operations)
+	}
+}
+
+func TestSegmentCache_NotifyInvalidation(t *testing.T) {
+	_, repo := setupTest(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cache := repository.NewSegmentCache(time.Hour)
+	cached := repository.NewCachedSegmentRepo(repo, cache)
+	go cache.Listen(ctx, testDB)
+	// Подписка сбрасывает кэш один раз; ждём её, чтобы не принять этот сброс за уведомление
+	waitInvalidations(t, cache, 1)
+	if _, err := cached.GetSegmentsConfig(ctx); err != nil {
+		t.Fatalf("GetSegmentsConfig упал с ошибкой: %v", err)
+	}
+	if _, err := cached.GetSegmentsConfig(ctx); err != nil {
+		t.Fatalf("GetSegmentsConfig упал с ошибкой: %v", err)
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("Ожидалось 1 попадание и 1 промах, получено %+v", stats)
+	}
+	// Сегмент создаёт «другая реплика» — в обход кэширующего репозитория
+	if err := repo.CreateSegment(ctx, model.SegmentDTO{Slug: "CACHE_NOTIFY_TEST"}, model.AuditDTO{}); err != nil {
+		t.Fatalf("CreateSegment упал с ошибкой: %v", err)
+	}
+	waitInvalidations(t, cache, 2)
+	segments, err := cached.GetSegmentsConfig(ctx)
+	if err != nil {
+		t.Fatalf("GetSegmentsConfig упал с ошибкой: %v", err)
+	}
+	if len(segments) != 1 || segments[0].Slug != "CACHE_NOTIFY_TEST" {
+		t.Errorf("Ожидался сегмент CACHE_NOTIFY_TEST после NOTIFY, получено %+v", segments)
+	}
+}
+
+// countingConfigRepo считает загрузки каталога; остальные методы не используются
+type countingConfigRepo struct {
+	repository.SegmentRepo
+	loads   atomic.Int64
+	release chan struct{}
+}
+
+func (r *countingConfigRepo) GetSegmentsConfig(ctx context.Context) ([]model.SegmentUserDataDTO, error) {
+	r.loads.Add(1)
+	<-r.release
+	return []model.SegmentUserDataDTO{{Slug: "CACHE_SINGLE_FLIGHT"}}, nil
+}
+
+func TestSegmentCache_SingleFlight(t *testing.T) {
+	repo := &countingConfigRepo{release: make(chan struct{})}
+	cache := repository.NewSegmentCache(time.Hour)
+	cached := repository.NewCachedSegmentRepo(repo, cache)
+	var wg sync.WaitGroup
+	errs := make(chan error, NumGoroutines)
+	for i := 0; i < NumGoroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			segments, err := cached.GetSegmentsConfig(context.Background())
+			if err == nil && (len(segments) != 1 || segments[0].Slug != "CACHE_SINGLE_FLIGHT") {
+				err = fmt.Errorf("неожиданный каталог %+v", segments)
+			}
+			errs <- err
+		}()
+	}
+	// Даём промахам дождаться общей загрузки, прежде чем её завершить
+	deadline := time.Now().Add(5 * time.Second)
+	for cache.Stats().Misses < NumGoroutines && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(repo.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("GetSegmentsConfig упал с ошибкой: %v", err)
+		}
+	}
+	if loads := repo.loads.Load(); loads != 1 {
+		t.Errorf("Ожидалась 1 загрузка каталога на %d одновременных промахов, получено %d", NumGoroutines, loads)
+	}
+}
+
+func waitInvalidations(t *testing.T, cache *repository.SegmentCache, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for cache.Stats().Invalidations < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("Кэш не сброшен за 5s: ожидалось %d сбросов, получено %d", want, cache.Stats().Invalidations)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
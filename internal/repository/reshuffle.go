@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+)
+
+// ReshuffleChangesFunc сравнивает попадание страницы пользователей в segment (со старой солью)
+// и в тот же сегмент с солью salt и возвращает вошедших и вышедших
+type ReshuffleChangesFunc func(segment model.SegmentUserDataDTO, salt string, users []model.UserAttributesDTO) (added, removed []int64, err error)
+
+// reshuffleHistoryQuery считает изменения членства по auto_percent и пишет их в историю,
+// если $6 = TRUE; пользователи с ручным назначением или явным переопределением сменой
+// соли не затрагиваются
+const reshuffleHistoryQuery = `
+	WITH changed AS (
+		SELECT u.user_id FROM unnest($1::bigint[]) AS u(user_id)
+		WHERE NOT EXISTS (SELECT 1 FROM user_segments us WHERE us.user_id = u.user_id AND us.segment_slug = $2 AND us.source = 'manual')
+		  AND NOT EXISTS (SELECT 1 FROM segment_overrides so WHERE so.user_id = u.user_id AND so.segment_slug = $2)),
+	history AS (
+		INSERT INTO user_segment_history(user_id, segment_slug, operation, actor, reason)
+		SELECT user_id, $2, $3, $4, $5 FROM changed WHERE $6)
+	SELECT COUNT(*) FROM changed
+`
+
+// newHashSalt генерирует случайную соль бакетирования сегмента
+func newHashSalt() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate hash salt: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// ReshuffleSegment в одной транзакции блокирует сегмент, генерирует и сохраняет новую соль и
+// проходит реестр пользователей страницами по batchSize: changes определяет вошедших
+// (RESHUFFLE_ADDED) и вышедших (RESHUFFLE_REMOVED) по auto_percent, они пишутся в историю.
+// Блокировка строки сегмента не даёт двум перемешиваниям чередоваться. У материализованного
+// сегмента строки пересчитывает фоновая материализация (она замечает смену соли) с операциями
+// AUTO_ADDED и AUTO_REMOVED, поэтому отдельные записи перемешивания не пишутся
+func (r *pgxSegmentRepo) ReshuffleSegment(ctx context.Context, slug string, audit model.AuditDTO, batchSize int, changes ReshuffleChangesFunc) (model.ReshuffleResultDTO, error) {
+	result := model.ReshuffleResultDTO{Segment_slug: slug}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, fmt.Errorf("%w: %w", apperror.ErrFailedBTransaction, err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM segments WHERE slug = $1 FOR UPDATE", slug); err != nil {
+		return result, fmt.Errorf("db query failed: %w", err)
+	}
+	segments, err := querySegmentsData(ctx, tx, 0, slug)
+	if err != nil {
+		return result, err
+	}
+	if len(segments) == 0 {
+		return result, apperror.ErrSegmentNotFound
+	}
+	segment := segments[0]
+	// Слой мог быть назначен после проверки в сервисе
+	if segment.Layer != "" {
+		return result, apperror.ErrLayerReshuffle
+	}
+	salt, err := newHashSalt()
+	if err != nil {
+		return result, err
+	}
+	var autoPercent sql.NullFloat64
+	if err := tx.QueryRowContext(ctx, "UPDATE segments SET hash_salt = $2, updated_at = NOW() WHERE slug = $1 RETURNING auto_percent", slug, salt).Scan(&autoPercent); err != nil {
+		return result, fmt.Errorf("db update failed: %w", err)
+	}
+	// Новая соль меняет выборку — фиксируем её в аудите конфигурации для запросов as_of
+	if _, err := tx.ExecContext(ctx, configSnapshotQuery, slug, autoPercent); err != nil {
+		return result, fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+	}
+	var materialized bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM segment_materializations WHERE segment_slug = $1)", slug).Scan(&materialized); err != nil {
+		return result, fmt.Errorf("db query failed: %w", err)
+	}
+	var afterID int64
+	for {
+		users, err := listUsers(ctx, tx, afterID, batchSize)
+		if err != nil {
+			return result, err
+		}
+		added, removed, err := changes(segment, salt, users)
+		if err != nil {
+			return result, err
+		}
+		for _, change := range []struct {
+			userIDs   []int64
+			operation string
+			count     *int
+		}{
+			{added, model.OperationReshuffleAdded, &result.Added},
+			{removed, model.OperationReshuffleRemoved, &result.Removed},
+		} {
+			if len(change.userIDs) == 0 {
+				continue
+			}
+			var count int
+			if err := tx.QueryRowContext(ctx, reshuffleHistoryQuery, change.userIDs, slug, change.operation, audit.Actor, audit.Reason, !materialized).Scan(&count); err != nil {
+				return result, fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+			}
+			*change.count += count
+		}
+		if len(users) < batchSize {
+			break
+		}
+		afterID = users[len(users)-1].UserID
+	}
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("%w: %w", apperror.ErrFailedCTransaction, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+)
+
+func (r *pgxSegmentRepo) GetRolloutPlan(ctx context.Context, slug string) (model.RolloutPlanDTO, error) {
+	plan := model.RolloutPlanDTO{Segment_slug: slug, Steps: []model.RolloutStepDTO{}}
+	var autoPercent sql.NullFloat64
+	var pausedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, "SELECT auto_percent, rollout_paused_at FROM segments WHERE slug = $1", slug).Scan(&autoPercent, &pausedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return plan, apperror.ErrSegmentNotFound
+	}
+	if err != nil {
+		return plan, fmt.Errorf("db query failed: %w", err)
+	}
+	plan.Auto_percent = nullFloatToPtr(autoPercent)
+	plan.Paused_at = nullTimeToPtr(pausedAt)
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT percent, starts_at FROM segment_rollout_steps
+		WHERE segment_slug = $1
+		ORDER BY starts_at
+		`, slug)
+	if err != nil {
+		return plan, fmt.Errorf("db query failed: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var step model.RolloutStepDTO
+		if err := rows.Scan(&step.Percent, &step.Starts_at); err != nil {
+			return plan, fmt.Errorf("scan failed: %w", err)
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+	if err := rows.Err(); err != nil {
+		return plan, fmt.Errorf("%w: %w", apperror.ErrDuringRowsIteration, err)
+	}
+	return plan, nil
+}
+
+// SetRolloutSteps полностью заменяет шаги плана раскатки сегмента и пишет новый план в аудит конфигурации
+func (r *pgxSegmentRepo) SetRolloutSteps(ctx context.Context, slug string, steps []model.RolloutStepDTO) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrFailedBTransaction, err)
+	}
+	defer tx.Rollback()
+	var autoPercent sql.NullFloat64
+	var layer sql.NullString
+	err = tx.QueryRowContext(ctx, "UPDATE segments SET updated_at = NOW() WHERE slug = $1 RETURNING auto_percent, layer_slug", slug).Scan(&autoPercent, &layer)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperror.ErrSegmentNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update segment: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM segment_rollout_steps WHERE segment_slug = $1", slug); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrCannotDeleteFT, err)
+	}
+	stmtAdd, err := tx.PrepareContext(ctx, "INSERT INTO segment_rollout_steps(segment_slug, percent, starts_at) VALUES($1, $2, $3)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare add statement: %w", err)
+	}
+	defer stmtAdd.Close()
+	for _, step := range steps {
+		if _, err := stmtAdd.ExecContext(ctx, slug, step.Percent, step.Starts_at); err != nil {
+			return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+		}
+	}
+	// Шаги раскатки расширяют диапазон сегмента слоя
+	if layer.Valid {
+		if err := checkLayerRange(ctx, tx, layer.String, slug); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, configSnapshotQuery, slug, autoPercent); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrFailedCTransaction, err)
+	}
+	return nil
+}
+
+// SetRolloutPaused ставит план на паузу (запоминая момент паузы) или снимает с неё
+// и пишет новое состояние в аудит конфигурации
+func (r *pgxSegmentRepo) SetRolloutPaused(ctx context.Context, slug string, paused bool) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrFailedBTransaction, err)
+	}
+	defer tx.Rollback()
+	var autoPercent sql.NullFloat64
+	err = tx.QueryRowContext(ctx, `
+		UPDATE segments
+		SET rollout_paused_at = CASE WHEN $2 THEN COALESCE(rollout_paused_at, NOW()) ELSE NULL END,
+		    updated_at = NOW()
+		WHERE slug = $1
+		RETURNING auto_percent
+		`, slug, paused).Scan(&autoPercent)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperror.ErrSegmentNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update segment: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, configSnapshotQuery, slug, autoPercent); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrFailedCTransaction, err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"time"
+)
+
+// GetSegmentStats считает действующие ручные назначения сегмента и динамику членства по дням
+// [from, to); границы — полночь UTC. Участником считается пользователь, чья последняя операция в истории —
+// добавление; дневные added/removed учитывают только фактические переходы, поэтому повторные
+// ADDED (продление, повторный импорт) не завышают численность. Материализованные строки
+// (source = 'auto', операции AUTO_*) не учитываются ни в счётчиках, ни в динамике: пользователь,
+// состоящий в сегменте и вручную, и автоматически, не выбывает при AUTO_REMOVED.
+func (r *pgxSegmentRepo) GetSegmentStats(ctx context.Context, slug string, from, to time.Time) (model.SegmentStatsDTO, error) {
+	stats := model.SegmentStatsDTO{Slug: slug, Daily: []model.SegmentDailyStatsDTO{}}
+	err := r.db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*) FILTER (WHERE expires_at IS NULL OR expires_at > NOW()),
+			COUNT(*) FILTER (WHERE expires_at > NOW())
+		FROM user_segments
+		WHERE segment_slug = $1 AND source = 'manual'
+		`, slug).Scan(&stats.Manual_members, &stats.Members_with_ttl)
+	if err != nil {
+		return model.SegmentStatsDTO{}, fmt.Errorf("db query failed for segment stats: %w", err)
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		WITH events AS (
+			SELECT id, user_id, created_at, operation = 'ADDED' AS member
+			FROM user_segment_history
+			WHERE segment_slug = $1 AND created_at < $3
+				AND operation IN ('ADDED', 'REMOVED', 'EXPIRED')
+		), changes AS (
+			SELECT created_at, member,
+				LAG(member, 1, FALSE) OVER (PARTITION BY user_id ORDER BY created_at, id) AS was_member
+			FROM events
+		), daily AS (
+			SELECT (created_at AT TIME ZONE 'UTC')::date AS day,
+				COUNT(*) FILTER (WHERE member) AS added,
+				COUNT(*) FILTER (WHERE NOT member) AS removed
+			FROM changes
+			WHERE member <> was_member
+			GROUP BY 1
+		), totals AS (
+			SELECT day, added, removed, SUM(added - removed) OVER (ORDER BY day) AS members
+			FROM daily
+		)
+		SELECT d.day::date, COALESCE(t.added, 0), COALESCE(t.removed, 0),
+			COALESCE((SELECT members FROM totals WHERE totals.day <= d.day ORDER BY totals.day DESC LIMIT 1), 0)
+		FROM generate_series($2::timestamptz AT TIME ZONE 'UTC', $3::timestamptz AT TIME ZONE 'UTC' - INTERVAL '1 day', INTERVAL '1 day') AS d(day)
+		LEFT JOIN totals t ON t.day = d.day::date
+		ORDER BY d.day
+		`, slug, from, to)
+	if err != nil {
+		return model.SegmentStatsDTO{}, fmt.Errorf("db query failed for segment daily stats: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day time.Time
+		var dto model.SegmentDailyStatsDTO
+		if err := rows.Scan(&day, &dto.Added, &dto.Removed, &dto.Members); err != nil {
+			return model.SegmentStatsDTO{}, fmt.Errorf("scan failed: %w", err)
+		}
+		dto.Date = day.Format(time.DateOnly)
+		stats.Daily = append(stats.Daily, dto)
+	}
+	if err := rows.Err(); err != nil {
+		return model.SegmentStatsDTO{}, fmt.Errorf("%w: %w", apperror.ErrDuringRowsIteration, err)
+	}
+	return stats, nil
+}
+
+// CountUsers возвращает число пользователей в реестре users
+func (r *pgxSegmentRepo) CountUsers(ctx context.Context) (int64, error) {
+	var count int64
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		return 0, fmt.Errorf("db query failed: %w", err)
+	}
+	return count, nil
+}
@@ -1,217 +1,688 @@
-package repository
-
-import (
-	"context"
-	"database/sql"
-	"fmt"
-	"progression1/internal/apperror"
-	"progression1/internal/model"
-	"time"
-
-	_ "github.com/jackc/pgx/v5/stdlib"
-)
-
-type SegmentRepo interface {
-	CreateSegment(ctx context.Context, slug string, auto_percent *int) error
-	DeleteSegment(ctx context.Context, slug string) error
-
-	GetAllSegments(ctx context.Context) ([]string, error)
-	GetHTable(ctx context.Context) ([]model.HistoryTableDTO, error)
-	GetHForPeriod(ctx context.Context, year, month int) ([]model.HistoryTableDTO, error)
-	SegmentExists(ctx context.Context, slug string) (bool, error)
-	AddUserToSegment(ctx context.Context, userID int64, slug string) error
-	UpdateUserSegments(ctx context.Context, userID int64, addSlugs []string, removeSlugs []string, expiresAt *time.Time) error
-	GetAllSegmentsData(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error)
-}
-
-type pgxSegmentRepo struct {
-	db *sql.DB
-}
-
-func NewPgxSegmentRepo(db *sql.DB) SegmentRepo {
-	return &pgxSegmentRepo{db: db}
-}
-
-func (r *pgxSegmentRepo) CreateSegment(ctx context.Context, slug string, auto_percent *int) error {
-	var err error
-	if auto_percent != nil {
-		_, err = r.db.ExecContext(ctx, "INSERT INTO segments(slug, auto_percent) VALUES($1, $2)", slug, auto_percent)
-	} else {
-		_, err = r.db.ExecContext(ctx, "INSERT INTO segments(slug) VALUES($1)", slug)
-	}
-	return err
-}
-
-func (r *pgxSegmentRepo) DeleteSegment(ctx context.Context, slug string) error {
-	_, err := r.db.ExecContext(ctx, "DELETE FROM segments WHERE slug = $1", slug)
-	return err
-}
-
-func (r *pgxSegmentRepo) GetAllSegments(ctx context.Context) ([]string, error) {
-	rows, err := r.db.QueryContext(ctx, "SELECT slug FROM segments")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var slugs []string
-	for rows.Next() {
-		var slug string
-		if err := rows.Scan(&slug); err != nil {
-			return nil, err
-		}
-		slugs = append(slugs, slug)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("%w: %w", apperror.ErrDuringRowsIteration, err)
-	}
-	return slugs, nil
-}
-
-func (r *pgxSegmentRepo) SegmentExists(ctx context.Context, slug string) (bool, error) {
-	var exists bool
-	err := r.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM segments WHERE slug = $1)", slug).Scan(&exists)
-	return exists, err
-}
-
-func (r *pgxSegmentRepo) AddUserToSegment(ctx context.Context, userID int64, slug string) error {
-	if _, err := r.db.ExecContext(ctx, `
-		INSERT INTO user_segments(user_id, segment_slug) VALUES($1, $2);
-		`,
-		userID, slug); err != nil {
-		return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
-	}
-	return nil
-}
-
-func (r *pgxSegmentRepo) GetAllSegmentsData(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error) {
-	query := `
-        SELECT
-            s.slug,                   -- 1. Имя сегмента
-            s.auto_percent,           -- 2. Процент
-            us.expires_at,            -- 3. Время истечения (NULL, если не назначен вручную)
-            CASE WHEN us.user_id IS NOT NULL THEN TRUE ELSE FALSE END AS is_manual -- 4. Назначен ли вручную
-        FROM 
-            segments s
-        LEFT JOIN 
-            user_segments us 
-            ON s.slug = us.segment_slug AND us.user_id = $1; 
-    `
-	rows, err := r.db.QueryContext(ctx, query, userID)
-	if err != nil {
-		return nil, fmt.Errorf("db query failed: %w", err)
-	}
-	defer rows.Close()
-	var results []model.SegmentUserDataDTO
-	for rows.Next() {
-		var dto model.SegmentUserDataDTO
-		var expiresAt sql.NullTime // Используем sql.NullTime для expires_at, т.к. может быть NULL
-		if err := rows.Scan(
-			&dto.Slug,
-			&dto.AutoPercent,
-			&expiresAt,
-			&dto.IsManuallyAssigned,
-		); err != nil {
-			return nil, fmt.Errorf("scan failed: %w", err)
-		}
-		if expiresAt.Valid {
-			dto.ExpiresAt = &expiresAt.Time
-		}
-		results = append(results, dto)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error during rows iteration: %w", err)
-	}
-	return results, nil
-}
-
-func (r *pgxSegmentRepo) GetHTable(ctx context.Context) ([]model.HistoryTableDTO, error) {
-	rows, err := r.db.QueryContext(ctx, "SELECT * FROM user_segment_history")
-	if err != nil {
-		return nil, fmt.Errorf("db query failed: %w", err)
-	}
-	defer rows.Close()
-	var historyTables []model.HistoryTableDTO
-	for rows.Next() {
-		var historyTable model.HistoryTableDTO
-		if err := rows.Scan(&historyTable); err != nil {
-			return nil, apperror.ErrSegmentNotFound
-		}
-		historyTables = append(historyTables, historyTable)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, apperror.ErrDuringRowsIteration
-	}
-	return historyTables, nil
-}
-
-func (r *pgxSegmentRepo) GetHForPeriod(ctx context.Context, year, month int) ([]model.HistoryTableDTO, error) {
-	// Начало месяца (например, 2025-10-01 00:00:00)
-	startTime := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
-	// Начало следующего месяца (например, 2025-11-01 00:00:00)
-	endTime := startTime.AddDate(0, 1, 0)
-	rows, err := r.db.QueryContext(ctx, `
-        SELECT id, user_id, segment_slug, operation, created_at 
-        FROM user_segment_history
-        WHERE created_at >= $1 AND created_at < $2
-        ORDER BY created_at
-    `, startTime, endTime)
-	if err != nil {
-		return nil, fmt.Errorf("db query failed for report: %w", err)
-	}
-	defer rows.Close()
-	var historyTables []model.HistoryTableDTO
-	for rows.Next() {
-		var dto model.HistoryTableDTO
-		if err := rows.Scan(&dto.ID, &dto.User_ID, &dto.Segment_slug, &dto.Operation, &dto.Created_at); err != nil {
-			return nil, fmt.Errorf("scan failed: %w", err)
-		}
-		historyTables = append(historyTables, dto)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("%w: %w", apperror.ErrDuringRowsIteration, err)
-	}
-	return historyTables, nil
-}
-
-func (r *pgxSegmentRepo) UpdateUserSegments(ctx context.Context, userID int64, addSlugs []string, removeSlugs []string, expiresAt *time.Time) error {
-	tx, err := r.db.BeginTx(ctx, nil)
-	if err != nil {
-		return fmt.Errorf("%w: %w", apperror.ErrFailedBTransaction, err)
-	}
-	defer tx.Rollback()
-	stmtRemove, err := tx.PrepareContext(ctx, "DELETE FROM user_segments WHERE user_id = $1 AND segment_slug = $2")
-	if err != nil {
-		return fmt.Errorf("failed to prepare remove statement: %w", err)
-	}
-	defer stmtRemove.Close()
-	stmtAdd, err := tx.PrepareContext(ctx, `
-        INSERT INTO user_segments (user_id, segment_slug, expires_at) 
-        VALUES ($1, $2, $3) 
-        ON CONFLICT (user_id, segment_slug) 
-        DO UPDATE SET expires_at = EXCLUDED.expires_at; 
-    `)
-	if err != nil {
-		return fmt.Errorf("failed to prepare add statement: %w", err)
-	}
-	defer stmtAdd.Close()
-	for _, slug := range removeSlugs {
-		if _, err := stmtRemove.ExecContext(ctx, userID, slug); err != nil {
-			return fmt.Errorf("%w: %w", apperror.ErrCannotDeleteFT, err)
-		}
-		if _, err = tx.ExecContext(ctx, "INSERT INTO user_segment_history(user_id, segment_slug, operation) VALUES($1, $2, $3)", userID, slug, "REMOVED"); err != nil {
-			return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
-		}
-	}
-	for _, slug := range addSlugs {
-		if _, err := stmtAdd.ExecContext(ctx, userID, slug, expiresAt); err != nil {
-			return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
-		}
-		if _, err = tx.ExecContext(ctx, "INSERT INTO user_segment_history(user_id, segment_slug, operation) VALUES($1, $2, $3)", userID, slug, "ADDED"); err != nil {
-			return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
-		}
-	}
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("%w: %w", apperror.ErrFailedCTransaction, err)
-	}
-	return nil
-}
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+type SegmentRepo interface {
+	CreateSegment(ctx context.Context, segment model.SegmentDTO, audit model.AuditDTO) error
+	DeleteSegment(ctx context.Context, slug string, audit model.AuditDTO) error
+	UpdateSegment(ctx context.Context, slug string, patch model.SegmentPatchDTO) error
+	GetSegmentConfigHistory(ctx context.Context, slug string) ([]model.SegmentConfigHistoryDTO, error)
+
+	GetAllSegments(ctx context.Context, filter model.SegmentFilterDTO) ([]model.SegmentInfoDTO, error)
+	StreamHistory(ctx context.Context, filter model.HistoryFilterDTO, fn func(model.HistoryTableDTO) error) error
+	SegmentExists(ctx context.Context, slug string) (bool, error)
+	AddUserToSegment(ctx context.Context, userID int64, slug string, audit model.AuditDTO) error
+	UpdateUserSegments(ctx context.Context, userID int64, add []model.SegmentAssignmentDTO, removeSlugs []string, extend []model.SegmentAssignmentDTO, audit model.AuditDTO) error
+	StreamSegmentMembers(ctx context.Context, slug string, afterUserID int64, limit int, fn func(model.SegmentMemberDTO) error) error
+	ImportUserSegments(ctx context.Context, userIDs []int64, slugs []string, expiresAt *time.Time, audit model.AuditDTO) error
+	GetAllSegmentsData(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error)
+	GetSegmentsConfig(ctx context.Context) ([]model.SegmentUserDataDTO, error)
+	GetUsersAssignments(ctx context.Context, userIDs []int64) ([]model.UserAssignmentDTO, error)
+	DeleteExpiredAssignments(ctx context.Context, limit int) (int, error)
+	GetSegmentData(ctx context.Context, slug string) (model.SegmentUserDataDTO, error)
+	GetUserSegmentData(ctx context.Context, userID int64, slug string) (model.SegmentUserDataDTO, error)
+	GetSegmentStats(ctx context.Context, slug string, from, to time.Time) (model.SegmentStatsDTO, error)
+	GetSegmentsDataAsOf(ctx context.Context, userID int64, asOf time.Time) ([]model.SegmentUserDataDTO, error)
+	ReshuffleSegment(ctx context.Context, slug string, audit model.AuditDTO, batchSize int, changes ReshuffleChangesFunc) (model.ReshuffleResultDTO, error)
+
+	GetRolloutPlan(ctx context.Context, slug string) (model.RolloutPlanDTO, error)
+	SetRolloutSteps(ctx context.Context, slug string, steps []model.RolloutStepDTO) error
+	SetRolloutPaused(ctx context.Context, slug string, paused bool) error
+
+	CreateLayer(ctx context.Context, layer model.LayerDTO) error
+	GetLayers(ctx context.Context) ([]model.LayerDTO, error)
+	LayerExists(ctx context.Context, slug string) (bool, error)
+	GetLayerSegments(ctx context.Context, layer string) ([]model.LayerSegmentDTO, error)
+	GetSegmentLayer(ctx context.Context, slug string) (string, error)
+
+	GetUserAttributes(ctx context.Context, userID int64) (map[string]any, error)
+	GetUsersAttributes(ctx context.Context, userIDs []int64) (map[int64]map[string]any, error)
+	SetUserAttributes(ctx context.Context, userID int64, attrs map[string]any) error
+	MergeUserAttributes(ctx context.Context, userID int64, attrs map[string]any) error
+	ListUsers(ctx context.Context, afterID int64, limit int) ([]model.UserAttributesDTO, error)
+	CountUsers(ctx context.Context) (int64, error)
+
+	GetMaterializations(ctx context.Context) ([]model.MaterializationDTO, error)
+	StartMaterialization(ctx context.Context, slug, previousKey, key string) (bool, error)
+	ApplyMaterialization(ctx context.Context, page model.MaterializationPageDTO) (bool, error)
+
+	GetSegmentOverrides(ctx context.Context, slug string) (model.SegmentOverridesDTO, error)
+	UpdateSegmentOverrides(ctx context.Context, slug string, patch model.SegmentOverridesPatchDTO, audit model.AuditDTO) error
+}
+
+type pgxSegmentRepo struct {
+	db *sql.DB
+}
+
+func NewPgxSegmentRepo(db *sql.DB) SegmentRepo {
+	return &pgxSegmentRepo{db: db}
+}
+
+// CreateSegment создаёт сегмент; с segment.Materialize сегмент ставится в очередь фоновой
+// материализации, которая запишет в него известных пользователей по auto_percent
+func (r *pgxSegmentRepo) CreateSegment(ctx context.Context, segment model.SegmentDTO, audit model.AuditDTO) error {
+	tags := segment.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	rule, err := ruleToJSON(segment.Targeting_rule)
+	if err != nil {
+		return err
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrFailedBTransaction, err)
+	}
+	defer tx.Rollback()
+	layerOffset := 0
+	if segment.Layer != "" {
+		if layerOffset, err = placeInLayer(ctx, tx, segment); err != nil {
+			return err
+		}
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO segments(slug, auto_percent, description, owner, tags, active_from, active_until, layer_slug, layer_offset, targeting_rule)
+		VALUES($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10::jsonb)
+		ON CONFLICT (slug) DO NOTHING
+		`,
+		segment.Slug, segment.Auto_percent, segment.Description, segment.Owner, tags,
+		segment.Active_from, segment.Active_until, segment.Layer, layerOffset, rule)
+	if err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: %s", apperror.ErrSegmentExists, segment.Slug)
+	}
+	// Начальная конфигурация — первая запись аудита сегмента
+	if _, err := tx.ExecContext(ctx, configSnapshotQuery, segment.Slug, nil); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+	}
+	if segment.Materialize {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO segment_materializations(segment_slug) VALUES($1)", segment.Slug); err != nil {
+			return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrFailedCTransaction, err)
+	}
+	return nil
+}
+
+func (r *pgxSegmentRepo) DeleteSegment(ctx context.Context, slug string, audit model.AuditDTO) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrFailedBTransaction, err)
+	}
+	defer tx.Rollback()
+	// Удаление фиксируется в аудите конфигурации: на более поздний момент сегмент не восстанавливается
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO segment_config_history(segment_slug, previous_auto_percent, hash_salt, deleted)
+		SELECT slug, auto_percent, hash_salt, TRUE FROM segments WHERE slug = $1
+		`, slug); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+	}
+	// Фиксируем в истории выход из сегмента для всех его участников до удаления связей
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO user_segment_history(user_id, segment_slug, operation, actor, reason)
+		SELECT user_id, segment_slug, CASE WHEN source = 'auto' THEN $4 ELSE $5 END, $2, $3
+		FROM user_segments WHERE segment_slug = $1
+		`, slug, audit.Actor, audit.Reason, model.OperationAutoRemoved, model.OperationRemoved); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_segments WHERE segment_slug = $1", slug); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrCannotDeleteFT, err)
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM segments WHERE slug = $1", slug)
+	if err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrCannotDeleteFT, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrCannotDeleteFT, err)
+	}
+	if affected == 0 {
+		return apperror.ErrSegmentNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrFailedCTransaction, err)
+	}
+	return nil
+}
+
+// configSnapshotQuery пишет в аудит конфигурации текущее состояние сегмента $1 вместе с планом
+// раскатки, паузой и слоем, чтобы запросы as_of восстанавливали их на любой момент;
+// $2 — auto_percent до изменения
+const configSnapshotQuery = `
+	INSERT INTO segment_config_history(segment_slug, previous_auto_percent, auto_percent, active_from, active_until,
+		targeting_rule, hash_salt, rollout_steps, rollout_paused_at, layer_slug, layer_offset)
+	SELECT s.slug, $2::numeric, s.auto_percent, s.active_from, s.active_until, s.targeting_rule, s.hash_salt,
+		COALESCE((SELECT jsonb_agg(jsonb_build_object('percent', rs.percent, 'starts_at', rs.starts_at) ORDER BY rs.starts_at)
+		          FROM segment_rollout_steps rs WHERE rs.segment_slug = s.slug), '[]'::jsonb),
+		s.rollout_paused_at, s.layer_slug, s.layer_offset
+	FROM segments s
+	WHERE s.slug = $1
+`
+
+// UpdateSegment применяет непустые поля patch и пишет снимок новой конфигурации в аудит
+func (r *pgxSegmentRepo) UpdateSegment(ctx context.Context, slug string, patch model.SegmentPatchDTO) error {
+	// NULL — не менять правило, пустая строка — удалить правило
+	var rule any
+	if patch.Targeting_rule != nil {
+		encoded, err := ruleToJSON(patch.Targeting_rule)
+		if err != nil {
+			return err
+		}
+		rule = ""
+		if encoded != nil {
+			rule = encoded
+		}
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrFailedBTransaction, err)
+	}
+	defer tx.Rollback()
+	var previous sql.NullFloat64
+	err = tx.QueryRowContext(ctx, "SELECT auto_percent FROM segments WHERE slug = $1 FOR UPDATE", slug).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperror.ErrSegmentNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("db query failed: %w", err)
+	}
+	var current sql.NullFloat64
+	var activeFrom, activeUntil sql.NullTime
+	var currentRule []byte
+	var layer sql.NullString
+	if err := tx.QueryRowContext(ctx, `
+		UPDATE segments
+		SET auto_percent = COALESCE($2, auto_percent),
+		    active_from = CASE WHEN $6 THEN NULL ELSE COALESCE($3, active_from) END,
+		    active_until = CASE WHEN $7 THEN NULL ELSE COALESCE($4, active_until) END,
+		    targeting_rule = CASE
+		        WHEN $5::text IS NULL THEN targeting_rule
+		        WHEN $5::text = '' THEN NULL
+		        ELSE ($5::text)::jsonb END,
+		    updated_at = NOW()
+		WHERE slug = $1
+		RETURNING auto_percent, active_from, active_until, targeting_rule, layer_slug
+		`, slug, patch.Auto_percent, patch.Active_from, patch.Active_until, rule, patch.Clear_active_from, patch.Clear_active_until).Scan(&current, &activeFrom, &activeUntil, &currentRule, &layer); err != nil {
+		return fmt.Errorf("failed to update segment: %w", err)
+	}
+	if layer.Valid {
+		// Правило без auto_percent заняло бы весь слой и пересеклось с остальными сегментами слоя
+		if currentRule != nil && !current.Valid {
+			return apperror.ErrLayerRule
+		}
+		if err := checkLayerRange(ctx, tx, layer.String, slug); err != nil {
+			return err
+		}
+	}
+	if activeFrom.Valid && activeUntil.Valid && !activeFrom.Time.Before(activeUntil.Time) {
+		return apperror.ErrActiveWindow
+	}
+	if _, err := tx.ExecContext(ctx, configSnapshotQuery, slug, previous); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrFailedCTransaction, err)
+	}
+	return nil
+}
+
+func (r *pgxSegmentRepo) GetSegmentConfigHistory(ctx context.Context, slug string) ([]model.SegmentConfigHistoryDTO, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, segment_slug, previous_auto_percent, auto_percent, active_from, active_until, targeting_rule, deleted, created_at
+		FROM segment_config_history
+		WHERE segment_slug = $1
+		ORDER BY created_at, id
+		`, slug)
+	if err != nil {
+		return nil, fmt.Errorf("db query failed: %w", err)
+	}
+	defer rows.Close()
+	var entries []model.SegmentConfigHistoryDTO
+	for rows.Next() {
+		var dto model.SegmentConfigHistoryDTO
+		var previous, current sql.NullFloat64
+		var activeFrom, activeUntil sql.NullTime
+		var rule []byte
+		if err := rows.Scan(&dto.ID, &dto.Segment_slug, &previous, &current, &activeFrom, &activeUntil, &rule, &dto.Deleted, &dto.Created_at); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		if dto.Targeting_rule, err = ruleFromJSON(rule); err != nil {
+			return nil, err
+		}
+		dto.Previous_auto_percent = nullFloatToPtr(previous)
+		dto.Auto_percent = nullFloatToPtr(current)
+		dto.Active_from = nullTimeToPtr(activeFrom)
+		dto.Active_until = nullTimeToPtr(activeUntil)
+		entries = append(entries, dto)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", apperror.ErrDuringRowsIteration, err)
+	}
+	return entries, nil
+}
+
+func nullFloatToPtr(v sql.NullFloat64) *float64 {
+	if !v.Valid {
+		return nil
+	}
+	f := v.Float64
+	return &f
+}
+
+// ruleToJSON кодирует правило таргетинга для записи в JSONB; правило без условий хранится как NULL
+func ruleToJSON(rule *model.TargetingRuleDTO) (any, error) {
+	if rule == nil || len(rule.All) == 0 {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(rule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode targeting rule: %w", err)
+	}
+	return string(encoded), nil
+}
+
+func ruleFromJSON(raw []byte) (*model.TargetingRuleDTO, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	var rule model.TargetingRuleDTO
+	if err := json.Unmarshal(raw, &rule); err != nil {
+		return nil, fmt.Errorf("failed to decode targeting rule: %w", err)
+	}
+	return &rule, nil
+}
+
+func nullTimeToPtr(v sql.NullTime) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	t := v.Time
+	return &t
+}
+
+func (r *pgxSegmentRepo) GetAllSegments(ctx context.Context, filter model.SegmentFilterDTO) ([]model.SegmentInfoDTO, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT slug, auto_percent, description, owner, tags, active_from, active_until,
+		       layer_slug, layer_offset, targeting_rule, created_at, updated_at
+		FROM segments
+		WHERE ($1 = '' OR $1 = ANY(tags)) AND ($2 = '' OR owner = $2)
+		ORDER BY slug
+		`, filter.Tag, filter.Owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	typeMap := pgtype.NewMap()
+	var segments []model.SegmentInfoDTO
+	for rows.Next() {
+		var segment model.SegmentInfoDTO
+		var autoPercent sql.NullFloat64
+		var activeFrom, activeUntil sql.NullTime
+		var layer sql.NullString
+		var layerOffset int
+		var rule []byte
+		if err := rows.Scan(
+			&segment.Slug,
+			&autoPercent,
+			&segment.Description,
+			&segment.Owner,
+			typeMap.SQLScanner(&segment.Tags),
+			&activeFrom,
+			&activeUntil,
+			&layer,
+			&layerOffset,
+			&rule,
+			&segment.Created_at,
+			&segment.Updated_at,
+		); err != nil {
+			return nil, err
+		}
+		if segment.Targeting_rule, err = ruleFromJSON(rule); err != nil {
+			return nil, err
+		}
+		if layer.Valid {
+			segment.Layer = layer.String
+			offset := layerOffset
+			segment.Layer_offset = &offset
+		}
+		segment.Auto_percent = nullFloatToPtr(autoPercent)
+		segment.Active_from = nullTimeToPtr(activeFrom)
+		segment.Active_until = nullTimeToPtr(activeUntil)
+		segments = append(segments, segment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", apperror.ErrDuringRowsIteration, err)
+	}
+	return segments, nil
+}
+
+func (r *pgxSegmentRepo) SegmentExists(ctx context.Context, slug string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM segments WHERE slug = $1)", slug).Scan(&exists)
+	return exists, err
+}
+
+func (r *pgxSegmentRepo) AddUserToSegment(ctx context.Context, userID int64, slug string, audit model.AuditDTO) error {
+	// Пользователь заодно попадает в реестр известных пользователей; назначение
+	// и запись истории выполняются одним запросом, поэтому либо пишутся оба, либо ни одно.
+	// Материализованная строка становится ручной, повторное ручное назначение — ошибка
+	res, err := r.db.ExecContext(ctx, `
+		WITH known AS (INSERT INTO users(user_id) VALUES($1) ON CONFLICT DO NOTHING),
+		assigned AS (
+			INSERT INTO user_segments(user_id, segment_slug) VALUES($1, $2)
+			ON CONFLICT (user_id, segment_slug) DO UPDATE SET source = 'manual' WHERE user_segments.source = 'auto'
+			RETURNING user_id, segment_slug)
+		INSERT INTO user_segment_history(user_id, segment_slug, operation, actor, reason)
+		SELECT user_id, segment_slug, $3, $4, $5 FROM assigned;
+		`,
+		userID, slug, model.OperationAdded, audit.Actor, audit.Reason)
+	if err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: user %d is already in segment %s", apperror.ErrCannotInsertT, userID, slug)
+	}
+	return nil
+}
+
+func (r *pgxSegmentRepo) GetAllSegmentsData(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error) {
+	return querySegmentsData(ctx, r.db, userID, "")
+}
+
+// GetSegmentData возвращает конфигурацию одного сегмента без данных о назначениях пользователя
+func (r *pgxSegmentRepo) GetSegmentData(ctx context.Context, slug string) (model.SegmentUserDataDTO, error) {
+	segments, err := querySegmentsData(ctx, r.db, 0, slug)
+	if err != nil {
+		return model.SegmentUserDataDTO{}, err
+	}
+	if len(segments) == 0 {
+		return model.SegmentUserDataDTO{}, apperror.ErrSegmentNotFound
+	}
+	return segments[0], nil
+}
+
+// queryer — общее у *sql.DB и *sql.Tx: запросы чтения выполняются и вне транзакции, и внутри неё
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// querySegmentsData выбирает конфигурацию сегментов вместе с назначениями пользователя userID;
+// непустой slug ограничивает выборку одним сегментом
+func querySegmentsData(ctx context.Context, q queryer, userID int64, slug string) ([]model.SegmentUserDataDTO, error) {
+	query := `
+        SELECT
+            s.slug,                   -- 1. Имя сегмента
+            s.auto_percent,           -- 2. Процент
+            us.expires_at,            -- 3. Время истечения (NULL, если не назначен вручную)
+            CASE WHEN us.user_id IS NOT NULL THEN TRUE ELSE FALSE END AS is_manual, -- 4. Назначен ли вручную
+            s.rollout_paused_at,      -- 5. Пауза плана раскатки
+            (SELECT json_agg(json_build_object('percent', rs.percent, 'starts_at', rs.starts_at) ORDER BY rs.starts_at)
+             FROM segment_rollout_steps rs WHERE rs.segment_slug = s.slug), -- 6. Шаги плана раскатки
+            s.active_from,            -- 7. Начало окна активности
+            s.active_until,           -- 8. Конец окна активности
+            COALESCE(s.layer_slug, ''), -- 9. Слой
+            s.layer_offset,           -- 10. Смещение в слое
+            s.targeting_rule,         -- 11. Правило таргетинга
+            COALESCE(so.mode, ''),    -- 12. Явное переопределение для пользователя
+            s.hash_salt               -- 13. Соль бакетирования
+        FROM 
+            segments s
+        LEFT JOIN 
+            user_segments us 
+            ON s.slug = us.segment_slug AND us.user_id = $1 AND us.source = 'manual'
+        LEFT JOIN
+            segment_overrides so
+            ON s.slug = so.segment_slug AND so.user_id = $1
+        WHERE $2 = '' OR s.slug = $2;
+    `
+	rows, err := q.QueryContext(ctx, query, userID, slug)
+	if err != nil {
+		return nil, fmt.Errorf("db query failed: %w", err)
+	}
+	defer rows.Close()
+	return scanSegmentsData(rows)
+}
+
+// scanSegmentsData читает строки в порядке колонок querySegmentsData
+func scanSegmentsData(rows *sql.Rows) ([]model.SegmentUserDataDTO, error) {
+	var results []model.SegmentUserDataDTO
+	for rows.Next() {
+		var dto model.SegmentUserDataDTO
+		var expiresAt sql.NullTime // Используем sql.NullTime для expires_at, т.к. может быть NULL
+		var pausedAt, activeFrom, activeUntil sql.NullTime
+		var autoPercent sql.NullFloat64
+		var steps, rule []byte
+		if err := rows.Scan(
+			&dto.Slug,
+			&autoPercent,
+			&expiresAt,
+			&dto.IsManuallyAssigned,
+			&pausedAt,
+			&steps,
+			&activeFrom,
+			&activeUntil,
+			&dto.Layer,
+			&dto.LayerOffset,
+			&rule,
+			&dto.Override,
+			&dto.Salt,
+		); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		var err error
+		if dto.TargetingRule, err = ruleFromJSON(rule); err != nil {
+			return nil, err
+		}
+		// Сегмент с правилом без auto_percent выдаётся всем, кто подходит под правило
+		switch {
+		case autoPercent.Valid:
+			dto.AutoPercent = autoPercent.Float64
+		case dto.TargetingRule != nil:
+			dto.AutoPercent = 100
+		}
+		dto.ActiveFrom = nullTimeToPtr(activeFrom)
+		dto.ActiveUntil = nullTimeToPtr(activeUntil)
+		if expiresAt.Valid {
+			dto.ExpiresAt = &expiresAt.Time
+		}
+		if pausedAt.Valid {
+			dto.RolloutPausedAt = &pausedAt.Time
+		}
+		if steps != nil {
+			if err := json.Unmarshal(steps, &dto.RolloutSteps); err != nil {
+				return nil, fmt.Errorf("failed to decode rollout steps: %w", err)
+			}
+		}
+		results = append(results, dto)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+	return results, nil
+}
+
+// StreamHistory читает историю по возрастанию (created_at, id) и передаёт записи в fn
+// по одной, не накапливая их в памяти. Ошибка fn прерывает чтение.
+func (r *pgxSegmentRepo) StreamHistory(ctx context.Context, filter model.HistoryFilterDTO, fn func(model.HistoryTableDTO) error) error {
+	var (
+		conditions []string
+		args       []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if filter.User_ID != nil {
+		conditions = append(conditions, "user_id = "+arg(*filter.User_ID))
+	}
+	if filter.Slug != "" {
+		conditions = append(conditions, "segment_slug = "+arg(filter.Slug))
+	}
+	if filter.Operation != "" {
+		conditions = append(conditions, "operation = "+arg(filter.Operation))
+	}
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor = "+arg(filter.Actor))
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "created_at >= "+arg(*filter.From))
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "created_at < "+arg(*filter.To))
+	}
+	if filter.After != nil {
+		conditions = append(conditions, fmt.Sprintf("(created_at, id) > (%s, %s)", arg(filter.After.Created_at), arg(filter.After.ID)))
+	}
+	query := "SELECT id, user_id, segment_slug, operation, created_at, actor, reason FROM user_segment_history"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at, id"
+	if filter.Limit > 0 {
+		query += " LIMIT " + arg(filter.Limit)
+	}
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("db query failed for history: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var dto model.HistoryTableDTO
+		if err := rows.Scan(&dto.ID, &dto.User_ID, &dto.Segment_slug, &dto.Operation, &dto.Created_at, &dto.Actor, &dto.Reason); err != nil {
+			return fmt.Errorf("scan failed: %w", err)
+		}
+		if err := fn(dto); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrDuringRowsIteration, err)
+	}
+	return nil
+}
+
+// UpdateUserSegments в одной транзакции удаляет removeSlugs, добавляет (или переназначает)
+// сегменты add и меняет expires_at у действующих назначений из extend
+func (r *pgxSegmentRepo) UpdateUserSegments(ctx context.Context, userID int64, add []model.SegmentAssignmentDTO, removeSlugs []string, extend []model.SegmentAssignmentDTO, audit model.AuditDTO) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrFailedBTransaction, err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "INSERT INTO users(user_id) VALUES($1) ON CONFLICT DO NOTHING", userID); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+	}
+	stmtRemove, err := tx.PrepareContext(ctx, "DELETE FROM user_segments WHERE user_id = $1 AND segment_slug = $2 AND source = 'manual'")
+	if err != nil {
+		return fmt.Errorf("failed to prepare remove statement: %w", err)
+	}
+	defer stmtRemove.Close()
+	stmtAdd, err := tx.PrepareContext(ctx, `
+        INSERT INTO user_segments (user_id, segment_slug, expires_at) 
+        VALUES ($1, $2, $3) 
+        ON CONFLICT (user_id, segment_slug) 
+        DO UPDATE SET expires_at = EXCLUDED.expires_at, source = 'manual'; 
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to prepare add statement: %w", err)
+	}
+	defer stmtAdd.Close()
+	stmtHistory, err := tx.PrepareContext(ctx, "INSERT INTO user_segment_history(user_id, segment_slug, operation, actor, reason, expires_at) VALUES($1, $2, $3, $4, $5, $6)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare history statement: %w", err)
+	}
+	defer stmtHistory.Close()
+	for _, slug := range removeSlugs {
+		if _, err := stmtRemove.ExecContext(ctx, userID, slug); err != nil {
+			return fmt.Errorf("%w: %w", apperror.ErrCannotDeleteFT, err)
+		}
+		if _, err = stmtHistory.ExecContext(ctx, userID, slug, model.OperationRemoved, audit.Actor, audit.Reason, nil); err != nil {
+			return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+		}
+	}
+	for _, assignment := range add {
+		if _, err := stmtAdd.ExecContext(ctx, userID, assignment.Slug, assignment.Expires_at); err != nil {
+			return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+		}
+		if _, err = stmtHistory.ExecContext(ctx, userID, assignment.Slug, model.OperationAdded, audit.Actor, audit.Reason, assignment.Expires_at); err != nil {
+			return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+		}
+	}
+	// Срок меняется только у действующих назначений: истёкшее назначение уже не выдаётся
+	for _, assignment := range extend {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE user_segments SET expires_at = $3
+			WHERE user_id = $1 AND segment_slug = $2 AND source = 'manual' AND (expires_at IS NULL OR expires_at > NOW())
+			`, userID, assignment.Slug, assignment.Expires_at)
+		if err != nil {
+			return fmt.Errorf("db update failed: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("db update failed: %w", err)
+		}
+		if affected == 0 {
+			return fmt.Errorf("%w: %s", apperror.ErrUserSegmentNotFound, assignment.Slug)
+		}
+		if _, err = stmtHistory.ExecContext(ctx, userID, assignment.Slug, model.OperationTTLUpdated, audit.Actor, audit.Reason, assignment.Expires_at); err != nil {
+			return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrFailedCTransaction, err)
+	}
+	return nil
+}
+
+// DeleteExpiredAssignments удаляет до limit назначений с истёкшим TTL и пишет
+// для каждого операцию EXPIRED в историю. Строки, заблокированные другой репликой,
+// пропускаются. Возвращает число удалённых назначений.
+func (r *pgxSegmentRepo) DeleteExpiredAssignments(ctx context.Context, limit int) (int, error) {
+	res, err := r.db.ExecContext(ctx, `
+		WITH expired AS (
+			DELETE FROM user_segments
+			WHERE (user_id, segment_slug) IN (
+				SELECT user_id, segment_slug FROM user_segments
+				WHERE expires_at < NOW()
+				ORDER BY expires_at
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED)
+			RETURNING user_id, segment_slug
+		)
+		INSERT INTO user_segment_history(user_id, segment_slug, operation, actor, reason)
+		SELECT user_id, segment_slug, $2, $3, $4 FROM expired
+		`, limit, model.OperationExpired, model.ActorTTLSweeper, model.ReasonExpired)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", apperror.ErrCannotDeleteFT, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", apperror.ErrCannotDeleteFT, err)
+	}
+	return int(affected), nil
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+)
+
+// GetUserAttributes возвращает сохранённые атрибуты пользователя; для неизвестного пользователя — пустой набор
+func (r *pgxSegmentRepo) GetUserAttributes(ctx context.Context, userID int64) (map[string]any, error) {
+	var raw []byte
+	err := r.db.QueryRowContext(ctx, "SELECT attributes FROM users WHERE user_id = $1", userID).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return map[string]any{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("db query failed: %w", err)
+	}
+	attrs := make(map[string]any)
+	if err := json.Unmarshal(raw, &attrs); err != nil {
+		return nil, fmt.Errorf("failed to decode user attributes: %w", err)
+	}
+	return attrs, nil
+}
+
+// SetUserAttributes полностью заменяет атрибуты пользователя
+func (r *pgxSegmentRepo) SetUserAttributes(ctx context.Context, userID int64, attrs map[string]any) error {
+	encoded, err := json.Marshal(attrs)
+	if err != nil {
+		return fmt.Errorf("failed to encode user attributes: %w", err)
+	}
+	if _, err := r.db.ExecContext(ctx, `
+		INSERT INTO users(user_id, attributes) VALUES($1, $2::jsonb)
+		ON CONFLICT (user_id)
+		DO UPDATE SET attributes = EXCLUDED.attributes, updated_at = NOW()
+		`, userID, string(encoded)); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+	}
+	return nil
+}
+
+// MergeUserAttributes дополняет атрибуты пользователя; ключи со значением null удаляются
+func (r *pgxSegmentRepo) MergeUserAttributes(ctx context.Context, userID int64, attrs map[string]any) error {
+	encoded, err := json.Marshal(attrs)
+	if err != nil {
+		return fmt.Errorf("failed to encode user attributes: %w", err)
+	}
+	if _, err := r.db.ExecContext(ctx, `
+		INSERT INTO users(user_id, attributes) VALUES($1, jsonb_strip_nulls($2::jsonb))
+		ON CONFLICT (user_id)
+		DO UPDATE SET attributes = jsonb_strip_nulls(users.attributes || $2::jsonb), updated_at = NOW()
+		`, userID, string(encoded)); err != nil {
+		return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+	}
+	return nil
+}
+
+// ListUsers возвращает страницу реестра пользователей с user_id больше afterID
+func (r *pgxSegmentRepo) ListUsers(ctx context.Context, afterID int64, limit int) ([]model.UserAttributesDTO, error) {
+	return listUsers(ctx, r.db, afterID, limit)
+}
+
+func listUsers(ctx context.Context, q queryer, afterID int64, limit int) ([]model.UserAttributesDTO, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT user_id, attributes FROM users
+		WHERE user_id > $1
+		ORDER BY user_id
+		LIMIT $2
+		`, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("db query failed: %w", err)
+	}
+	defer rows.Close()
+	var users []model.UserAttributesDTO
+	for rows.Next() {
+		var user model.UserAttributesDTO
+		var raw []byte
+		if err := rows.Scan(&user.UserID, &raw); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		if err := json.Unmarshal(raw, &user.Attributes); err != nil {
+			return nil, fmt.Errorf("failed to decode user attributes: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", apperror.ErrDuringRowsIteration, err)
+	}
+	return users, nil
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"time"
+)
+
+// GetUserSegmentsAsOf восстанавливает сегменты пользователя на момент asOf: ручные назначения
+// и переопределения — по истории, auto_percent и правила — по действовавшей тогда конфигурации.
+// Атрибуты пользователя не историзируются, используются текущие вместе с переданными.
+func (s *UserService) GetUserSegmentsAsOf(ctx context.Context, userID int64, attrs map[string]any, asOf time.Time) ([]model.SegmentUserDataDTO, error) {
+	if userID <= 0 {
+		return nil, apperror.ErrUserIDInvalid
+	}
+	if asOf.After(time.Now()) {
+		return nil, apperror.ErrAsOfFuture
+	}
+	normalized, err := s.userAttributes(ctx, userID, attrs)
+	if err != nil {
+		return nil, err
+	}
+	userSegments, err := s.segRepo.GetSegmentsDataAsOf(ctx, userID, asOf)
+	if err != nil {
+		return nil, err
+	}
+	return evaluateSegments(userID, userSegments, normalized, asOf), nil
+}
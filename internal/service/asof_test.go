@@ -0,0 +1,47 @@
+package service
+
+import (
+	"errors"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"testing"
+	"time"
+)
+
+func TestUserService_GetUserSegmentsAsOf(t *testing.T) {
+	asOf := timePast.Add(-time.Hour)
+	expiredAt := asOf.Add(-time.Minute)
+	mockRepo := &MockSegmentRepo{
+		segmentsAsOf: []model.SegmentUserDataDTO{
+			// Сегмент закончился вчера, но на момент asOf ещё действовал
+			{Slug: "ENDED_MANUAL", IsManuallyAssigned: true, ActiveUntil: &timePast},
+			{Slug: "AUTO_HIT", AutoPercent: 50},
+			// Сегмент начал действовать после asOf
+			{Slug: "LATER_MANUAL", IsManuallyAssigned: true, ActiveFrom: &timePast},
+			// TTL истёк до asOf, хотя фоновая очистка могла ещё не записать EXPIRED
+			{Slug: "EXPIRED_MANUAL", IsManuallyAssigned: true, ExpiresAt: &expiredAt},
+		},
+	}
+	userService := NewUserService(mockRepo)
+	segments, err := userService.GetUserSegmentsAsOf(ctx, 1003, nil, asOf)
+	if err != nil {
+		t.Fatalf("GetUserSegmentsAsOf() unexpected error = %v", err)
+	}
+	if !mockRepo.asOf.Equal(asOf) {
+		t.Errorf("GetSegmentsDataAsOf() called with %v, want %v", mockRepo.asOf, asOf)
+	}
+	var slugs []string
+	for _, segment := range segments {
+		slugs = append(slugs, segment.Slug)
+	}
+	if len(slugs) != 2 || slugs[0] != "ENDED_MANUAL" || slugs[1] != "AUTO_HIT" {
+		t.Errorf("GetUserSegmentsAsOf() = %v, want [ENDED_MANUAL AUTO_HIT]", slugs)
+	}
+}
+
+func TestUserService_GetUserSegmentsAsOf_Future(t *testing.T) {
+	_, err := NewUserService(&MockSegmentRepo{}).GetUserSegmentsAsOf(ctx, 1000, nil, timeFuture)
+	if !errors.Is(err, apperror.ErrAsOfFuture) {
+		t.Errorf("GetUserSegmentsAsOf() error = %v, want %v", err, apperror.ErrAsOfFuture)
+	}
+}
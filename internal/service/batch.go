@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"progression1/internal/repository"
+	"time"
+)
+
+// batchMaxUsers — наибольшее число пользователей в одном пакетном запросе
+const batchMaxUsers = 1000
+
+// GetUsersSegments вычисляет активные сегменты сразу для нескольких пользователей. Конфигурация
+// сегментов читается один раз, назначения и переопределения всех пользователей — одним запросом;
+// атрибуты из реестра подгружаются, только если у сегментов есть правила таргетинга.
+func (s *UserService) GetUsersSegments(ctx context.Context, userIDs []int64) (map[int64][]model.SegmentUserDataDTO, error) {
+	if len(userIDs) == 0 || len(userIDs) > batchMaxUsers {
+		return nil, apperror.ErrBatchSize
+	}
+	for _, userID := range userIDs {
+		if userID <= 0 {
+			return nil, apperror.ErrUserIDInvalid
+		}
+	}
+	config, err := s.segRepo.GetSegmentsConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	assignments, err := s.segRepo.GetUsersAssignments(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	var storedAttrs map[int64]map[string]any
+	for _, segment := range config {
+		if segment.TargetingRule != nil {
+			if storedAttrs, err = s.segRepo.GetUsersAttributes(ctx, userIDs); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	byUser := make(map[int64][]model.UserAssignmentDTO)
+	for _, assignment := range assignments {
+		byUser[assignment.UserID] = append(byUser[assignment.UserID], assignment)
+	}
+	now := time.Now()
+	result := make(map[int64][]model.SegmentUserDataDTO, len(userIDs))
+	for _, userID := range userIDs {
+		if _, done := result[userID]; done {
+			continue
+		}
+		attrs, err := normalizeAttributes(storedAttrs[userID])
+		if err != nil {
+			return nil, err
+		}
+		userSegments := repository.ApplyUserAssignments(config, byUser[userID])
+		active := evaluateSegments(userID, userSegments, attrs, now)
+		if active == nil {
+			active = []model.SegmentUserDataDTO{}
+		}
+		result[userID] = active
+	}
+	return result, nil
+}
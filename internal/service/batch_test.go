@@ -0,0 +1,90 @@
+package service
+
+import (
+	"errors"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"reflect"
+	"testing"
+)
+
+func TestUserService_GetUsersSegments(t *testing.T) {
+	rule := &model.TargetingRuleDTO{All: []model.TargetingConditionDTO{{Attribute: "country", Op: "eq", Value: "RU"}}}
+	mockRepo := &MockSegmentRepo{
+		segmentsConfig: []model.SegmentUserDataDTO{
+			{Slug: "AVITO_VOICE"},
+			{Slug: "AVITO_CHAT"},
+			{Slug: "AVITO_ALL", AutoPercent: 100},
+			{Slug: "AVITO_RU", AutoPercent: 100, TargetingRule: rule},
+		},
+		assignments: []model.UserAssignmentDTO{
+			{UserID: 1, Slug: "AVITO_VOICE", Manual: true, ExpiresAt: &timeFuture},
+			{UserID: 2, Slug: "AVITO_CHAT", Manual: true, ExpiresAt: &timePast},
+			{UserID: 3, Slug: "AVITO_ALL", Override: model.OverrideExclude},
+			{UserID: 3, Slug: "DELETED_SEGMENT", Manual: true},
+		},
+		usersAttributes: map[int64]map[string]any{1: {"country": "RU"}, 2: {"country": "KZ"}},
+	}
+	userService := NewUserService(mockRepo)
+	users, err := userService.GetUsersSegments(ctx, []int64{1, 2, 3, 4, 1})
+	if err != nil {
+		t.Fatalf("GetUsersSegments() unexpected error = %v", err)
+	}
+	want := map[int64][]string{
+		1: {"AVITO_VOICE", "AVITO_ALL", "AVITO_RU"},
+		2: {"AVITO_ALL"},
+		3: {},
+		4: {"AVITO_ALL"},
+	}
+	got := make(map[int64][]string, len(users))
+	for userID, segments := range users {
+		got[userID] = []string{}
+		for _, segment := range segments {
+			got[userID] = append(got[userID], segment.Slug)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetUsersSegments() = %v, want %v", got, want)
+	}
+	if mockRepo.usersAttributesCalls != 1 {
+		t.Errorf("GetUsersAttributes() calls = %d, want 1", mockRepo.usersAttributesCalls)
+	}
+	// Назначение одного пользователя не должно попадать в сегменты другого
+	if mockRepo.segmentsConfig[0].IsManuallyAssigned {
+		t.Errorf("GetUsersSegments() modified the shared segment config")
+	}
+}
+
+func TestUserService_GetUsersSegments_WithoutRules(t *testing.T) {
+	mockRepo := &MockSegmentRepo{segmentsConfig: []model.SegmentUserDataDTO{{Slug: "AVITO_ALL", AutoPercent: 100}}}
+	if _, err := NewUserService(mockRepo).GetUsersSegments(ctx, []int64{1}); err != nil {
+		t.Fatalf("GetUsersSegments() unexpected error = %v", err)
+	}
+	if mockRepo.usersAttributesCalls != 0 {
+		t.Errorf("GetUsersAttributes() must not be called without targeting rules")
+	}
+}
+
+func TestUserService_GetUsersSegments_Validation(t *testing.T) {
+	tooMany := make([]int64, batchMaxUsers+1)
+	for i := range tooMany {
+		tooMany[i] = int64(i + 1)
+	}
+	tests := []struct {
+		name    string
+		userIDs []int64
+		wantErr error
+	}{
+		{name: "Empty list", wantErr: apperror.ErrBatchSize},
+		{name: "Too many users", userIDs: tooMany, wantErr: apperror.ErrBatchSize},
+		{name: "Invalid user ID", userIDs: []int64{1, 0}, wantErr: apperror.ErrUserIDInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewUserService(&MockSegmentRepo{}).GetUsersSegments(ctx, tt.userIDs)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("GetUsersSegments() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	historyDefaultLimit = 100
+	historyMaxLimit     = 1000
+)
+
+// encodeHistoryCursor кодирует позицию (created_at, id) в непрозрачный токен
+func encodeHistoryCursor(entry model.HistoryTableDTO) string {
+	raw := strconv.FormatInt(entry.Created_at.UnixMicro(), 10) + ":" + strconv.Itoa(entry.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeHistoryCursor(token string) (*model.HistoryCursorDTO, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, apperror.ErrHistoryCursor
+	}
+	micros, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, apperror.ErrHistoryCursor
+	}
+	ts, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return nil, apperror.ErrHistoryCursor
+	}
+	entryID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, apperror.ErrHistoryCursor
+	}
+	return &model.HistoryCursorDTO{Created_at: time.UnixMicro(ts).UTC(), ID: entryID}, nil
+}
+
+// MonthRange возвращает границы [from, to) завершённого месяца
+func MonthRange(year, month int) (time.Time, time.Time, error) {
+	if err := yearAmonthValidate(year, month); err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: %w", apperror.ErrReportPeriod, err)
+	}
+	from := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	return from, from.AddDate(0, 1, 0), nil
+}
+
+// StreamAllHistory передаёт в fn все записи истории, подходящие под filter, без разбиения на страницы.
+// Используется прежним форматом GET /segments/history (массив без cursor и limit)
+func (s *UserService) StreamAllHistory(ctx context.Context, filter model.HistoryFilterDTO, fn func(model.HistoryTableDTO) error) error {
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return apperror.ErrHistoryRange
+	}
+	filter.Limit, filter.After = 0, nil
+	return s.segRepo.StreamHistory(ctx, filter, fn)
+}
+
+// StreamHistory передаёт в fn одну страницу истории (не больше filter.Limit записей)
+// начиная после cursor и возвращает курсор следующей страницы; пустой курсор — страниц больше нет
+func (s *UserService) StreamHistory(ctx context.Context, filter model.HistoryFilterDTO, cursor string, fn func(model.HistoryTableDTO) error) (string, error) {
+	if filter.Limit == 0 {
+		filter.Limit = historyDefaultLimit
+	}
+	if filter.Limit < 0 || filter.Limit > historyMaxLimit {
+		return "", apperror.ErrHistoryLimit
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return "", apperror.ErrHistoryRange
+	}
+	if cursor != "" {
+		after, err := decodeHistoryCursor(cursor)
+		if err != nil {
+			return "", err
+		}
+		filter.After = after
+	}
+	// Читаем на одну запись больше, чтобы узнать, есть ли следующая страница
+	limit := filter.Limit
+	filter.Limit++
+	var (
+		count int
+		last  model.HistoryTableDTO
+		more  bool
+	)
+	err := s.segRepo.StreamHistory(ctx, filter, func(entry model.HistoryTableDTO) error {
+		if count == limit {
+			more = true
+			return nil
+		}
+		count++
+		last = entry
+		return fn(entry)
+	})
+	if err != nil {
+		return "", err
+	}
+	if !more {
+		return "", nil
+	}
+	return encodeHistoryCursor(last), nil
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"errors"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"testing"
+	"time"
+)
+
+func TestUserService_StreamHistory_Pagination(t *testing.T) {
+	createdAt := time.Date(2023, 8, 15, 10, 30, 0, 0, time.UTC)
+	// Две записи с одинаковым created_at различаются по id
+	mockRepo := &MockSegmentRepo{
+		history: []model.HistoryTableDTO{
+			{ID: 1, User_ID: 1000, Segment_slug: "AVITO_VOICE_MESSAGES", Operation: model.OperationAdded, Created_at: createdAt},
+			{ID: 2, User_ID: 1001, Segment_slug: "AVITO_VOICE_MESSAGES", Operation: model.OperationAdded, Created_at: createdAt},
+			{ID: 3, User_ID: 1000, Segment_slug: "AVITO_VOICE_MESSAGES", Operation: model.OperationRemoved, Created_at: createdAt.Add(time.Hour)},
+		},
+	}
+	userService := NewUserService(mockRepo)
+
+	var ids []int
+	collect := func(entry model.HistoryTableDTO) error {
+		ids = append(ids, entry.ID)
+		return nil
+	}
+	cursor := ""
+	for page := 0; page < 3; page++ {
+		next, err := userService.StreamHistory(ctx, model.HistoryFilterDTO{Limit: 2}, cursor, collect)
+		if err != nil {
+			t.Fatalf("StreamHistory() unexpected error = %v", err)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(ids) != 3 || ids[0] != 1 || ids[1] != 2 || ids[2] != 3 {
+		t.Errorf("StreamHistory() ids = %v, want [1 2 3]", ids)
+	}
+}
+
+func TestUserService_StreamHistory_LastPageHasNoCursor(t *testing.T) {
+	mockRepo := &MockSegmentRepo{
+		history: []model.HistoryTableDTO{{ID: 1, User_ID: 1000, Segment_slug: "AVITO_VOICE_MESSAGES", Operation: model.OperationAdded, Created_at: timeNow}},
+	}
+	next, err := NewUserService(mockRepo).StreamHistory(ctx, model.HistoryFilterDTO{Limit: 1}, "", func(model.HistoryTableDTO) error { return nil })
+	if err != nil {
+		t.Fatalf("StreamHistory() unexpected error = %v", err)
+	}
+	if next != "" {
+		t.Errorf("StreamHistory() next cursor = %q, want empty", next)
+	}
+}
+
+func TestUserService_StreamHistory_Validation(t *testing.T) {
+	userService := NewUserService(&MockSegmentRepo{})
+	noop := func(model.HistoryTableDTO) error { return nil }
+	tests := []struct {
+		name    string
+		filter  model.HistoryFilterDTO
+		cursor  string
+		wantErr error
+	}{
+		{name: "Limit too big", filter: model.HistoryFilterDTO{Limit: 1001}, wantErr: apperror.ErrHistoryLimit},
+		{name: "Empty range", filter: model.HistoryFilterDTO{From: &timeNow, To: &timePast}, wantErr: apperror.ErrHistoryRange},
+		{name: "Garbage cursor", cursor: "not a cursor", wantErr: apperror.ErrHistoryCursor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := userService.StreamHistory(ctx, tt.filter, tt.cursor, noop); !errors.Is(err, tt.wantErr) {
+				t.Errorf("StreamHistory() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUserService_StreamAllHistory(t *testing.T) {
+	mockRepo := &MockSegmentRepo{}
+	for id := 1; id <= historyDefaultLimit+1; id++ {
+		mockRepo.history = append(mockRepo.history, model.HistoryTableDTO{ID: id, User_ID: 1000, Segment_slug: "AVITO_VOICE_MESSAGES", Operation: model.OperationAdded, Created_at: timeNow})
+	}
+	userService := NewUserService(mockRepo)
+	count := 0
+	err := userService.StreamAllHistory(ctx, model.HistoryFilterDTO{}, func(model.HistoryTableDTO) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamAllHistory() unexpected error = %v", err)
+	}
+	// Прежний формат не ограничен размером страницы по умолчанию
+	if count != historyDefaultLimit+1 {
+		t.Errorf("StreamAllHistory() streamed %d entries, want %d", count, historyDefaultLimit+1)
+	}
+	if err := userService.StreamAllHistory(ctx, model.HistoryFilterDTO{From: &timeNow, To: &timePast}, func(model.HistoryTableDTO) error { return nil }); !errors.Is(err, apperror.ErrHistoryRange) {
+		t.Errorf("StreamAllHistory() error = %v, want %v", err, apperror.ErrHistoryRange)
+	}
+}
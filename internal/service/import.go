@@ -0,0 +1,127 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// importBatchSize — сколько пользователей добавляется в сегменты одним запросом
+	importBatchSize = 5000
+	// importMaxErrors — сколько отклонённых строк перечисляется в ответе
+	importMaxErrors = 100
+)
+
+// ImportSegmentUsers читает из src файл с ID пользователя в первой колонке (разделители
+// ",", ";" или табуляция) и пачками добавляет пользователей во все сегменты request.Segments.
+// Файл читается потоком и в память целиком не загружается. Первая строка, не являющаяся
+// числом, считается заголовком; пустые строки пропускаются; строки с невалидным ID
+// отклоняются и не прерывают импорт. Повторный импорт того же файла безопасен: существующим
+// назначениям обновляется срок действия.
+func (s *UserService) ImportSegmentUsers(ctx context.Context, src io.Reader, request model.ImportRequestDTO, audit model.AuditDTO) (model.ImportResultDTO, error) {
+	result := model.ImportResultDTO{Segments: request.Segments}
+	expiresAt, err := s.importValidate(ctx, request, audit)
+	if err != nil {
+		return result, err
+	}
+	batch := make([]int64, 0, importBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := s.segRepo.ImportUserSegments(ctx, batch, request.Segments, expiresAt, audit); err != nil {
+			return err
+		}
+		result.Accepted += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	reject := func(line int, err error) {
+		result.Rejected++
+		if len(result.Errors) < importMaxErrors {
+			result.Errors = append(result.Errors, model.ImportLineErrorDTO{Line: line, Error: err.Error()})
+		}
+	}
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		field, _, _ := strings.Cut(scanner.Text(), ",")
+		field, _, _ = strings.Cut(field, ";")
+		field, _, _ = strings.Cut(field, "\t")
+		field = strings.Trim(strings.TrimSpace(field), `"`)
+		if field == "" {
+			continue
+		}
+		userID, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			if line == 1 && errors.Is(err, strconv.ErrSyntax) {
+				continue
+			}
+			reject(line, apperror.ErrUserIDInvalid)
+			continue
+		}
+		if userID <= 0 {
+			reject(line, apperror.ErrUserIDInvalid)
+			continue
+		}
+		batch = append(batch, userID)
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("failed to read import file at line %d: %w", line+1, err)
+	}
+	if err := flush(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// importValidate проверяет сегменты и срок действия импорта и возвращает expires_at назначений
+func (s *UserService) importValidate(ctx context.Context, request model.ImportRequestDTO, audit model.AuditDTO) (*time.Time, error) {
+	if len(request.Segments) == 0 {
+		return nil, apperror.ErrImportNoSegments
+	}
+	if len(request.Segments) > 100 {
+		return nil, apperror.ErrTooManySegments
+	}
+	if err := auditValidate(audit); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{}, len(request.Segments))
+	for _, slug := range request.Segments {
+		if err := slugValidate(slug); err != nil {
+			return nil, err
+		}
+		if _, ok := seen[slug]; ok {
+			return nil, fmt.Errorf("%w: %s", apperror.ErrSegmentDuplicate, slug)
+		}
+		seen[slug] = struct{}{}
+		segment, err := s.segRepo.GetSegmentData(ctx, slug)
+		if err != nil {
+			return nil, err
+		}
+		// Сегменты слоя выдаются по бакетам слоя; ручные назначения пачкой обошли бы проверку пересечений
+		if segment.Layer != "" {
+			return nil, fmt.Errorf("%w: %s", apperror.ErrImportLayer, slug)
+		}
+	}
+	assignment := model.SegmentAssignmentDTO{TTL_hours: request.TTL_hours}
+	if err := resolveExpiry(&assignment, time.Now()); err != nil {
+		return nil, err
+	}
+	return assignment.Expires_at, nil
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"errors"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"strings"
+	"testing"
+)
+
+func TestUserService_ImportSegmentUsers(t *testing.T) {
+	mockRepo := &MockSegmentRepo{}
+	userService := NewUserService(mockRepo)
+	ttl := 24
+	file := "user_id;source\n1000;crm\n\n1001\nabc\n-5\n\"1002\",x\n99999999999999999999\n"
+	result, err := userService.ImportSegmentUsers(ctx, strings.NewReader(file),
+		model.ImportRequestDTO{Segments: []string{"AVITO_VOICE", "AVITO_CHAT"}, TTL_hours: &ttl}, model.AuditDTO{Actor: "analytics"})
+	if err != nil {
+		t.Fatalf("ImportSegmentUsers() unexpected error = %v", err)
+	}
+	if result.Accepted != 3 || result.Rejected != 3 {
+		t.Errorf("ImportSegmentUsers() accepted = %d, rejected = %d, want 3 and 3", result.Accepted, result.Rejected)
+	}
+	if len(mockRepo.imported) != 3 || mockRepo.imported[0] != 1000 || mockRepo.imported[2] != 1002 {
+		t.Errorf("ImportUserSegments() got users %v, want [1000 1001 1002]", mockRepo.imported)
+	}
+	// Заголовок и пустая строка не считаются, отклонённые строки перечисляются с номерами
+	var lines []int
+	for _, lineErr := range result.Errors {
+		lines = append(lines, lineErr.Line)
+	}
+	if len(lines) != 3 || lines[0] != 5 || lines[1] != 6 || lines[2] != 8 {
+		t.Errorf("ImportSegmentUsers() rejected lines = %v, want [5 6 8]", lines)
+	}
+	if mockRepo.importExpiresAt == nil {
+		t.Errorf("ImportUserSegments() expected expires_at from ttl_hours")
+	}
+}
+
+func TestUserService_ImportSegmentUsers_Validation(t *testing.T) {
+	ttl := 720
+	tests := []struct {
+		name    string
+		repo    *MockSegmentRepo
+		request model.ImportRequestDTO
+		wantErr error
+	}{
+		{name: "No segments", repo: &MockSegmentRepo{}, wantErr: apperror.ErrImportNoSegments},
+		{name: "Duplicate segment", repo: &MockSegmentRepo{}, request: model.ImportRequestDTO{Segments: []string{"AVITO_VOICE", "AVITO_VOICE"}}, wantErr: apperror.ErrSegmentDuplicate},
+		{name: "TTL out of range", repo: &MockSegmentRepo{}, request: model.ImportRequestDTO{Segments: []string{"AVITO_VOICE"}, TTL_hours: &ttl}, wantErr: apperror.ErrTTLRange},
+		{name: "Layer segment", repo: &MockSegmentRepo{segmentData: model.SegmentUserDataDTO{Slug: "EXP_A", Layer: "checkout"}}, request: model.ImportRequestDTO{Segments: []string{"EXP_A"}}, wantErr: apperror.ErrImportLayer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewUserService(tt.repo).ImportSegmentUsers(ctx, strings.NewReader("1000\n"), tt.request, model.AuditDTO{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ImportSegmentUsers() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(tt.repo.imported) != 0 {
+				t.Errorf("ImportUserSegments() must not be called, got users %v", tt.repo.imported)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"progression1/internal/repository"
+	"time"
+)
+
+func (s *UserService) CreateLayer(ctx context.Context, layer model.LayerDTO) error {
+	if err := slugValidate(layer.Slug); err != nil {
+		return err
+	}
+	if len(layer.Description) > 1000 {
+		return apperror.ErrDescriptionLength
+	}
+	return s.segRepo.CreateLayer(ctx, layer)
+}
+
+func (s *UserService) GetLayers(ctx context.Context) ([]model.LayerDTO, error) {
+	return s.segRepo.GetLayers(ctx)
+}
+
+// layerValidate проверяет слой нового сегмента. Диапазон бакетов выбирается (или явное
+// смещение проверяется) при создании сегмента под блокировкой слоя
+func (s *UserService) layerValidate(ctx context.Context, segment model.SegmentDTO) error {
+	if err := slugValidate(segment.Layer); err != nil {
+		return fmt.Errorf("layer: %w", err)
+	}
+	exists, err := s.segRepo.LayerExists(ctx, segment.Layer)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return apperror.ErrLayerNotFound
+	}
+	// Сегмент с правилом без auto_percent выдаётся всем подходящим, то есть занял бы весь слой
+	if segment.Auto_percent == nil && segment.Targeting_rule != nil && len(segment.Targeting_rule.All) > 0 {
+		return apperror.ErrLayerRule
+	}
+	if segment.Auto_percent != nil && *segment.Auto_percent != math.Trunc(*segment.Auto_percent) {
+		return apperror.ErrLayerPercent
+	}
+	if segment.Layer_offset != nil && (*segment.Layer_offset < 0 || *segment.Layer_offset > 99) {
+		return apperror.ErrLayerOffset
+	}
+	return nil
+}
+
+// ensureLayerCapacity заранее проверяет, что сегмент слоя может расшириться до percent бакетов;
+// окончательно диапазон проверяется при сохранении под блокировкой слоя
+func (s *UserService) ensureLayerCapacity(ctx context.Context, slug string, percent float64) error {
+	layer, err := s.segRepo.GetSegmentLayer(ctx, slug)
+	if err != nil || layer == "" {
+		return err
+	}
+	if percent != math.Trunc(percent) {
+		return apperror.ErrLayerPercent
+	}
+	width := int(percent)
+	segments, err := s.segRepo.GetLayerSegments(ctx, layer)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment.Segment_slug != slug || width <= segment.Max_percent {
+			continue
+		}
+		if !repository.LayerRangeFree(segments, slug, segment.Layer_offset, width) {
+			return apperror.ErrLayerOverflow
+		}
+	}
+	return nil
+}
+
+// checkLayerConflicts не даёт вручную добавить пользователя во второй сегмент слоя
+func (s *UserService) checkLayerConflicts(ctx context.Context, userID int64, addSlugs []string, removeSlugs []string) error {
+	if len(addSlugs) == 0 {
+		return nil
+	}
+	userSegments, err := s.segRepo.GetAllSegmentsData(ctx, userID)
+	if err != nil {
+		return err
+	}
+	// Без атрибутов сегменты слоя, выданные по правилу, не считались бы занимающими слой
+	attrs, err := s.userAttributes(ctx, userID, nil)
+	if err != nil {
+		return err
+	}
+	layerOf := make(map[string]string, len(userSegments))
+	for _, userSegment := range userSegments {
+		layerOf[userSegment.Slug] = userSegment.Layer
+	}
+	skip := make(map[string]struct{}, len(addSlugs)+len(removeSlugs))
+	for _, slug := range removeSlugs {
+		skip[slug] = struct{}{}
+	}
+	for _, slug := range addSlugs {
+		skip[slug] = struct{}{}
+	}
+	occupied := make(map[string]string)
+	for _, active := range evaluateSegments(userID, userSegments, attrs, time.Now()) {
+		if _, ok := skip[active.Slug]; ok || active.Layer == "" {
+			continue
+		}
+		occupied[active.Layer] = active.Slug
+	}
+	for _, slug := range addSlugs {
+		layer := layerOf[slug]
+		if layer == "" {
+			continue
+		}
+		if other, ok := occupied[layer]; ok && other != slug {
+			return fmt.Errorf("%w: %s and %s in layer %s", apperror.ErrLayerConflict, other, slug, layer)
+		}
+		occupied[layer] = slug
+	}
+	return nil
+}
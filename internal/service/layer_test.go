@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"progression1/internal/repository"
+	"testing"
+	"time"
+)
+
+func TestAllocateLayerOffset(t *testing.T) {
+	segments := []model.LayerSegmentDTO{
+		{Segment_slug: "EXP_A", Layer_offset: 0, Max_percent: 30},
+		{Segment_slug: "EXP_B", Layer_offset: 50, Max_percent: 20},
+	}
+	tests := []struct {
+		name   string
+		width  int
+		want   int
+		wantOk bool
+	}{
+		{name: "FirstGap", width: 20, want: 30, wantOk: true},
+		{name: "SecondGap", width: 25, want: 70, wantOk: true},
+		{name: "NoRoom", width: 40, wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := repository.AllocateLayerOffset(segments, "EXP_NEW", tt.width)
+			if ok != tt.wantOk || (ok && got != tt.want) {
+				t.Errorf("allocateLayerOffset(%d) = %d, %v, want %d, %v", tt.width, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestEvaluateSegments_LayerExclusive(t *testing.T) {
+	userSegments := []model.SegmentUserDataDTO{
+		{Slug: "EXP_A", AutoPercent: 50, Layer: "CHECKOUT", LayerOffset: 0},
+		{Slug: "EXP_B", AutoPercent: 50, Layer: "CHECKOUT", LayerOffset: 50},
+	}
+	for userID := int64(1); userID <= 1000; userID++ {
+		if got := len(evaluateSegments(userID, userSegments, nil, time.Now())); got != 1 {
+			t.Fatalf("user %d is in %d segments of one layer, want exactly 1", userID, got)
+		}
+	}
+}
+
+func TestUserService_UpdateUserSegments_LayerConflict(t *testing.T) {
+	mockRepo := &MockSegmentRepo{
+		getAllSegmentsData: func(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error) {
+			return []model.SegmentUserDataDTO{
+				{Slug: "EXP_A", IsManuallyAssigned: true, Layer: "CHECKOUT"},
+				{Slug: "EXP_B", Layer: "CHECKOUT", LayerOffset: 50},
+				{Slug: "EXP_C", Layer: "CHECKOUT", LayerOffset: 60},
+			}, nil
+		},
+		updateUserSegments: func(ctx context.Context, userID int64, add []model.SegmentAssignmentDTO, removeSlugs []string, extend []model.SegmentAssignmentDTO) error {
+			return nil
+		},
+	}
+	userService := NewUserService(mockRepo)
+	t.Run("Error_SecondSegmentInLayer", func(t *testing.T) {
+		err := userService.UpdateUserSegments(ctx, 1000, model.SegmentUpdateUserDTO{AddSlugs: []string{"EXP_B"}}, model.AuditDTO{})
+		if !errors.Is(err, apperror.ErrLayerConflict) {
+			t.Fatalf("Expected ErrLayerConflict, got: %v", err)
+		}
+	})
+	t.Run("Error_TwoAddsInLayer", func(t *testing.T) {
+		err := userService.UpdateUserSegments(ctx, 1000, model.SegmentUpdateUserDTO{AddSlugs: []string{"EXP_B", "EXP_C"}, RemoveSlugs: []string{"EXP_A"}}, model.AuditDTO{})
+		if !errors.Is(err, apperror.ErrLayerConflict) {
+			t.Fatalf("Expected ErrLayerConflict, got: %v", err)
+		}
+	})
+	t.Run("Success_SwapInLayer", func(t *testing.T) {
+		if err := userService.UpdateUserSegments(ctx, 1000, model.SegmentUpdateUserDTO{AddSlugs: []string{"EXP_B"}, RemoveSlugs: []string{"EXP_A"}}, model.AuditDTO{}); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+	})
+}
+
+func TestUserService_UpdateUserSegments_LayerConflictByRule(t *testing.T) {
+	mockRepo := &MockSegmentRepo{
+		getAllSegmentsData: func(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error) {
+			return []model.SegmentUserDataDTO{
+				{Slug: "EXP_RU", AutoPercent: 100, Layer: "CHECKOUT", TargetingRule: &model.TargetingRuleDTO{All: []model.TargetingConditionDTO{{Attribute: "country", Op: "eq", Value: "RU"}}}},
+				{Slug: "EXP_B", Layer: "CHECKOUT"},
+			}, nil
+		},
+		updateUserSegments: func(ctx context.Context, userID int64, add []model.SegmentAssignmentDTO, removeSlugs []string, extend []model.SegmentAssignmentDTO) error {
+			return nil
+		},
+		userAttributes: map[string]any{"country": "RU"},
+	}
+	// Пользователь уже попадает в EXP_RU по правилу, второй сегмент слоя ему выдать нельзя
+	err := NewUserService(mockRepo).UpdateUserSegments(ctx, 1000, model.SegmentUpdateUserDTO{AddSlugs: []string{"EXP_B"}}, model.AuditDTO{})
+	if !errors.Is(err, apperror.ErrLayerConflict) {
+		t.Fatalf("Expected ErrLayerConflict, got: %v", err)
+	}
+	mockRepo.userAttributes = map[string]any{"country": "DE"}
+	if err := NewUserService(mockRepo).UpdateUserSegments(ctx, 1000, model.SegmentUpdateUserDTO{AddSlugs: []string{"EXP_B"}}, model.AuditDTO{}); err != nil {
+		t.Fatalf("Expected no error when rule does not match, got: %v", err)
+	}
+}
+
+func TestUserService_UpdateSegment_LayerOverflow(t *testing.T) {
+	mockRepo := &MockSegmentRepo{
+		segmentLayer: "CHECKOUT",
+		layerSegments: []model.LayerSegmentDTO{
+			{Segment_slug: "EXP_A", Layer_offset: 0, Auto_percent: 30, Max_percent: 30},
+			{Segment_slug: "EXP_B", Layer_offset: 30, Auto_percent: 20, Max_percent: 20},
+		},
+	}
+	percent := 40.0
+	err := NewUserService(mockRepo).UpdateSegment(ctx, "EXP_A", model.SegmentPatchDTO{Auto_percent: &percent})
+	if !errors.Is(err, apperror.ErrLayerOverflow) {
+		t.Fatalf("Expected ErrLayerOverflow, got: %v", err)
+	}
+}
+
+func TestUserService_CreateSegment_LayerRuleWithoutPercent(t *testing.T) {
+	mockRepo := &MockSegmentRepo{
+		layerSegments: []model.LayerSegmentDTO{
+			{Segment_slug: "EXP_A", Layer_offset: 0, Auto_percent: 50, Max_percent: 50},
+		},
+	}
+	rule := &model.TargetingRuleDTO{All: []model.TargetingConditionDTO{{Attribute: "country", Op: "eq", Value: "RU"}}}
+	// Без auto_percent сегмент с правилом покрыл бы весь слой, включая бакеты EXP_A
+	err := NewUserService(mockRepo).CreateSegment(ctx, model.SegmentDTO{Slug: "EXP_RULE", Layer: "CHECKOUT", Targeting_rule: rule}, model.AuditDTO{})
+	if !errors.Is(err, apperror.ErrLayerRule) {
+		t.Fatalf("Expected ErrLayerRule, got: %v", err)
+	}
+	percent := 50.0
+	err = NewUserService(mockRepo).CreateSegment(ctx, model.SegmentDTO{Slug: "EXP_RULE", Layer: "CHECKOUT", Targeting_rule: rule, Auto_percent: &percent}, model.AuditDTO{})
+	if err != nil {
+		t.Fatalf("Expected rule segment with auto_percent to be accepted, got: %v", err)
+	}
+}
+
+func TestUserService_SetRolloutSteps_LayerFractionalPercent(t *testing.T) {
+	mockRepo := &MockSegmentRepo{
+		segmentLayer: "CHECKOUT",
+		layerSegments: []model.LayerSegmentDTO{
+			{Segment_slug: "EXP_A", Layer_offset: 0, Auto_percent: 10, Max_percent: 10},
+		},
+	}
+	// Сегмент слоя занимает целые бакеты слоя, дробный шаг раскатки для него недопустим
+	err := NewUserService(mockRepo).SetRolloutSteps(ctx, "EXP_A", []model.RolloutStepDTO{{Percent: 12.5, Starts_at: timeFuture}})
+	if !errors.Is(err, apperror.ErrLayerPercent) {
+		t.Fatalf("Expected ErrLayerPercent, got: %v", err)
+	}
+}
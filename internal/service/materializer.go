@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"progression1/internal/model"
+	"progression1/internal/repository"
+	"slices"
+	"time"
+)
+
+// Materializer в фоне поддерживает материализованные назначения (source = 'auto') сегментов,
+// созданных с materialize: true. Выборка сегмента зависит от соли, действующего процента,
+// смещения в слое, правила таргетинга и переопределений; при смене любого из них (изменение
+// auto_percent, шаг или пауза раскатки, перемешивание, include/exclude) начинается новый проход, который
+// постранично добавляет недостающие строки и удаляет лишние. Окно активности в выборку не
+// входит: вне окна сегмент не выдаётся ни по ручным, ни по автоматическим строкам.
+type Materializer struct {
+	segRepo   repository.SegmentRepo
+	interval  time.Duration
+	batchSize int
+}
+
+func NewMaterializer(segRepo repository.SegmentRepo, interval time.Duration, batchSize int) *Materializer {
+	return &Materializer{segRepo: segRepo, interval: interval, batchSize: batchSize}
+}
+
+// Run продвигает материализацию каждые interval и возвращается после отмены ctx
+func (m *Materializer) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	slog.Default().Info("materializer started", "interval", m.interval, "batchSize", m.batchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Default().Info("materializer stopped")
+			return
+		case <-ticker.C:
+			completed, err := m.Materialize(ctx)
+			if err != nil && ctx.Err() == nil {
+				slog.Default().Error("materialization failed", "error", err)
+				continue
+			}
+			if completed > 0 {
+				slog.Default().Info("segments materialized", "segments", completed)
+			}
+		}
+	}
+}
+
+// Materialize доводит до конца проходы всех материализуемых сегментов и возвращает
+// число завершённых проходов. Прерванный проход продолжается со своего курсора
+func (m *Materializer) Materialize(ctx context.Context) (int, error) {
+	materializations, err := m.segRepo.GetMaterializations(ctx)
+	if err != nil || len(materializations) == 0 {
+		return 0, err
+	}
+	segments, err := m.segRepo.GetSegmentsConfig(ctx)
+	if err != nil {
+		return 0, err
+	}
+	bySlug := make(map[string]model.SegmentUserDataDTO, len(segments))
+	for _, segment := range segments {
+		bySlug[segment.Slug] = segment
+	}
+	now := time.Now()
+	completed := 0
+	for _, materialization := range materializations {
+		segment, ok := bySlug[materialization.Slug]
+		if !ok {
+			continue
+		}
+		segment.AutoPercent = effectivePercent(segment.AutoPercent, segment.RolloutSteps, segment.RolloutPausedAt, now)
+		overrides, err := m.segRepo.GetSegmentOverrides(ctx, segment.Slug)
+		if err != nil {
+			return completed, err
+		}
+		key, err := materializationKey(segment, overrides)
+		if err != nil {
+			return completed, err
+		}
+		if key != materialization.Key {
+			started, err := m.segRepo.StartMaterialization(ctx, segment.Slug, materialization.Key, key)
+			if err != nil {
+				return completed, err
+			}
+			if !started {
+				continue
+			}
+			var cursor int64
+			materialization.Cursor = &cursor
+		}
+		if materialization.Cursor == nil {
+			continue
+		}
+		done, err := m.materializeSegment(ctx, segment, overrides, key, *materialization.Cursor)
+		if err != nil {
+			return completed, err
+		}
+		if done {
+			completed++
+		}
+	}
+	return completed, nil
+}
+
+// materializeSegment проходит реестр пользователей после afterID страницами по batchSize.
+// Членство вычисляется как в explain: exclude и include применяются до правила и бакета;
+// включённые пользователи, которых нет в реестре, добавляются в страницу, покрывающую их ID.
+// Возвращает false, если проход перехватила другая реплика или конфигурация сменилась
+func (m *Materializer) materializeSegment(ctx context.Context, segment model.SegmentUserDataDTO, overrides model.SegmentOverridesDTO, key string, afterID int64) (bool, error) {
+	excluded := make(map[int64]bool, len(overrides.Exclude))
+	for _, userID := range overrides.Exclude {
+		excluded[userID] = true
+	}
+	included := make(map[int64]bool, len(overrides.Include))
+	for _, userID := range overrides.Include {
+		included[userID] = true
+	}
+	for ctx.Err() == nil {
+		users, err := m.segRepo.ListUsers(ctx, afterID, m.batchSize)
+		if err != nil {
+			return false, err
+		}
+		page := model.MaterializationPageDTO{
+			Slug:    segment.Slug,
+			Key:     key,
+			AfterID: afterID,
+			LastID:  afterID,
+			Done:    len(users) < m.batchSize,
+		}
+		for _, user := range users {
+			page.LastID = user.UserID
+			if excluded[user.UserID] || included[user.UserID] {
+				continue
+			}
+			attrs, err := normalizeAttributes(user.Attributes)
+			if err != nil {
+				return false, err
+			}
+			if ruleMatches(segment.TargetingRule, attrs) && autoBucketHit(user.UserID, segment) {
+				page.Members = append(page.Members, user.UserID)
+			}
+		}
+		for _, userID := range overrides.Include {
+			if userID > page.AfterID && (page.Done || userID <= page.LastID) {
+				page.Members = append(page.Members, userID)
+			}
+		}
+		slices.Sort(page.Members)
+		applied, err := m.segRepo.ApplyMaterialization(ctx, page)
+		if err != nil || !applied {
+			return false, err
+		}
+		if page.Done {
+			return true, nil
+		}
+		afterID = page.LastID
+	}
+	return false, nil
+}
+
+// materializationKey описывает конфигурацию и переопределения, от которых зависит выборка сегмента
+func materializationKey(segment model.SegmentUserDataDTO, overrides model.SegmentOverridesDTO) (string, error) {
+	rule, err := json.Marshal(segment.TargetingRule)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode targeting rule: %w", err)
+	}
+	// Списки переопределений могут быть длинными, поэтому в ключ входит только их хеш
+	digest := sha256.New()
+	fmt.Fprint(digest, overrides.Include, overrides.Exclude)
+	return fmt.Sprintf("%s|%s|%d|%g|%s|%x", segment.Salt, segment.Layer, segment.LayerOffset, segment.AutoPercent, rule, digest.Sum(nil)), nil
+}
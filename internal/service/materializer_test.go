@@ -0,0 +1,126 @@
+package service
+
+import (
+	"progression1/internal/model"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMaterializer_Materialize(t *testing.T) {
+	users := []model.UserAttributesDTO{
+		{UserID: 1000, Attributes: map[string]any{"country": "RU"}}, // бакет 87
+		{UserID: 1001, Attributes: map[string]any{"country": "RU"}}, // бакет 58
+		{UserID: 1003, Attributes: map[string]any{"country": "RU"}}, // бакет 16
+		{UserID: 1004, Attributes: map[string]any{"country": "KZ"}}, // бакет 43
+	}
+	rule := &model.TargetingRuleDTO{All: []model.TargetingConditionDTO{{Attribute: "country", Op: "eq", Value: "RU"}}}
+	segment := model.SegmentUserDataDTO{Slug: "AUTO_HIT", AutoPercent: 50}
+	ruleSegment := model.SegmentUserDataDTO{Slug: "AUTO_HIT", AutoPercent: 50, TargetingRule: rule}
+	key, err := materializationKey(segment, model.SegmentOverridesDTO{})
+	if err != nil {
+		t.Fatalf("materializationKey() unexpected error = %v", err)
+	}
+	// 1003 попадает в бакет, но исключён; 1000 вне бакета и 2000 вне реестра включены явно
+	overrides := model.SegmentOverridesDTO{Include: []int64{1000, 2000}, Exclude: []int64{1003}}
+	var start int64
+	tests := []struct {
+		name            string
+		segment         model.SegmentUserDataDTO
+		overrides       model.SegmentOverridesDTO
+		materialization model.MaterializationDTO
+		wantMembers     []int64
+		wantPages       int
+		wantCompleted   int
+	}{
+		{
+			name:            "New segment is materialized page by page",
+			segment:         segment,
+			materialization: model.MaterializationDTO{Slug: "AUTO_HIT", Cursor: &start},
+			wantMembers:     []int64{1003, 1004},
+			wantPages:       3,
+			wantCompleted:   1,
+		},
+		{
+			name:            "Targeting rule narrows materialized users",
+			segment:         ruleSegment,
+			materialization: model.MaterializationDTO{Slug: "AUTO_HIT", Cursor: &start},
+			wantMembers:     []int64{1003},
+			wantPages:       3,
+			wantCompleted:   1,
+		},
+		{
+			name:            "Changed configuration restarts finished pass",
+			segment:         ruleSegment,
+			materialization: model.MaterializationDTO{Slug: "AUTO_HIT", Key: key},
+			wantMembers:     []int64{1003},
+			wantPages:       3,
+			wantCompleted:   1,
+		},
+		{
+			name:            "Overrides apply before targeting and bucket",
+			segment:         segment,
+			overrides:       overrides,
+			materialization: model.MaterializationDTO{Slug: "AUTO_HIT", Cursor: &start},
+			wantMembers:     []int64{1000, 1004, 2000},
+			wantPages:       3,
+			wantCompleted:   1,
+		},
+		{
+			name:            "Changed overrides restart finished pass",
+			segment:         segment,
+			overrides:       overrides,
+			materialization: model.MaterializationDTO{Slug: "AUTO_HIT", Key: key},
+			wantMembers:     []int64{1000, 1004, 2000},
+			wantPages:       3,
+			wantCompleted:   1,
+		},
+		{
+			name:            "Finished pass with the same configuration is skipped",
+			segment:         segment,
+			materialization: model.MaterializationDTO{Slug: "AUTO_HIT", Key: key},
+			wantPages:       0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockSegmentRepo{
+				users:            users,
+				segmentsConfig:   []model.SegmentUserDataDTO{tt.segment},
+				overrides:        tt.overrides,
+				materializations: []model.MaterializationDTO{tt.materialization},
+			}
+			completed, err := NewMaterializer(mockRepo, time.Minute, 2).Materialize(ctx)
+			if err != nil {
+				t.Fatalf("Materialize() unexpected error = %v", err)
+			}
+			if completed != tt.wantCompleted || len(mockRepo.materializationPages) != tt.wantPages {
+				t.Fatalf("Materialize() = %d in %d pages, want %d in %d pages", completed, len(mockRepo.materializationPages), tt.wantCompleted, tt.wantPages)
+			}
+			var members []int64
+			afterID := int64(0)
+			for i, page := range mockRepo.materializationPages {
+				if page.AfterID != afterID || page.Done != (i == len(mockRepo.materializationPages)-1) {
+					t.Errorf("page %d = %+v, want after %d", i, page, afterID)
+				}
+				afterID = page.LastID
+				members = append(members, page.Members...)
+			}
+			if !reflect.DeepEqual(members, tt.wantMembers) {
+				t.Errorf("Materialize() members = %v, want %v", members, tt.wantMembers)
+			}
+		})
+	}
+}
+
+func TestUserService_CreateSegment_Materialize(t *testing.T) {
+	percent := 50.0
+	mockRepo := &MockSegmentRepo{}
+	// Создание только ставит сегмент в очередь фоновой материализации
+	if err := NewUserService(mockRepo).CreateSegment(ctx, model.SegmentDTO{Slug: "AUTO_HIT", Auto_percent: &percent, Materialize: true}, model.AuditDTO{}); err != nil {
+		t.Fatalf("CreateSegment() unexpected error = %v", err)
+	}
+	if !mockRepo.created.Materialize {
+		t.Errorf("CreateSegment() segment = %+v, want materialize", mockRepo.created)
+	}
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"context"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+)
+
+// membersMaxLimit — наибольший размер страницы выгрузки участников сегмента
+const membersMaxLimit = 100000
+
+// StreamSegmentMembers передаёт в fn ручных участников сегмента по возрастанию user_id после
+// afterUserID. limit 0 выгружает всех оставшихся; следующая страница запрашивается с
+// afterUserID, равным последнему полученному user_id.
+func (s *UserService) StreamSegmentMembers(ctx context.Context, slug string, afterUserID int64, limit int, fn func(model.SegmentMemberDTO) error) error {
+	if err := slugValidate(slug); err != nil {
+		return err
+	}
+	if limit < 0 || limit > membersMaxLimit {
+		return apperror.ErrMembersLimit
+	}
+	if afterUserID < 0 {
+		return apperror.ErrUserIDInvalid
+	}
+	exists, err := s.segRepo.SegmentExists(ctx, slug)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return apperror.ErrSegmentNotFound
+	}
+	return s.segRepo.StreamSegmentMembers(ctx, slug, afterUserID, limit, fn)
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"errors"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"testing"
+)
+
+func TestUserService_StreamSegmentMembers(t *testing.T) {
+	mockRepo := &MockSegmentRepo{
+		segmentExists: true,
+		members: []model.SegmentMemberDTO{
+			{User_id: 1000, Assigned_at: &timePast},
+			{User_id: 1001, Assigned_at: &timePast, Expires_at: &timeFuture},
+			{User_id: 1002, Assigned_at: &timeNow},
+		},
+	}
+	userService := NewUserService(mockRepo)
+	var got []int64
+	err := userService.StreamSegmentMembers(ctx, "AVITO_VOICE", 1000, 2, func(member model.SegmentMemberDTO) error {
+		got = append(got, member.User_id)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamSegmentMembers() unexpected error = %v", err)
+	}
+	if len(got) != 2 || got[0] != 1001 || got[1] != 1002 {
+		t.Errorf("StreamSegmentMembers() got users %v, want [1001 1002]", got)
+	}
+}
+
+func TestUserService_StreamSegmentMembers_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		repo    *MockSegmentRepo
+		slug    string
+		after   int64
+		limit   int
+		wantErr error
+	}{
+		{name: "Segment not found", repo: &MockSegmentRepo{}, slug: "AVITO_VOICE", wantErr: apperror.ErrSegmentNotFound},
+		{name: "Invalid slug", repo: &MockSegmentRepo{segmentExists: true}, slug: "avito voice", wantErr: apperror.ErrSlugRegex},
+		{name: "Limit too large", repo: &MockSegmentRepo{segmentExists: true}, slug: "AVITO_VOICE", limit: membersMaxLimit + 1, wantErr: apperror.ErrMembersLimit},
+		{name: "Negative after", repo: &MockSegmentRepo{segmentExists: true}, slug: "AVITO_VOICE", after: -1, wantErr: apperror.ErrUserIDInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewUserService(tt.repo).StreamSegmentMembers(ctx, tt.slug, tt.after, tt.limit, func(model.SegmentMemberDTO) error { return nil })
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("StreamSegmentMembers() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"progression1/internal/model"
+	"time"
+)
+
+// CheckUserSegment проверяет членство пользователя в одном сегменте. Читается только этот
+// сегмент; атрибуты из реестра подгружаются, лишь если у сегмента есть правило таргетинга.
+func (s *UserService) CheckUserSegment(ctx context.Context, userID int64, slug string, attrs map[string]any) (model.UserSegmentMembershipDTO, error) {
+	if err := userValidate(userID, slug); err != nil {
+		return model.UserSegmentMembershipDTO{}, err
+	}
+	if err := slugValidate(slug); err != nil {
+		return model.UserSegmentMembershipDTO{}, err
+	}
+	segment, err := s.segRepo.GetUserSegmentData(ctx, userID, slug)
+	if err != nil {
+		return model.UserSegmentMembershipDTO{}, err
+	}
+	var normalized map[string]string
+	if segment.TargetingRule != nil {
+		if normalized, err = s.userAttributes(ctx, userID, attrs); err != nil {
+			return model.UserSegmentMembershipDTO{}, err
+		}
+	}
+	decision := explainSegment(userID, segment, normalized, time.Now())
+	membership := model.UserSegmentMembershipDTO{User_id: userID, Segment_slug: slug, Member: decision.Member}
+	switch {
+	case !decision.Member:
+	case decision.Reason == model.ReasonManual:
+		membership.Source, membership.Expires_at = model.SourceManual, segment.ExpiresAt
+	case decision.Reason == model.ReasonOverrideInclude:
+		membership.Source = model.SourceManual
+	case segment.TargetingRule != nil:
+		membership.Source = model.SourceRule
+	default:
+		membership.Source = model.SourceAuto
+	}
+	return membership, nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"errors"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"testing"
+)
+
+func TestUserService_CheckUserSegment(t *testing.T) {
+	rule := &model.TargetingRuleDTO{All: []model.TargetingConditionDTO{{Attribute: "country", Op: "eq", Value: "RU"}}}
+	tests := []struct {
+		name        string
+		segment     model.SegmentUserDataDTO
+		attrs       map[string]any
+		wantMember  bool
+		wantSource  string
+		wantExpires bool
+	}{
+		{name: "Manual assignment", segment: model.SegmentUserDataDTO{Slug: "AVITO_VOICE", IsManuallyAssigned: true, ExpiresAt: &timeFuture}, wantMember: true, wantSource: model.SourceManual, wantExpires: true},
+		{name: "Expired manual assignment", segment: model.SegmentUserDataDTO{Slug: "AVITO_VOICE", IsManuallyAssigned: true, ExpiresAt: &timePast}},
+		{name: "Override include", segment: model.SegmentUserDataDTO{Slug: "AVITO_VOICE", Override: model.OverrideInclude}, wantMember: true, wantSource: model.SourceManual},
+		{name: "Auto percent", segment: model.SegmentUserDataDTO{Slug: "AVITO_VOICE", AutoPercent: 100}, wantMember: true, wantSource: model.SourceAuto},
+		{name: "Targeting rule", segment: model.SegmentUserDataDTO{Slug: "AVITO_VOICE", AutoPercent: 100, TargetingRule: rule}, attrs: map[string]any{"country": "RU"}, wantMember: true, wantSource: model.SourceRule},
+		{name: "Targeting rule not matched", segment: model.SegmentUserDataDTO{Slug: "AVITO_VOICE", AutoPercent: 100, TargetingRule: rule}, attrs: map[string]any{"country": "KZ"}},
+		{name: "Not a member", segment: model.SegmentUserDataDTO{Slug: "AVITO_VOICE"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userService := NewUserService(&MockSegmentRepo{segmentData: tt.segment})
+			membership, err := userService.CheckUserSegment(ctx, 1000, "AVITO_VOICE", tt.attrs)
+			if err != nil {
+				t.Fatalf("CheckUserSegment() unexpected error = %v", err)
+			}
+			if membership.Member != tt.wantMember || membership.Source != tt.wantSource || (membership.Expires_at != nil) != tt.wantExpires {
+				t.Errorf("CheckUserSegment() = %+v, want member %v, source %q, expires %v", membership, tt.wantMember, tt.wantSource, tt.wantExpires)
+			}
+		})
+	}
+}
+
+func TestUserService_CheckUserSegment_Errors(t *testing.T) {
+	userService := NewUserService(&MockSegmentRepo{segmentData: model.SegmentUserDataDTO{Slug: "AVITO_VOICE"}})
+	if _, err := userService.CheckUserSegment(ctx, 0, "AVITO_VOICE", nil); !errors.Is(err, apperror.ErrUserIDInvalid) {
+		t.Errorf("CheckUserSegment() error = %v, want %v", err, apperror.ErrUserIDInvalid)
+	}
+	if _, err := userService.CheckUserSegment(ctx, 1000, "AVITO_CHAT", nil); !errors.Is(err, apperror.ErrSegmentNotFound) {
+		t.Errorf("CheckUserSegment() error = %v, want %v", err, apperror.ErrSegmentNotFound)
+	}
+	if _, err := userService.CheckUserSegment(ctx, 1000, "avito voice", nil); !errors.Is(err, apperror.ErrSlugRegex) {
+		t.Errorf("CheckUserSegment() error = %v, want %v", err, apperror.ErrSlugRegex)
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+)
+
+func overridesValidate(patch model.SegmentOverridesPatchDTO) error {
+	if len(patch.Include)+len(patch.Exclude)+len(patch.Remove) > 1000 {
+		return apperror.ErrTooManyOverrides
+	}
+	if len(patch.Include)+len(patch.Exclude)+len(patch.Remove) == 0 {
+		return apperror.ErrEmptyPatch
+	}
+	seen := make(map[int64]struct{})
+	for _, list := range [][]int64{patch.Include, patch.Exclude, patch.Remove} {
+		for _, userID := range list {
+			if userID <= 0 {
+				return apperror.ErrUserIDInvalid
+			}
+			if _, ok := seen[userID]; ok {
+				return fmt.Errorf("%w: %d", apperror.ErrOverrideConflict, userID)
+			}
+			seen[userID] = struct{}{}
+		}
+	}
+	return nil
+}
+
+func (s *UserService) GetSegmentOverrides(ctx context.Context, slug string) (model.SegmentOverridesDTO, error) {
+	if err := slugValidate(slug); err != nil {
+		return model.SegmentOverridesDTO{}, err
+	}
+	exists, err := s.segRepo.SegmentExists(ctx, slug)
+	if err != nil {
+		return model.SegmentOverridesDTO{}, err
+	}
+	if !exists {
+		return model.SegmentOverridesDTO{}, apperror.ErrSegmentNotFound
+	}
+	return s.segRepo.GetSegmentOverrides(ctx, slug)
+}
+
+// UpdateSegmentOverrides меняет списки явно включённых и исключённых пользователей сегмента
+func (s *UserService) UpdateSegmentOverrides(ctx context.Context, slug string, patch model.SegmentOverridesPatchDTO, audit model.AuditDTO) error {
+	if err := slugValidate(slug); err != nil {
+		return err
+	}
+	if err := auditValidate(audit); err != nil {
+		return err
+	}
+	if err := overridesValidate(patch); err != nil {
+		return err
+	}
+	exists, err := s.segRepo.SegmentExists(ctx, slug)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return apperror.ErrSegmentNotFound
+	}
+	// Включение выдаёт сегмент в обход бакета, поэтому проверяется так же, как ручное добавление
+	if len(patch.Include) > 0 {
+		layer, err := s.segRepo.GetSegmentLayer(ctx, slug)
+		if err != nil {
+			return err
+		}
+		if layer != "" {
+			for _, userID := range patch.Include {
+				if err := s.checkLayerConflicts(ctx, userID, []string{slug}, nil); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return s.segRepo.UpdateSegmentOverrides(ctx, slug, patch, audit)
+}
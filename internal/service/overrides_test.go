@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"testing"
+)
+
+func TestUserService_GetUserSegments_Overrides(t *testing.T) {
+	mockRepo := &MockSegmentRepo{
+		getAllSegmentsData: func(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error) {
+			return []model.SegmentUserDataDTO{
+				// Bucket(1000, "AUTO_MISS") = 30: без переопределения не попадает в 10%
+				{Slug: "AUTO_MISS", AutoPercent: 10, Override: model.OverrideInclude},
+				// При 100% без переопределения попадает любой пользователь
+				{Slug: "AUTO_ALL", AutoPercent: 100, Override: model.OverrideExclude},
+				{Slug: "MANUAL_PERMANENT", IsManuallyAssigned: true, Override: model.OverrideExclude},
+				{Slug: "RULE_ALL", AutoPercent: 100, TargetingRule: testRule, Override: model.OverrideInclude},
+			}, nil
+		},
+	}
+	segments, err := NewUserService(mockRepo).GetUserSegments(ctx, 1000, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(segments) != 2 || segments[0].Slug != "AUTO_MISS" || segments[1].Slug != "RULE_ALL" {
+		t.Errorf("Expected AUTO_MISS and RULE_ALL, got: %v", segments)
+	}
+}
+
+func TestOverridesValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   model.SegmentOverridesPatchDTO
+		wantErr error
+	}{
+		{name: "Valid", input: model.SegmentOverridesPatchDTO{Include: []int64{1, 2}, Exclude: []int64{3}, Remove: []int64{4}}, wantErr: nil},
+		{name: "ErrorEmpty", input: model.SegmentOverridesPatchDTO{}, wantErr: apperror.ErrEmptyPatch},
+		{name: "ErrorConflict", input: model.SegmentOverridesPatchDTO{Include: []int64{1}, Exclude: []int64{1}}, wantErr: apperror.ErrOverrideConflict},
+		{name: "ErrorUserID", input: model.SegmentOverridesPatchDTO{Remove: []int64{0}}, wantErr: apperror.ErrUserIDInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := overridesValidate(tt.input); !errors.Is(err, tt.wantErr) {
+				t.Errorf("overridesValidate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUserService_UpdateSegmentOverrides_LayerConflict(t *testing.T) {
+	mockRepo := &MockSegmentRepo{
+		segmentExists: true,
+		segmentLayer:  "CHECKOUT",
+		getAllSegmentsData: func(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error) {
+			return []model.SegmentUserDataDTO{
+				{Slug: "EXP_A", IsManuallyAssigned: userID == 1000, Layer: "CHECKOUT"},
+				{Slug: "EXP_B", Layer: "CHECKOUT", LayerOffset: 50},
+			}, nil
+		},
+	}
+	userService := NewUserService(mockRepo)
+	// Пользователь 1000 уже в EXP_A, включение в EXP_B дало бы два сегмента одного слоя
+	err := userService.UpdateSegmentOverrides(ctx, "EXP_B", model.SegmentOverridesPatchDTO{Include: []int64{1001, 1000}}, model.AuditDTO{})
+	if !errors.Is(err, apperror.ErrLayerConflict) {
+		t.Fatalf("Expected ErrLayerConflict, got: %v", err)
+	}
+	if err := userService.UpdateSegmentOverrides(ctx, "EXP_B", model.SegmentOverridesPatchDTO{Include: []int64{1001}, Exclude: []int64{1000}}, model.AuditDTO{}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"progression1/internal/repository"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// reportNameRegex — имя файла отчёта; случайный суффикс не даёт подобрать ссылку
+var reportNameRegex = regexp.MustCompile(`^history_\d{4}_\d{2}_[0-9a-f]{16}\.csv$`)
+
+// ReportService формирует CSV-отчёты по истории сегментов в локальном каталоге
+// и удаляет отчёты старше retention
+type ReportService struct {
+	segRepo   repository.SegmentRepo
+	dir       string
+	retention time.Duration
+}
+
+func NewReportService(segRepo repository.SegmentRepo, dir string, retention time.Duration) *ReportService {
+	return &ReportService{segRepo: segRepo, dir: dir, retention: retention}
+}
+
+// Retention возвращает срок хранения отчётов
+func (s *ReportService) Retention() time.Duration {
+	return s.retention
+}
+
+// CreateHistoryReport пишет историю за месяц в CSV (user_id;segment;operation;created_at;actor;reason)
+// и возвращает имя файла отчёта
+func (s *ReportService) CreateHistoryReport(ctx context.Context, year, month int) (string, error) {
+	from, to, err := MonthRange(year, month)
+	if err != nil {
+		return "", err
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate report name: %w", err)
+	}
+	name := fmt.Sprintf("history_%04d_%02d_%s.csv", year, month, hex.EncodeToString(suffix))
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create reports dir: %w", err)
+	}
+	// Пишем во временный файл и переименовываем, чтобы по ссылке не отдать недописанный отчёт
+	tmp, err := os.CreateTemp(s.dir, name+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create report: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	writer := csv.NewWriter(tmp)
+	writer.Comma = ';'
+	if err := writer.Write([]string{"user_id", "segment", "operation", "created_at", "actor", "reason"}); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write report: %w", err)
+	}
+	// История за месяц может быть большой — пишем записи по мере чтения
+	err = s.segRepo.StreamHistory(ctx, model.HistoryFilterDTO{From: &from, To: &to}, func(entry model.HistoryTableDTO) error {
+		record := []string{strconv.Itoa(entry.User_ID), entry.Segment_slug, entry.Operation, entry.Created_at.UTC().Format(time.RFC3339), entry.Actor, entry.Reason}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		tmp.Close()
+		return "", err
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write report: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write report: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		return "", fmt.Errorf("failed to save report: %w", err)
+	}
+	return name, nil
+}
+
+// ReportPath возвращает путь к файлу отчёта; отчёты с истёкшим сроком хранения не отдаются
+func (s *ReportService) ReportPath(name string) (string, error) {
+	if !reportNameRegex.MatchString(name) {
+		return "", apperror.ErrReportNotFound
+	}
+	path := filepath.Join(s.dir, name)
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > s.retention {
+		return "", apperror.ErrReportNotFound
+	}
+	return path, nil
+}
+
+// Cleanup удаляет отчёты, созданные раньше now - retention, и возвращает их число
+func (s *ReportService) Cleanup(now time.Time) (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read reports dir: %w", err)
+	}
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !reportNameRegex.MatchString(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) <= s.retention {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("failed to remove report: %w", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// RunCleanup удаляет устаревшие отчёты каждые interval и возвращается после отмены ctx
+func (s *ReportService) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			removed, err := s.Cleanup(now)
+			if err != nil {
+				slog.Default().Error("reports cleanup failed", "error", err)
+				continue
+			}
+			if removed > 0 {
+				slog.Default().Info("expired reports removed", "removed", removed)
+			}
+		}
+	}
+}
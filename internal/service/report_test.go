@@ -0,0 +1,86 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"testing"
+	"time"
+)
+
+func TestReportService_CreateHistoryReport(t *testing.T) {
+	createdAt := time.Date(2023, 8, 15, 10, 30, 0, 0, time.UTC)
+	mockRepo := &MockSegmentRepo{
+		history: []model.HistoryTableDTO{
+			{ID: 1, User_ID: 1000, Segment_slug: "AVITO_VOICE_MESSAGES", Operation: "ADDED", Created_at: createdAt},
+			{ID: 2, User_ID: 1000, Segment_slug: "AVITO_VOICE_MESSAGES", Operation: "REMOVED", Created_at: createdAt.Add(time.Hour), Actor: "support-tool", Reason: "ticket 42"},
+		},
+	}
+	reports := NewReportService(mockRepo, t.TempDir(), time.Hour)
+	name, err := reports.CreateHistoryReport(ctx, 2023, 8)
+	if err != nil {
+		t.Fatalf("CreateHistoryReport() unexpected error = %v", err)
+	}
+	path, err := reports.ReportPath(name)
+	if err != nil {
+		t.Fatalf("ReportPath(%q) unexpected error = %v", name, err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read report: %v", err)
+	}
+	want := "user_id;segment;operation;created_at;actor;reason\n" +
+		"1000;AVITO_VOICE_MESSAGES;ADDED;2023-08-15T10:30:00Z;;\n" +
+		"1000;AVITO_VOICE_MESSAGES;REMOVED;2023-08-15T11:30:00Z;support-tool;ticket 42\n"
+	if string(content) != want {
+		t.Errorf("report content = %q, want %q", content, want)
+	}
+}
+
+func TestReportService_CreateHistoryReport_InvalidPeriod(t *testing.T) {
+	reports := NewReportService(&MockSegmentRepo{}, t.TempDir(), time.Hour)
+	if _, err := reports.CreateHistoryReport(ctx, 2023, 13); !errors.Is(err, apperror.ErrReportPeriod) {
+		t.Errorf("CreateHistoryReport() error = %v, want %v", err, apperror.ErrReportPeriod)
+	}
+}
+
+func TestReportService_ReportPath_InvalidName(t *testing.T) {
+	reports := NewReportService(&MockSegmentRepo{}, t.TempDir(), time.Hour)
+	for _, name := range []string{"", "../etc/passwd", "history_2023_08_0123456789abcdef.csv"} {
+		if _, err := reports.ReportPath(name); !errors.Is(err, apperror.ErrReportNotFound) {
+			t.Errorf("ReportPath(%q) error = %v, want %v", name, err, apperror.ErrReportNotFound)
+		}
+	}
+}
+
+func TestReportService_Cleanup(t *testing.T) {
+	dir := t.TempDir()
+	reports := NewReportService(&MockSegmentRepo{}, dir, time.Hour)
+	oldName, err := reports.CreateHistoryReport(ctx, 2023, 7)
+	if err != nil {
+		t.Fatalf("CreateHistoryReport() unexpected error = %v", err)
+	}
+	freshName, err := reports.CreateHistoryReport(ctx, 2023, 8)
+	if err != nil {
+		t.Fatalf("CreateHistoryReport() unexpected error = %v", err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, oldName), old, old); err != nil {
+		t.Fatalf("failed to age report: %v", err)
+	}
+	if _, err := reports.ReportPath(oldName); !errors.Is(err, apperror.ErrReportNotFound) {
+		t.Errorf("ReportPath() for expired report error = %v, want %v", err, apperror.ErrReportNotFound)
+	}
+	removed, err := reports.Cleanup(time.Now())
+	if err != nil {
+		t.Fatalf("Cleanup() unexpected error = %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Cleanup() removed = %d, want 1", removed)
+	}
+	if _, err := reports.ReportPath(freshName); err != nil {
+		t.Errorf("ReportPath() for fresh report unexpected error = %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"time"
+)
+
+// ReshuffleSegment меняет соль сегмента, чтобы набрать по тому же auto_percent новую
+// случайную выборку. Соль генерируется и сохраняется в транзакции репозитория под блокировкой
+// сегмента, а реестр пользователей сравнивается со старой и новой солью страницами по
+// knownUsersBatchSize. Изменения членства известных пользователей пишутся в историю;
+// ручные назначения и явные переопределения сохраняются.
+func (s *UserService) ReshuffleSegment(ctx context.Context, slug string, audit model.AuditDTO) (model.ReshuffleResultDTO, error) {
+	if err := slugValidate(slug); err != nil {
+		return model.ReshuffleResultDTO{}, err
+	}
+	if err := auditValidate(audit); err != nil {
+		return model.ReshuffleResultDTO{}, err
+	}
+	segment, err := s.segRepo.GetSegmentData(ctx, slug)
+	if err != nil {
+		return model.ReshuffleResultDTO{}, err
+	}
+	if segment.Layer != "" {
+		return model.ReshuffleResultDTO{}, apperror.ErrLayerReshuffle
+	}
+	now := time.Now()
+	return s.segRepo.ReshuffleSegment(ctx, slug, audit, knownUsersBatchSize,
+		func(segment model.SegmentUserDataDTO, salt string, users []model.UserAttributesDTO) ([]int64, []int64, error) {
+			return reshuffleChanges(segment, salt, users, now)
+		})
+}
+
+// reshuffleChanges сравнивает попадание страницы пользователей в сегмент со старой и новой солью
+func reshuffleChanges(segment model.SegmentUserDataDTO, salt string, users []model.UserAttributesDTO, now time.Time) (added, removed []int64, err error) {
+	// Вне окна активности сегмент не выдаётся никому, членство не меняется
+	if !segmentActive(segment.ActiveFrom, segment.ActiveUntil, now) {
+		return nil, nil, nil
+	}
+	segment.AutoPercent = effectivePercent(segment.AutoPercent, segment.RolloutSteps, segment.RolloutPausedAt, now)
+	reshuffled := segment
+	reshuffled.Salt = salt
+	for _, user := range users {
+		attrs, err := normalizeAttributes(user.Attributes)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ruleMatches(segment.TargetingRule, attrs) {
+			continue
+		}
+		before, after := autoBucketHit(user.UserID, segment), autoBucketHit(user.UserID, reshuffled)
+		switch {
+		case after && !before:
+			added = append(added, user.UserID)
+		case before && !after:
+			removed = append(removed, user.UserID)
+		}
+	}
+	return added, removed, nil
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"errors"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"testing"
+)
+
+func TestSegmentBucketKey(t *testing.T) {
+	// Без соли бакет совпадает с историческим расчётом по "userID:slug"
+	for userID := int64(1); userID <= 100; userID++ {
+		unsalted := model.SegmentUserDataDTO{Slug: "AVITO_VOICE", AutoPercent: 30}
+		want := calculateDeterministicBucket(userID, "AVITO_VOICE") < basisPoints(30)
+		if got := autoBucketHit(userID, unsalted); got != want {
+			t.Fatalf("autoBucketHit(%d) without salt = %v, want %v", userID, got, want)
+		}
+	}
+	if segmentBucketKey("AVITO_VOICE", "") == segmentBucketKey("AVITO_VOICE", "a1b2") {
+		t.Error("Expected salt to change the bucket key")
+	}
+}
+
+func TestUserService_ReshuffleSegment(t *testing.T) {
+	var users []model.UserAttributesDTO
+	for userID := int64(1); userID <= 200; userID++ {
+		users = append(users, model.UserAttributesDTO{UserID: userID})
+	}
+	segment := model.SegmentUserDataDTO{Slug: "AVITO_VOICE", AutoPercent: 50}
+
+	added, removed, err := reshuffleChanges(segment, "a1b2", users, timeNow)
+	if err != nil {
+		t.Fatalf("reshuffleChanges() unexpected error = %v", err)
+	}
+	if len(added) == 0 || len(removed) == 0 {
+		t.Fatalf("Expected reshuffle to move users in both directions, got added=%d removed=%d", len(added), len(removed))
+	}
+	salted := segment
+	salted.Salt = "a1b2"
+	for _, userID := range added {
+		if autoBucketHit(userID, segment) || !autoBucketHit(userID, salted) {
+			t.Errorf("User %d reported as added but membership did not change that way", userID)
+		}
+	}
+	for _, userID := range removed {
+		if !autoBucketHit(userID, segment) || autoBucketHit(userID, salted) {
+			t.Errorf("User %d reported as removed but membership did not change that way", userID)
+		}
+	}
+
+	ended := segment
+	ended.ActiveUntil = &timePast
+	added, removed, err = reshuffleChanges(ended, "a1b2", users, timeNow)
+	if err != nil || len(added) != 0 || len(removed) != 0 {
+		t.Errorf("Expected no changes for inactive segment, got added=%v removed=%v err=%v", added, removed, err)
+	}
+}
+
+func TestUserService_ReshuffleSegment_Batches(t *testing.T) {
+	var users []model.UserAttributesDTO
+	for userID := int64(1); userID <= 2*knownUsersBatchSize+1; userID++ {
+		users = append(users, model.UserAttributesDTO{UserID: userID})
+	}
+	segment := model.SegmentUserDataDTO{Slug: "AVITO_VOICE", AutoPercent: 50}
+	mockRepo := &MockSegmentRepo{users: users, segmentData: segment}
+	result, err := NewUserService(mockRepo).ReshuffleSegment(ctx, "AVITO_VOICE", model.AuditDTO{})
+	if err != nil {
+		t.Fatalf("ReshuffleSegment() unexpected error = %v", err)
+	}
+	// Реестр передаётся в репозиторий страницами, а не целиком
+	if mockRepo.reshufflePages != 3 {
+		t.Errorf("ReshuffleSegment() compared %d pages, want 3", mockRepo.reshufflePages)
+	}
+	added, removed, _ := reshuffleChanges(segment, mockRepo.reshuffledSalt, users, timeNow)
+	if result.Added != len(added) || result.Removed != len(removed) {
+		t.Errorf("ReshuffleSegment() = %+v, want added=%d removed=%d", result, len(added), len(removed))
+	}
+}
+
+func TestUserService_ReshuffleSegment_Layer(t *testing.T) {
+	mockRepo := &MockSegmentRepo{segmentData: model.SegmentUserDataDTO{Slug: "CHECKOUT_A", AutoPercent: 20, Layer: "CHECKOUT"}}
+	_, err := NewUserService(mockRepo).ReshuffleSegment(ctx, "CHECKOUT_A", model.AuditDTO{})
+	if !errors.Is(err, apperror.ErrLayerReshuffle) {
+		t.Errorf("Expected ErrLayerReshuffle, got: %v", err)
+	}
+	if mockRepo.reshuffledSalt != "" {
+		t.Error("Expected salt of a layered segment to stay unchanged")
+	}
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"sort"
+	"time"
+)
+
+// effectivePercent вычисляет auto_percent, действующий в момент at.
+// Действует последний наступивший шаг плана; если план на паузе, время
+// замораживается на моменте паузы. Без наступивших шагов действует auto_percent сегмента.
+func effectivePercent(autoPercent float64, steps []model.RolloutStepDTO, pausedAt *time.Time, at time.Time) float64 {
+	if pausedAt != nil && pausedAt.Before(at) {
+		at = *pausedAt
+	}
+	percent := autoPercent
+	var latest time.Time
+	for _, step := range steps {
+		if step.Starts_at.After(at) {
+			continue
+		}
+		if latest.IsZero() || step.Starts_at.After(latest) {
+			latest = step.Starts_at
+			percent = step.Percent
+		}
+	}
+	return percent
+}
+
+func rolloutStepsValidate(steps []model.RolloutStepDTO) error {
+	if len(steps) > 50 {
+		return apperror.ErrTooManyRolloutSteps
+	}
+	seen := make(map[int64]struct{}, len(steps))
+	for _, step := range steps {
+		if step.Starts_at.IsZero() {
+			return apperror.ErrRolloutStepTime
+		}
+		percent := step.Percent
+		if err := percentValidate(&percent); err != nil {
+			return err
+		}
+		key := step.Starts_at.UnixNano()
+		if _, ok := seen[key]; ok {
+			return fmt.Errorf("%w: %s", apperror.ErrRolloutStepsDuplicate, step.Starts_at.Format(time.RFC3339))
+		}
+		seen[key] = struct{}{}
+	}
+	return nil
+}
+
+func (s *UserService) GetRolloutPlan(ctx context.Context, slug string) (model.RolloutPlanDTO, error) {
+	if err := slugValidate(slug); err != nil {
+		return model.RolloutPlanDTO{}, err
+	}
+	plan, err := s.segRepo.GetRolloutPlan(ctx, slug)
+	if err != nil {
+		return plan, err
+	}
+	autoPercent := 0.0
+	if plan.Auto_percent != nil {
+		autoPercent = *plan.Auto_percent
+	}
+	plan.Effective_percent = effectivePercent(autoPercent, plan.Steps, plan.Paused_at, time.Now())
+	return plan, nil
+}
+
+// SetRolloutSteps заменяет план раскатки; пустой список шагов удаляет план
+func (s *UserService) SetRolloutSteps(ctx context.Context, slug string, steps []model.RolloutStepDTO) error {
+	if err := slugValidate(slug); err != nil {
+		return err
+	}
+	if err := rolloutStepsValidate(steps); err != nil {
+		return err
+	}
+	maxPercent := 0.0
+	for _, step := range steps {
+		maxPercent = max(maxPercent, step.Percent)
+	}
+	if err := s.ensureLayerCapacity(ctx, slug, maxPercent); err != nil {
+		return err
+	}
+	sorted := make([]model.RolloutStepDTO, len(steps))
+	copy(sorted, steps)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Starts_at.Before(sorted[j].Starts_at) })
+	return s.segRepo.SetRolloutSteps(ctx, slug, sorted)
+}
+
+func (s *UserService) SetRolloutPaused(ctx context.Context, slug string, paused bool) error {
+	if err := slugValidate(slug); err != nil {
+		return err
+	}
+	return s.segRepo.SetRolloutPaused(ctx, slug, paused)
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"testing"
+	"time"
+)
+
+func TestEffectivePercent(t *testing.T) {
+	monday := time.Date(2025, 10, 6, 0, 0, 0, 0, time.UTC)
+	wednesday := monday.AddDate(0, 0, 2)
+	nextWeek := monday.AddDate(0, 0, 7)
+	steps := []model.RolloutStepDTO{
+		{Percent: 1, Starts_at: monday},
+		{Percent: 10, Starts_at: wednesday},
+		{Percent: 50, Starts_at: nextWeek},
+	}
+	tests := []struct {
+		name     string
+		at       time.Time
+		pausedAt *time.Time
+		want     float64
+	}{
+		{name: "BeforePlan", at: monday.Add(-time.Hour), want: 5},
+		{name: "FirstStep", at: monday.Add(time.Hour), want: 1},
+		{name: "SecondStep", at: wednesday, want: 10},
+		{name: "LastStep", at: nextWeek.AddDate(0, 1, 0), want: 50},
+		{name: "PausedBeforeLastStep", at: nextWeek.AddDate(0, 1, 0), pausedAt: &wednesday, want: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := effectivePercent(5, steps, tt.pausedAt, tt.at)
+			if got != tt.want {
+				t.Errorf("effectivePercent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserService_GetUserSegments_RolloutPlan(t *testing.T) {
+	// Bucket: calculateDeterministicBucket(1000, "AUTO_MISS") = 30 — попадает только при проценте > 17
+	mockRepo := &MockSegmentRepo{
+		getAllSegmentsData: func(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error) {
+			return []model.SegmentUserDataDTO{{
+				Slug:         "AUTO_MISS",
+				AutoPercent:  10,
+				RolloutSteps: []model.RolloutStepDTO{{Percent: 50, Starts_at: timePast}},
+			}}, nil
+		},
+	}
+	segments, err := NewUserService(mockRepo).GetUserSegments(ctx, 1000, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(segments) != 1 || segments[0].AutoPercent != 50 {
+		t.Errorf("Expected AUTO_MISS with effective percent 50, got: %v", segments)
+	}
+}
+
+func TestRolloutStepsValidate(t *testing.T) {
+	t.Run("Error_Duplicate", func(t *testing.T) {
+		err := rolloutStepsValidate([]model.RolloutStepDTO{
+			{Percent: 1, Starts_at: timeFuture},
+			{Percent: 10, Starts_at: timeFuture},
+		})
+		if !errors.Is(err, apperror.ErrRolloutStepsDuplicate) {
+			t.Fatalf("Expected ErrRolloutStepsDuplicate, got: %v", err)
+		}
+	})
+	t.Run("Success_FractionalPercent", func(t *testing.T) {
+		if err := rolloutStepsValidate([]model.RolloutStepDTO{{Percent: 0.25, Starts_at: timeFuture}}); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+	})
+	t.Run("Error_Precision", func(t *testing.T) {
+		err := rolloutStepsValidate([]model.RolloutStepDTO{{Percent: 0.125, Starts_at: timeFuture}})
+		if !errors.Is(err, apperror.ErrPercentPrecision) {
+			t.Fatalf("Expected ErrPercentPrecision, got: %v", err)
+		}
+	})
+	t.Run("Error_NoTime", func(t *testing.T) {
+		err := rolloutStepsValidate([]model.RolloutStepDTO{{Percent: 1}})
+		if !errors.Is(err, apperror.ErrRolloutStepTime) {
+			t.Fatalf("Expected ErrRolloutStepTime, got: %v", err)
+		}
+	})
+}
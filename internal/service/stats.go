@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"math"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"time"
+)
+
+// statsMaxDays — наибольшая глубина дневной статистики сегмента
+const statsMaxDays = 365
+
+// GetSegmentStats возвращает численность сегмента: действующие ручные назначения, оценку
+// попадающих по auto_percent и динамику за последние days дней (UTC, включая текущий).
+func (s *UserService) GetSegmentStats(ctx context.Context, slug string, days int) (model.SegmentStatsDTO, error) {
+	if err := slugValidate(slug); err != nil {
+		return model.SegmentStatsDTO{}, err
+	}
+	if days < 1 || days > statsMaxDays {
+		return model.SegmentStatsDTO{}, apperror.ErrStatsDays
+	}
+	segment, err := s.segRepo.GetSegmentData(ctx, slug)
+	if err != nil {
+		return model.SegmentStatsDTO{}, err
+	}
+	now := time.Now().UTC()
+	to := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+	stats, err := s.segRepo.GetSegmentStats(ctx, slug, to.AddDate(0, 0, -days), to)
+	if err != nil {
+		return model.SegmentStatsDTO{}, err
+	}
+	stats.User_base, stats.User_base_source = s.UserBase, model.UserBaseConfig
+	if s.UserBase <= 0 {
+		if stats.User_base, err = s.segRepo.CountUsers(ctx); err != nil {
+			return model.SegmentStatsDTO{}, err
+		}
+		stats.User_base_source = model.UserBaseRegistry
+	}
+	if segmentActive(segment.ActiveFrom, segment.ActiveUntil, now) {
+		stats.Auto_percent = effectivePercent(segment.AutoPercent, segment.RolloutSteps, segment.RolloutPausedAt, now)
+	}
+	stats.Estimated_auto_members = int64(math.Round(float64(stats.User_base) * stats.Auto_percent / 100))
+	stats.Has_targeting_rule = segment.TargetingRule != nil
+	return stats, nil
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"errors"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"testing"
+	"time"
+)
+
+func TestUserService_GetSegmentStats(t *testing.T) {
+	users := make([]model.UserAttributesDTO, 400)
+	for i := range users {
+		users[i].UserID = int64(i + 1)
+	}
+	tests := []struct {
+		name          string
+		userBase      int64
+		segment       model.SegmentUserDataDTO
+		wantBase      int64
+		wantSource    string
+		wantEstimated int64
+	}{
+		{name: "Registry user base", segment: model.SegmentUserDataDTO{Slug: "AVITO_VOICE", AutoPercent: 25}, wantBase: 400, wantSource: model.UserBaseRegistry, wantEstimated: 100},
+		{name: "Configured user base", userBase: 1000000, segment: model.SegmentUserDataDTO{Slug: "AVITO_VOICE", AutoPercent: 0.05}, wantBase: 1000000, wantSource: model.UserBaseConfig, wantEstimated: 500},
+		{name: "Rollout step overrides percent", userBase: 1000, segment: model.SegmentUserDataDTO{Slug: "AVITO_VOICE", AutoPercent: 50, RolloutSteps: []model.RolloutStepDTO{{Percent: 10, Starts_at: timePast}}}, wantBase: 1000, wantSource: model.UserBaseConfig, wantEstimated: 100},
+		{name: "Inactive segment", userBase: 1000, segment: model.SegmentUserDataDTO{Slug: "AVITO_VOICE", AutoPercent: 50, ActiveFrom: &timeFuture}, wantBase: 1000, wantSource: model.UserBaseConfig, wantEstimated: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockSegmentRepo{users: users, segmentData: tt.segment, stats: model.SegmentStatsDTO{Manual_members: 7, Members_with_ttl: 2}}
+			userService := NewUserService(mockRepo)
+			userService.UserBase = tt.userBase
+			stats, err := userService.GetSegmentStats(ctx, "AVITO_VOICE", 7)
+			if err != nil {
+				t.Fatalf("GetSegmentStats() unexpected error = %v", err)
+			}
+			if stats.User_base != tt.wantBase || stats.User_base_source != tt.wantSource || stats.Estimated_auto_members != tt.wantEstimated {
+				t.Errorf("GetSegmentStats() base = %d (%s), estimated = %d, want %d (%s), %d",
+					stats.User_base, stats.User_base_source, stats.Estimated_auto_members, tt.wantBase, tt.wantSource, tt.wantEstimated)
+			}
+			if stats.Manual_members != 7 || stats.Members_with_ttl != 2 {
+				t.Errorf("GetSegmentStats() manual = %d, with ttl = %d, want 7 and 2", stats.Manual_members, stats.Members_with_ttl)
+			}
+		})
+	}
+}
+
+func TestUserService_GetSegmentStats_Period(t *testing.T) {
+	mockRepo := &MockSegmentRepo{segmentData: model.SegmentUserDataDTO{Slug: "AVITO_VOICE"}}
+	if _, err := NewUserService(mockRepo).GetSegmentStats(ctx, "AVITO_VOICE", 7); err != nil {
+		t.Fatalf("GetSegmentStats() unexpected error = %v", err)
+	}
+	// Период — 7 полных суток UTC, последние из которых — текущие
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	if !mockRepo.statsTo.Equal(today.Add(24*time.Hour)) || mockRepo.statsTo.Sub(mockRepo.statsFrom) != 7*24*time.Hour {
+		t.Errorf("GetSegmentStats() period = [%v, %v), want 7 days ending tomorrow", mockRepo.statsFrom, mockRepo.statsTo)
+	}
+	for _, days := range []int{0, statsMaxDays + 1} {
+		if _, err := NewUserService(mockRepo).GetSegmentStats(ctx, "AVITO_VOICE", days); !errors.Is(err, apperror.ErrStatsDays) {
+			t.Errorf("GetSegmentStats(days = %d) error = %v, want %v", days, err, apperror.ErrStatsDays)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"fmt"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Операторы условий таргетинга
+const (
+	opEq    = "eq"
+	opNeq   = "neq"
+	opIn    = "in"
+	opNotIn = "not_in"
+	opGt    = "gt"
+	opGte   = "gte"
+	opLt    = "lt"
+	opLte   = "lte"
+)
+
+func ruleValidate(rule *model.TargetingRuleDTO) error {
+	if rule == nil {
+		return nil
+	}
+	if len(rule.All) > 20 {
+		return apperror.ErrRuleTooManyConditions
+	}
+	for _, condition := range rule.All {
+		if condition.Attribute == "" || len(condition.Attribute) > 50 {
+			return apperror.ErrRuleAttribute
+		}
+		switch condition.Op {
+		case opIn, opNotIn:
+			if len(condition.Values) == 0 {
+				return fmt.Errorf("%w: %s", apperror.ErrRuleValue, condition.Attribute)
+			}
+		case opEq, opNeq, opGt, opGte, opLt, opLte:
+			if condition.Value == "" {
+				return fmt.Errorf("%w: %s", apperror.ErrRuleValue, condition.Attribute)
+			}
+		default:
+			return fmt.Errorf("%w: %q", apperror.ErrRuleOperator, condition.Op)
+		}
+	}
+	return nil
+}
+
+// ruleMatches проверяет, что атрибуты пользователя удовлетворяют всем условиям правила.
+// Условие на отсутствующий атрибут не выполняется ни для одного оператора.
+func ruleMatches(rule *model.TargetingRuleDTO, attrs map[string]string) bool {
+	if rule == nil {
+		return true
+	}
+	for _, condition := range rule.All {
+		value, ok := attrs[condition.Attribute]
+		if !ok || !conditionMatches(condition, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func conditionMatches(condition model.TargetingConditionDTO, value string) bool {
+	switch condition.Op {
+	case opEq:
+		return value == condition.Value
+	case opNeq:
+		return value != condition.Value
+	case opIn:
+		return slices.Contains(condition.Values, value)
+	case opNotIn:
+		return !slices.Contains(condition.Values, value)
+	case opGt:
+		return compareAttribute(value, condition.Value) > 0
+	case opGte:
+		return compareAttribute(value, condition.Value) >= 0
+	case opLt:
+		return compareAttribute(value, condition.Value) < 0
+	case opLte:
+		return compareAttribute(value, condition.Value) <= 0
+	}
+	return false
+}
+
+// compareAttribute сравнивает значения как версии ("5.10" > "5.2"), если обе строки
+// состоят из чисел через точку, иначе — лексикографически
+func compareAttribute(a, b string) int {
+	aParts, aOk := parseVersion(a)
+	bParts, bOk := parseVersion(b)
+	if !aOk || !bOk {
+		return strings.Compare(a, b)
+	}
+	for i := 0; i < max(len(aParts), len(bParts)); i++ {
+		var x, y int64
+		if i < len(aParts) {
+			x = aParts[i]
+		}
+		if i < len(bParts) {
+			y = bParts[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func parseVersion(v string) ([]int64, bool) {
+	parts := strings.Split(v, ".")
+	numbers := make([]int64, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers, true
+}
+
+// normalizeAttributes приводит атрибуты из JSON к строкам для сравнения в правилах
+func normalizeAttributes(attrs map[string]any) (map[string]string, error) {
+	normalized := make(map[string]string, len(attrs))
+	for key, value := range attrs {
+		switch v := value.(type) {
+		case string:
+			normalized[key] = v
+		case float64:
+			normalized[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			normalized[key] = strconv.FormatBool(v)
+		default:
+			return nil, fmt.Errorf("%w: %s", apperror.ErrAttributeValue, key)
+		}
+	}
+	return normalized, nil
+}
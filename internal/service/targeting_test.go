@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"testing"
+)
+
+// country = RU AND platform IN (ios, android) AND app_version >= 5.2
+var testRule = &model.TargetingRuleDTO{All: []model.TargetingConditionDTO{
+	{Attribute: "country", Op: "eq", Value: "RU"},
+	{Attribute: "platform", Op: "in", Values: []string{"ios", "android"}},
+	{Attribute: "app_version", Op: "gte", Value: "5.2"},
+}}
+
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs map[string]string
+		want  bool
+	}{
+		{name: "Match", attrs: map[string]string{"country": "RU", "platform": "ios", "app_version": "5.2"}, want: true},
+		{name: "MatchNewerVersion", attrs: map[string]string{"country": "RU", "platform": "android", "app_version": "5.10.1"}, want: true},
+		{name: "OldVersion", attrs: map[string]string{"country": "RU", "platform": "ios", "app_version": "5.1.9"}, want: false},
+		{name: "WrongPlatform", attrs: map[string]string{"country": "RU", "platform": "web", "app_version": "6.0"}, want: false},
+		{name: "MissingAttribute", attrs: map[string]string{"country": "RU", "platform": "ios"}, want: false},
+		{name: "NoAttributes", attrs: nil, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ruleMatches(testRule, tt.attrs); got != tt.want {
+				t.Errorf("ruleMatches(%v) = %v, want %v", tt.attrs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   *model.TargetingRuleDTO
+		wantErr error
+	}{
+		{name: "Valid", input: testRule, wantErr: nil},
+		{name: "ValidNil", input: nil, wantErr: nil},
+		{name: "ErrorOperator", input: &model.TargetingRuleDTO{All: []model.TargetingConditionDTO{{Attribute: "country", Op: "like", Value: "R%"}}}, wantErr: apperror.ErrRuleOperator},
+		{name: "ErrorNoValues", input: &model.TargetingRuleDTO{All: []model.TargetingConditionDTO{{Attribute: "platform", Op: "in"}}}, wantErr: apperror.ErrRuleValue},
+		{name: "ErrorNoAttribute", input: &model.TargetingRuleDTO{All: []model.TargetingConditionDTO{{Op: "eq", Value: "RU"}}}, wantErr: apperror.ErrRuleAttribute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ruleValidate(tt.input); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ruleValidate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUserService_GetUserSegments_TargetingRule(t *testing.T) {
+	mockRepo := &MockSegmentRepo{
+		getAllSegmentsData: func(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error) {
+			return []model.SegmentUserDataDTO{
+				// Без auto_percent правило выдаёт сегмент всем подходящим
+				{Slug: "RULE_ALL", AutoPercent: 100, TargetingRule: testRule},
+				// Bucket(1000, "AUTO_MISS") = 30: подходит под правило, но не проходит сэмплирование 10%
+				{Slug: "AUTO_MISS", AutoPercent: 10, TargetingRule: testRule},
+				{Slug: "MANUAL_PERMANENT", IsManuallyAssigned: true, TargetingRule: testRule},
+			}, nil
+		},
+	}
+	userService := NewUserService(mockRepo)
+	t.Run("Match", func(t *testing.T) {
+		segments, err := userService.GetUserSegments(ctx, 1000, map[string]any{"country": "RU", "platform": "ios", "app_version": 5.3})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(segments) != 2 || segments[0].Slug != "RULE_ALL" || segments[1].Slug != "MANUAL_PERMANENT" {
+			t.Errorf("Expected RULE_ALL and MANUAL_PERMANENT, got: %v", segments)
+		}
+	})
+	t.Run("NoMatch", func(t *testing.T) {
+		segments, err := userService.GetUserSegments(ctx, 1000, map[string]any{"country": "KZ"})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(segments) != 1 || segments[0].Slug != "MANUAL_PERMANENT" {
+			t.Errorf("Expected only MANUAL_PERMANENT, got: %v", segments)
+		}
+	})
+	t.Run("Error_AttributeValue", func(t *testing.T) {
+		_, err := userService.GetUserSegments(ctx, 1000, map[string]any{"tags": []any{"a"}})
+		if !errors.Is(err, apperror.ErrAttributeValue) {
+			t.Fatalf("Expected ErrAttributeValue, got: %v", err)
+		}
+	})
+}
+
+func TestUserService_GetUserSegments_StoredAttributes(t *testing.T) {
+	mockRepo := &MockSegmentRepo{
+		getAllSegmentsData: func(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error) {
+			return []model.SegmentUserDataDTO{{Slug: "RULE_ALL", AutoPercent: 100, TargetingRule: testRule}}, nil
+		},
+		userAttributes: map[string]any{"country": "RU", "platform": "ios", "app_version": "5.0"},
+	}
+	userService := NewUserService(mockRepo)
+	t.Run("StoredOnly", func(t *testing.T) {
+		segments, err := userService.GetUserSegments(ctx, 1000, nil)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(segments) != 0 {
+			t.Errorf("Expected no segments for stored app_version 5.0, got: %v", segments)
+		}
+	})
+	t.Run("RequestOverridesStored", func(t *testing.T) {
+		segments, err := userService.GetUserSegments(ctx, 1000, map[string]any{"app_version": "5.2"})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(segments) != 1 || segments[0].Slug != "RULE_ALL" {
+			t.Errorf("Expected RULE_ALL, got: %v", segments)
+		}
+	})
+}
+
+func TestAttributesValidate(t *testing.T) {
+	if err := attributesValidate(map[string]any{"country": nil}, false); !errors.Is(err, apperror.ErrAttributeValue) {
+		t.Errorf("Expected ErrAttributeValue for null in full replace, got: %v", err)
+	}
+	if err := attributesValidate(map[string]any{"country": nil}, true); err != nil {
+		t.Errorf("Expected null to be allowed in merge, got: %v", err)
+	}
+	if err := attributesValidate(map[string]any{"": "RU"}, true); !errors.Is(err, apperror.ErrAttributeKey) {
+		t.Errorf("Expected ErrAttributeKey, got: %v", err)
+	}
+}
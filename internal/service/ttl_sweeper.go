@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"progression1/internal/repository"
+	"time"
+)
+
+// TTLSweeper периодически удаляет назначения с истёкшим TTL и пишет в историю
+// операцию EXPIRED. До очистки такие назначения отбрасываются при чтении.
+type TTLSweeper struct {
+	segRepo   repository.SegmentRepo
+	interval  time.Duration
+	batchSize int
+}
+
+func NewTTLSweeper(segRepo repository.SegmentRepo, interval time.Duration, batchSize int) *TTLSweeper {
+	return &TTLSweeper{segRepo: segRepo, interval: interval, batchSize: batchSize}
+}
+
+// Run запускает очистку каждые interval и возвращается после отмены ctx
+func (s *TTLSweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	slog.Default().Info("ttl sweeper started", "interval", s.interval, "batchSize", s.batchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Default().Info("ttl sweeper stopped")
+			return
+		case <-ticker.C:
+			expired, err := s.Sweep(ctx)
+			if err != nil && ctx.Err() == nil {
+				slog.Default().Error("ttl sweep failed", "error", err, "expired", expired)
+				continue
+			}
+			if expired > 0 {
+				slog.Default().Info("expired assignments removed", "expired", expired)
+			}
+		}
+	}
+}
+
+// Sweep удаляет истёкшие назначения пачками по batchSize, пока не останется
+// неполная пачка, и возвращает общее число удалённых назначений
+func (s *TTLSweeper) Sweep(ctx context.Context) (int, error) {
+	total := 0
+	for ctx.Err() == nil {
+		deleted, err := s.segRepo.DeleteExpiredAssignments(ctx, s.batchSize)
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+		if deleted < s.batchSize {
+			break
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTTLSweeper_Sweep(t *testing.T) {
+	// 250 истёкших назначений удаляются пачками по 100: 100, 100, 50
+	remaining := 250
+	var limits []int
+	mockRepo := &MockSegmentRepo{
+		deleteExpiredAssignments: func(ctx context.Context, limit int) (int, error) {
+			limits = append(limits, limit)
+			deleted := min(limit, remaining)
+			remaining -= deleted
+			return deleted, nil
+		},
+	}
+	expired, err := NewTTLSweeper(mockRepo, time.Minute, 100).Sweep(ctx)
+	if err != nil {
+		t.Fatalf("Sweep() unexpected error = %v", err)
+	}
+	if expired != 250 || len(limits) != 3 {
+		t.Errorf("Sweep() = %d in %d batches, want 250 in 3 batches", expired, len(limits))
+	}
+}
+
+func TestTTLSweeper_SweepError(t *testing.T) {
+	dbErr := errors.New("db is down")
+	calls := 0
+	mockRepo := &MockSegmentRepo{
+		deleteExpiredAssignments: func(ctx context.Context, limit int) (int, error) {
+			calls++
+			if calls == 2 {
+				return 0, dbErr
+			}
+			return limit, nil
+		},
+	}
+	expired, err := NewTTLSweeper(mockRepo, time.Minute, 10).Sweep(ctx)
+	if !errors.Is(err, dbErr) || expired != 10 {
+		t.Errorf("Sweep() = %d, %v; want 10, %v", expired, err, dbErr)
+	}
+}
+
+func TestTTLSweeper_RunStopsOnCancel(t *testing.T) {
+	swept := make(chan struct{}, 1)
+	mockRepo := &MockSegmentRepo{
+		deleteExpiredAssignments: func(ctx context.Context, limit int) (int, error) {
+			select {
+			case swept <- struct{}{}:
+			default:
+			}
+			return 0, nil
+		},
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		NewTTLSweeper(mockRepo, time.Millisecond, 10).Run(runCtx)
+		close(done)
+	}()
+	select {
+	case <-swept:
+	case <-time.After(time.Second):
+		t.Fatal("Expected sweeper to run at least once")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected sweeper to stop after context cancel")
+	}
+}
@@ -1,195 +1,496 @@
-package service
-
-import (
-	"context"
-	"errors"
-	"fmt"
-	"hash/fnv"
-	"progression1/internal/apperror"
-	"progression1/internal/model"
-	"progression1/internal/repository"
-	"regexp"
-	"time"
-)
-
-type UserService struct {
-	segRepo repository.SegmentRepo // ← зависимость через интерфейс
-}
-
-func NewUserService(segRepo repository.SegmentRepo) *UserService {
-	return &UserService{segRepo: segRepo}
-}
-
-func percentValidate(auto_percent *int) error {
-	if auto_percent != nil {
-		if *auto_percent < 0 {
-			return apperror.ErrPercentAbove
-		} else if *auto_percent > 100 {
-			return apperror.ErrPercentLess
-		}
-	}
-	return nil
-}
-func (s *UserService) CreateSegment(ctx context.Context, slug string, auto_percent *int) error {
-	if err := slugValidate(slug); err != nil {
-		return err
-	}
-	if err := percentValidate(auto_percent); err != nil {
-		return err
-	}
-	if err := s.segRepo.CreateSegment(ctx, slug, auto_percent); err != nil {
-		return err
-	}
-	return nil
-}
-
-func (s *UserService) GetAllSegments(ctx context.Context) ([]string, error) {
-	segments, err := s.segRepo.GetAllSegments(ctx)
-	if err != nil {
-		return nil, err
-	}
-	return segments, nil
-}
-
-func (s *UserService) SegmentExists(ctx context.Context, slug string) (bool, error) {
-	exists, err := s.segRepo.SegmentExists(ctx, slug)
-	return exists, err
-}
-
-func (s *UserService) GetHTable(ctx context.Context) ([]model.HistoryTableDTO, error) {
-	historyTables, err := s.segRepo.GetHTable(ctx)
-	return historyTables, err
-}
-
-func (s *UserService) GetHForPeriod(ctx context.Context, year, month int) ([]model.HistoryTableDTO, error) {
-	if err := yearAmonthValidate(year, month); err != nil {
-		return nil, err
-	}
-	historyTables, err := s.segRepo.GetHForPeriod(ctx, year, month)
-	return historyTables, err
-}
-
-func (s *UserService) UpdateUserSegments(ctx context.Context, userID int64, addSlugs []string, removeSlugs []string, ttlHours *int) error {
-	if len(addSlugs) > 100 || len(removeSlugs) > 100 {
-		return apperror.ErrTooManySegments
-	}
-	var expiresAt *time.Time
-	if ttlHours != nil && *ttlHours > 0 && *ttlHours < 720 {
-		expirationTime := time.Now().Add(time.Duration(*ttlHours) * time.Hour)
-		expiresAt = &expirationTime
-	}
-	for _, slug := range addSlugs {
-		if err := slugValidate(slug); err != nil {
-			return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
-		}
-	}
-	for _, slug := range removeSlugs {
-		if err := slugValidate(slug); err != nil {
-			return fmt.Errorf("%w: %w", apperror.ErrCannotDeleteFT, err)
-		}
-	}
-	slugsToAdd := make(map[string]struct{}, len(addSlugs))
-	for _, slug := range addSlugs {
-		slugsToAdd[slug] = struct{}{}
-	}
-	for _, slug := range removeSlugs {
-		if _, ok := slugsToAdd[slug]; ok {
-			return fmt.Errorf("%w: %s", apperror.ErrSegmentConflict, slug)
-		}
-	}
-	if err := s.segRepo.UpdateUserSegments(ctx, userID, addSlugs, removeSlugs, expiresAt); err != nil {
-		return err
-	}
-	return nil
-}
-
-func (s *UserService) AddUserToSegment(ctx context.Context, userID int64, slug string) error {
-	if err := userValidate(userID, slug); err != nil {
-		return err
-	}
-	exists, err := s.segRepo.SegmentExists(ctx, slug)
-	if err != nil {
-		return err
-	}
-	if !exists {
-		return apperror.ErrSlugNotFound
-	}
-	if err := s.segRepo.AddUserToSegment(ctx, userID, slug); err != nil {
-		return err
-	}
-	return nil
-}
-
-func calculateDeterministicBucket(userID int64, slug string) int {
-	seed := fmt.Sprintf("%d:%s", userID, slug)
-	h := fnv.New32a()
-	h.Write([]byte(seed))
-	hashValue := h.Sum32()
-	return int(hashValue) % 100
-}
-
-func (s *UserService) GetUserSegments(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error) {
-	if userID <= 0 {
-		return nil, apperror.ErrUserIDInvalid
-	}
-	userSegments, err := s.segRepo.GetAllSegmentsData(ctx, userID)
-	if err != nil {
-		return nil, err
-	}
-	var activeDTOs []model.SegmentUserDataDTO
-	for _, userSegment := range userSegments {
-		isExpired := userSegment.ExpiresAt != nil && userSegment.ExpiresAt.Before(time.Now())
-		if userSegment.IsManuallyAssigned {
-			if !isExpired {
-				activeDTOs = append(activeDTOs, userSegment)
-			}
-			continue
-		}
-		if userSegment.AutoPercent > 0 {
-			bucket := calculateDeterministicBucket(userID, userSegment.Slug)
-			if bucket < userSegment.AutoPercent {
-				activeDTOs = append(activeDTOs, userSegment)
-			}
-		}
-	}
-	return activeDTOs, nil
-}
-
-func yearAmonthValidate(year, month int) error {
-	if month < 1 || month > 12 {
-		return errors.New("month must be between 1 and 12")
-	}
-	if year < 2000 {
-		return fmt.Errorf("year %d is earlier than the minimum allowed year (%d)", year, 2000)
-	}
-	targetDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
-	currentMonthStart := time.Now().Truncate(24*time.Hour).AddDate(0, 0, -(time.Now().Day() - 1))
-	if targetDate.After(currentMonthStart) {
-		return fmt.Errorf("report date (%04d-%02d) cannot be in the future or current incomplete month", year, month)
-	}
-	return nil
-}
-
-func userValidate(userID int64, slug string) error {
-	if userID <= 0 {
-		return apperror.ErrUserIDInvalid
-	}
-	if slug == "" {
-		return apperror.ErrEmptySlug
-	}
-	return nil
-}
-
-func slugValidate(slug string) error {
-	if slug == "" {
-		return apperror.ErrEmptySlug
-	}
-	if len(slug) < 3 || len(slug) > 50 {
-		return apperror.ErrSlugLength
-	}
-	var slugRegex = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
-	if !slugRegex.MatchString(slug) {
-		return apperror.ErrSlugRegex
-	}
-	return nil
-}
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"progression1/internal/repository"
+	"regexp"
+	"slices"
+	"time"
+)
+
+type UserService struct {
+	segRepo repository.SegmentRepo // ← зависимость через интерфейс
+	// UserBase — общее число пользователей для оценки численности по auto_percent;
+	// 0 — оценка по реестру users
+	UserBase int64
+}
+
+func NewUserService(segRepo repository.SegmentRepo) *UserService {
+	return &UserService{segRepo: segRepo}
+}
+
+func percentValidate(auto_percent *float64) error {
+	if auto_percent != nil {
+		if *auto_percent < 0 {
+			return apperror.ErrPercentLess
+		} else if *auto_percent > 100 {
+			return apperror.ErrPercentAbove
+		}
+		bp := *auto_percent * 100
+		if math.Abs(bp-math.Round(bp)) > 1e-6 {
+			return apperror.ErrPercentPrecision
+		}
+	}
+	return nil
+}
+
+// basisPoints переводит процент в базисные пункты (сотые доли процента)
+func basisPoints(percent float64) int {
+	return int(math.Round(percent * 100))
+}
+func metadataValidate(segment model.SegmentDTO) error {
+	if len(segment.Description) > 1000 {
+		return apperror.ErrDescriptionLength
+	}
+	if len(segment.Owner) > 100 {
+		return apperror.ErrOwnerLength
+	}
+	if len(segment.Tags) > 20 {
+		return apperror.ErrTooManyTags
+	}
+	for _, tag := range segment.Tags {
+		if tag == "" || len(tag) > 50 {
+			return fmt.Errorf("%w: %q", apperror.ErrTagInvalid, tag)
+		}
+	}
+	return nil
+}
+
+func (s *UserService) CreateSegment(ctx context.Context, segment model.SegmentDTO, audit model.AuditDTO) error {
+	if err := slugValidate(segment.Slug); err != nil {
+		return err
+	}
+	if err := auditValidate(audit); err != nil {
+		return err
+	}
+	if err := percentValidate(segment.Auto_percent); err != nil {
+		return err
+	}
+	if err := metadataValidate(segment); err != nil {
+		return err
+	}
+	if err := windowValidate(segment.Active_from, segment.Active_until); err != nil {
+		return err
+	}
+	if err := ruleValidate(segment.Targeting_rule); err != nil {
+		return err
+	}
+	if segment.Layer != "" {
+		if err := s.layerValidate(ctx, segment); err != nil {
+			return err
+		}
+	}
+	if err := s.segRepo.CreateSegment(ctx, segment, audit); err != nil {
+		return err
+	}
+	return nil
+}
+
+func windowValidate(activeFrom, activeUntil *time.Time) error {
+	if activeFrom != nil && activeUntil != nil && !activeFrom.Before(*activeUntil) {
+		return apperror.ErrActiveWindow
+	}
+	return nil
+}
+
+// segmentActive сообщает, попадает ли момент at в окно активности сегмента
+func segmentActive(activeFrom, activeUntil *time.Time, at time.Time) bool {
+	return segmentState(activeFrom, activeUntil, at) == model.SegmentStateActive
+}
+
+func segmentState(activeFrom, activeUntil *time.Time, at time.Time) string {
+	if activeFrom != nil && at.Before(*activeFrom) {
+		return model.SegmentStateScheduled
+	}
+	if activeUntil != nil && !at.Before(*activeUntil) {
+		return model.SegmentStateEnded
+	}
+	return model.SegmentStateActive
+}
+
+// UpdateSegment меняет auto_percent, окно активности и правило таргетинга существующего сегмента.
+// Бакет пользователя не зависит от процента, поэтому при увеличении процента
+// все, кто уже попадал в сегмент, в нём остаются (монотонная раскатка).
+func (s *UserService) UpdateSegment(ctx context.Context, slug string, patch model.SegmentPatchDTO) error {
+	if err := slugValidate(slug); err != nil {
+		return err
+	}
+	if patch.Auto_percent == nil && patch.Active_from == nil && patch.Active_until == nil && patch.Targeting_rule == nil &&
+		!patch.Clear_active_from && !patch.Clear_active_until {
+		return apperror.ErrEmptyPatch
+	}
+	if (patch.Clear_active_from && patch.Active_from != nil) || (patch.Clear_active_until && patch.Active_until != nil) {
+		return apperror.ErrActiveClear
+	}
+	if err := ruleValidate(patch.Targeting_rule); err != nil {
+		return err
+	}
+	if err := percentValidate(patch.Auto_percent); err != nil {
+		return err
+	}
+	if err := windowValidate(patch.Active_from, patch.Active_until); err != nil {
+		return err
+	}
+	if patch.Auto_percent != nil {
+		if err := s.ensureLayerCapacity(ctx, slug, *patch.Auto_percent); err != nil {
+			return err
+		}
+	}
+	return s.segRepo.UpdateSegment(ctx, slug, patch)
+}
+
+func (s *UserService) GetSegmentConfigHistory(ctx context.Context, slug string) ([]model.SegmentConfigHistoryDTO, error) {
+	if err := slugValidate(slug); err != nil {
+		return nil, err
+	}
+	return s.segRepo.GetSegmentConfigHistory(ctx, slug)
+}
+
+func (s *UserService) DeleteSegment(ctx context.Context, slug string, audit model.AuditDTO) error {
+	if err := slugValidate(slug); err != nil {
+		return err
+	}
+	if err := auditValidate(audit); err != nil {
+		return err
+	}
+	return s.segRepo.DeleteSegment(ctx, slug, audit)
+}
+
+func (s *UserService) GetAllSegments(ctx context.Context, filter model.SegmentFilterDTO) ([]model.SegmentInfoDTO, error) {
+	segments, err := s.segRepo.GetAllSegments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range segments {
+		segments[i].State = segmentState(segments[i].Active_from, segments[i].Active_until, now)
+	}
+	return segments, nil
+}
+
+func (s *UserService) SegmentExists(ctx context.Context, slug string) (bool, error) {
+	exists, err := s.segRepo.SegmentExists(ctx, slug)
+	return exists, err
+}
+
+// UpdateUserSegments добавляет и удаляет сегменты пользователя и меняет срок действия
+// существующих назначений. Некорректный TTL отклоняется ошибкой, а не игнорируется.
+func (s *UserService) UpdateUserSegments(ctx context.Context, userID int64, update model.SegmentUpdateUserDTO, audit model.AuditDTO) error {
+	if len(update.AddSlugs)+len(update.Add) > 100 || len(update.RemoveSlugs) > 100 || len(update.Update) > 100 {
+		return apperror.ErrTooManySegments
+	}
+	if err := auditValidate(audit); err != nil {
+		return err
+	}
+	now := time.Now()
+	add := make([]model.SegmentAssignmentDTO, 0, len(update.AddSlugs)+len(update.Add))
+	for _, slug := range update.AddSlugs {
+		add = append(add, model.SegmentAssignmentDTO{Slug: slug, TTL_hours: update.TTLHours})
+	}
+	add = append(add, update.Add...)
+	addSlugs := make([]string, 0, len(add))
+	slugsToAdd := make(map[string]struct{}, len(add))
+	for i := range add {
+		if err := slugValidate(add[i].Slug); err != nil {
+			return fmt.Errorf("%w: %w", apperror.ErrCannotInsertT, err)
+		}
+		if err := resolveExpiry(&add[i], now); err != nil {
+			return fmt.Errorf("%w: %s", err, add[i].Slug)
+		}
+		addSlugs = append(addSlugs, add[i].Slug)
+		slugsToAdd[add[i].Slug] = struct{}{}
+	}
+	for _, slug := range update.RemoveSlugs {
+		if err := slugValidate(slug); err != nil {
+			return fmt.Errorf("%w: %w", apperror.ErrCannotDeleteFT, err)
+		}
+		if _, ok := slugsToAdd[slug]; ok {
+			return fmt.Errorf("%w: %s", apperror.ErrSegmentConflict, slug)
+		}
+	}
+	extend := make([]model.SegmentAssignmentDTO, len(update.Update))
+	copy(extend, update.Update)
+	for i := range extend {
+		if err := slugValidate(extend[i].Slug); err != nil {
+			return err
+		}
+		if extend[i].TTL_hours == nil && extend[i].Expires_at == nil && !extend[i].Clear_ttl {
+			return fmt.Errorf("%w: %s", apperror.ErrTTLUpdateEmpty, extend[i].Slug)
+		}
+		if err := resolveExpiry(&extend[i], now); err != nil {
+			return fmt.Errorf("%w: %s", err, extend[i].Slug)
+		}
+		if _, ok := slugsToAdd[extend[i].Slug]; ok || slices.Contains(update.RemoveSlugs, extend[i].Slug) {
+			return fmt.Errorf("%w: %s", apperror.ErrSegmentDuplicate, extend[i].Slug)
+		}
+	}
+	if err := s.checkLayerConflicts(ctx, userID, addSlugs, update.RemoveSlugs); err != nil {
+		return err
+	}
+	if err := s.segRepo.UpdateUserSegments(ctx, userID, add, update.RemoveSlugs, extend, audit); err != nil {
+		return err
+	}
+	return nil
+}
+
+// resolveExpiry проверяет срок действия назначения и переводит ttl_hours в абсолютный
+// expires_at; без срока или с clear_ttl назначение бессрочное
+func resolveExpiry(assignment *model.SegmentAssignmentDTO, now time.Time) error {
+	set := 0
+	for _, ok := range []bool{assignment.TTL_hours != nil, assignment.Expires_at != nil, assignment.Clear_ttl} {
+		if ok {
+			set++
+		}
+	}
+	if set > 1 {
+		return apperror.ErrTTLConflict
+	}
+	switch {
+	case assignment.TTL_hours != nil:
+		if *assignment.TTL_hours < 1 || *assignment.TTL_hours > 719 {
+			return apperror.ErrTTLRange
+		}
+		expiresAt := now.Add(time.Duration(*assignment.TTL_hours) * time.Hour)
+		assignment.Expires_at = &expiresAt
+		assignment.TTL_hours = nil
+	case assignment.Expires_at != nil:
+		if !assignment.Expires_at.After(now) {
+			return apperror.ErrExpiresAtPast
+		}
+	}
+	return nil
+}
+
+func (s *UserService) AddUserToSegment(ctx context.Context, userID int64, slug string, audit model.AuditDTO) error {
+	if err := userValidate(userID, slug); err != nil {
+		return err
+	}
+	if err := auditValidate(audit); err != nil {
+		return err
+	}
+	exists, err := s.segRepo.SegmentExists(ctx, slug)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return apperror.ErrSlugNotFound
+	}
+	if err := s.checkLayerConflicts(ctx, userID, []string{slug}, nil); err != nil {
+		return err
+	}
+	if err := s.segRepo.AddUserToSegment(ctx, userID, slug, audit); err != nil {
+		return err
+	}
+	return nil
+}
+
+// calculateDeterministicBucket возвращает бакет пользователя в базисных пунктах (0..9999).
+// Старшие разряды — прежний процентный бакет hash % 100, младшие уточняют его
+// следующими разрядами хеша, поэтому при целом проценте выборка не меняется.
+func calculateDeterministicBucket(userID int64, slug string) int {
+	seed := fmt.Sprintf("%d:%s", userID, slug)
+	h := fnv.New32a()
+	h.Write([]byte(seed))
+	hashValue := h.Sum32()
+	return int(hashValue%100)*100 + int(hashValue/100%100)
+}
+
+// GetUserSegments возвращает активные сегменты пользователя. attrs — атрибуты
+// контекста пользователя для сегментов с правилами таргетинга (может быть nil),
+// они объединяются с атрибутами, сохранёнными в реестре пользователей.
+func (s *UserService) GetUserSegments(ctx context.Context, userID int64, attrs map[string]any) ([]model.SegmentUserDataDTO, error) {
+	if userID <= 0 {
+		return nil, apperror.ErrUserIDInvalid
+	}
+	normalized, err := s.userAttributes(ctx, userID, attrs)
+	if err != nil {
+		return nil, err
+	}
+	userSegments, err := s.segRepo.GetAllSegmentsData(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return evaluateSegments(userID, userSegments, normalized, time.Now()), nil
+}
+
+// ExplainUserSegments возвращает решение по каждому сегменту с причиной:
+// ручное назначение, истёкший TTL, бакет против auto_percent, несовпадение правила и т.д.
+func (s *UserService) ExplainUserSegments(ctx context.Context, userID int64, attrs map[string]any) ([]model.SegmentDecisionDTO, error) {
+	if userID <= 0 {
+		return nil, apperror.ErrUserIDInvalid
+	}
+	normalized, err := s.userAttributes(ctx, userID, attrs)
+	if err != nil {
+		return nil, err
+	}
+	userSegments, err := s.segRepo.GetAllSegmentsData(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	decisions := make([]model.SegmentDecisionDTO, 0, len(userSegments))
+	for _, userSegment := range userSegments {
+		decisions = append(decisions, explainSegment(userID, userSegment, normalized, now))
+	}
+	return decisions, nil
+}
+
+// evaluateSegments отбирает сегменты, в которых пользователь состоит в момент now
+func evaluateSegments(userID int64, userSegments []model.SegmentUserDataDTO, attrs map[string]string, now time.Time) []model.SegmentUserDataDTO {
+	var activeDTOs []model.SegmentUserDataDTO
+	for _, userSegment := range userSegments {
+		decision := explainSegment(userID, userSegment, attrs, now)
+		if !decision.Member {
+			continue
+		}
+		userSegment.AutoPercent = decision.Auto_percent
+		activeDTOs = append(activeDTOs, userSegment)
+	}
+	return activeDTOs
+}
+
+// explainSegment решает, состоит ли пользователь в сегменте в момент now, и объясняет почему
+func explainSegment(userID int64, userSegment model.SegmentUserDataDTO, attrs map[string]string, now time.Time) model.SegmentDecisionDTO {
+	decision := model.SegmentDecisionDTO{
+		Segment_slug: userSegment.Slug,
+		Expires_at:   userSegment.ExpiresAt,
+		Layer:        userSegment.Layer,
+	}
+	// Вне окна активности сегмент не выдаётся ни вручную, ни по auto_percent
+	if !segmentActive(userSegment.ActiveFrom, userSegment.ActiveUntil, now) {
+		decision.Reason = model.ReasonInactive
+		return decision
+	}
+	decision.Auto_percent = effectivePercent(userSegment.AutoPercent, userSegment.RolloutSteps, userSegment.RolloutPausedAt, now)
+	// Явные переопределения применяются до ручных назначений и проверки бакета
+	switch userSegment.Override {
+	case model.OverrideExclude:
+		decision.Reason = model.ReasonOverrideExclude
+		return decision
+	case model.OverrideInclude:
+		decision.Member, decision.Reason = true, model.ReasonOverrideInclude
+		return decision
+	}
+	if userSegment.IsManuallyAssigned {
+		if userSegment.ExpiresAt != nil && userSegment.ExpiresAt.Before(now) {
+			decision.Reason = model.ReasonExpired
+		} else {
+			decision.Member, decision.Reason = true, model.ReasonManual
+		}
+		return decision
+	}
+	// Правило таргетинга сужает аудиторию, auto_percent сэмплирует подходящих
+	if !ruleMatches(userSegment.TargetingRule, attrs) {
+		decision.Reason = model.ReasonRuleNotMatched
+		return decision
+	}
+	userSegment.AutoPercent = decision.Auto_percent
+	bucket, from, to := bucketRange(userID, userSegment)
+	if from == to {
+		decision.Reason = model.ReasonNoAutoPercent
+		return decision
+	}
+	bucketPercent := float64(bucket) / 100
+	decision.Bucket = &bucketPercent
+	if userSegment.Layer != "" {
+		offset := userSegment.LayerOffset
+		decision.Layer_offset = &offset
+	}
+	if bucket >= from && bucket < to {
+		decision.Member, decision.Reason = true, model.ReasonBucketHit
+	} else {
+		decision.Reason = model.ReasonBucketMiss
+	}
+	return decision
+}
+
+// autoBucketHit проверяет попадание пользователя в сегмент по auto_percent.
+// Сегменты одного слоя делят общее пространство бакетов слоя и занимают в нём
+// непересекающиеся диапазоны, поэтому пользователь попадает максимум в один из них.
+func autoBucketHit(userID int64, userSegment model.SegmentUserDataDTO) bool {
+	bucket, from, to := bucketRange(userID, userSegment)
+	return bucket >= from && bucket < to
+}
+
+// bucketRange возвращает бакет пользователя и диапазон [from, to) бакетов сегмента
+// в базисных пунктах; пустой диапазон означает нулевой auto_percent
+func bucketRange(userID int64, userSegment model.SegmentUserDataDTO) (bucket, from, to int) {
+	width := max(basisPoints(userSegment.AutoPercent), 0)
+	if userSegment.Layer != "" {
+		offset := userSegment.LayerOffset * 100
+		return calculateDeterministicBucket(userID, "layer:"+userSegment.Layer), offset, offset + width
+	}
+	return calculateDeterministicBucket(userID, segmentBucketKey(userSegment.Slug, userSegment.Salt)), 0, width
+}
+
+// segmentBucketKey возвращает ключ бакетирования сегмента. Без соли ключом служит
+// slug, поэтому сегменты, не проходившие перемешивание, сохраняют прежнюю выборку.
+func segmentBucketKey(slug, salt string) string {
+	if salt == "" {
+		return slug
+	}
+	return slug + ":" + salt
+}
+
+func yearAmonthValidate(year, month int) error {
+	if month < 1 || month > 12 {
+		return errors.New("month must be between 1 and 12")
+	}
+	if year < 2000 {
+		return fmt.Errorf("year %d is earlier than the minimum allowed year (%d)", year, 2000)
+	}
+	targetDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	currentMonthStart := time.Now().Truncate(24*time.Hour).AddDate(0, 0, -(time.Now().Day() - 1))
+	if targetDate.After(currentMonthStart) {
+		return fmt.Errorf("report date (%04d-%02d) cannot be in the future or current incomplete month", year, month)
+	}
+	return nil
+}
+
+// auditValidate ограничивает длину actor и reason, сохраняемых в истории
+func auditValidate(audit model.AuditDTO) error {
+	if len(audit.Actor) > 100 {
+		return apperror.ErrActorLength
+	}
+	if len(audit.Reason) > 500 {
+		return apperror.ErrReasonLength
+	}
+	return nil
+}
+
+func userValidate(userID int64, slug string) error {
+	if userID <= 0 {
+		return apperror.ErrUserIDInvalid
+	}
+	if slug == "" {
+		return apperror.ErrEmptySlug
+	}
+	return nil
+}
+
+// reservedSlugs совпадают с литеральными путями /segments/history и /segments/import:
+// сегмент с таким slug нельзя было бы адресовать через /segments/{slug}
+var reservedSlugs = map[string]bool{"history": true, "import": true}
+
+func slugValidate(slug string) error {
+	if slug == "" {
+		return apperror.ErrEmptySlug
+	}
+	if len(slug) < 3 || len(slug) > 50 {
+		return apperror.ErrSlugLength
+	}
+	var slugRegex = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+	if !slugRegex.MatchString(slug) {
+		return apperror.ErrSlugRegex
+	}
+	if reservedSlugs[slug] {
+		return apperror.ErrSlugReserved
+	}
+	return nil
+}
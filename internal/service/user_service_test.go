@@ -1,230 +1,667 @@
-package service
-
-import (
-	"context"
-	"errors"
-	"progression1/internal/apperror"
-	"progression1/internal/model"
-	"reflect"
-	"sort"
-	"testing"
-	"time"
-)
-
-func (m *MockSegmentRepo) CreateSegment(ctx context.Context, slug string, auto_percent *int) error {
-	return nil
-}
-func (m *MockSegmentRepo) DeleteSegment(ctx context.Context, slug string) error { return nil }
-func (m *MockSegmentRepo) GetAllSegments(ctx context.Context) ([]string, error) { return nil, nil }
-func (m *MockSegmentRepo) GetHTable(ctx context.Context) ([]model.HistoryTableDTO, error) {
-	return nil, nil
-}
-func (m *MockSegmentRepo) GetHForPeriod(ctx context.Context, year, month int) ([]model.HistoryTableDTO, error) {
-	return nil, nil
-}
-func (m *MockSegmentRepo) SegmentExists(ctx context.Context, slug string) (bool, error) {
-	return false, nil
-}
-func (m *MockSegmentRepo) AddUserToSegment(ctx context.Context, userID int64, slug string) error {
-	return nil
-}
-func (m *MockSegmentRepo) GetAllSegmentsData(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error) {
-	return m.getAllSegmentsData(ctx, userID)
-}
-func (m *MockSegmentRepo) UpdateUserSegments(ctx context.Context, userID int64, addSlugs []string, removeSlugs []string, expiresAt *time.Time) error {
-	return m.updateUserSegments(ctx, userID, addSlugs, removeSlugs, expiresAt)
-}
-
-type MockSegmentRepo struct {
-	getAllSegmentsData func(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error)
-	updateUserSegments func(ctx context.Context, userID int64, addSlugs []string, removeSlugs []string, expiresAt *time.Time) error
-}
-
-var (
-	ctx        = context.Background()
-	timeNow    = time.Now()
-	timeFuture = timeNow.Add(time.Hour * 24)
-	timePast   = timeNow.Add(time.Hour * -24)
-)
-
-func TestSlugValidate(t *testing.T) {
-	tests := []struct {
-		name    string // Имя теста для t.Run
-		input   string // Входной slug
-		wantErr bool   // Ожидаем ли мы ошибку (true/false)
-	}{
-		// Успешные сценарии
-		{name: "ValidSimple", input: "AVITO_VOICE", wantErr: false},
-		{name: "ValidDigits", input: "test1000", wantErr: false},
-		{name: "ValidMinLength", input: "abc", wantErr: false},
-
-		// Ошибочные сценарии
-		{name: "ValidMaxLength", input: "a-very-long-slug-with-many-words-and-digits-123", wantErr: true},
-		{name: "ErrorEmpty", input: "", wantErr: true},
-		{name: "ErrorTooShort", input: "ab", wantErr: true},
-		{name: "ErrorSpecialChars", input: "bad-slug!", wantErr: true},
-		{name: "ErrorSpaces", input: "with spaces", wantErr: true},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			err := slugValidate(tt.input)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("slugValidate(%s) error = %v, wantErr %v", tt.input, err, tt.wantErr)
-			}
-		})
-	}
-}
-func TestPercentValidate(t *testing.T) {
-	int1 := 50
-	int2 := -1
-	int3 := 101
-	int1t := &int1
-	int2t := &int2
-	int3t := &int3
-	tests := []struct {
-		name    string // Имя теста для t.Run
-		input   *int   // Входной slug
-		wantErr bool   // Ожидаем ли мы ошибку (true/false)
-	}{
-		// Успешные сценарии
-		{name: "ValidSimple", input: int1t, wantErr: false},
-		{name: "ValidSimple", input: nil, wantErr: false},
-
-		// Ошибочные сценарии
-		{name: "ErrorAbove", input: int2t, wantErr: true},
-		{name: "ErrorLess", input: int3t, wantErr: true},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			err := percentValidate(tt.input)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("percentValidate(%v) error = %v, wantErr %v", tt.input, err, tt.wantErr)
-			}
-		})
-	}
-}
-func TestUserService_GetUserSegments(t *testing.T) {
-	testData := []model.SegmentUserDataDTO{
-		// 1. Ручной, постоянный сегмент (Активен)
-		{
-			Slug: "MANUAL_PERMANENT", IsManuallyAssigned: true, ExpiresAt: nil, AutoPercent: 10,
-		},
-		// 2. Ручной, но ПРОСРОЧЕННЫЙ сегмент (Неактивен - фильтруется)
-		{
-			Slug: "MANUAL_EXPIRED_TTL", IsManuallyAssigned: true, ExpiresAt: &timePast, AutoPercent: 0,
-		},
-		// 3. Автоматический сегмент, в который пользователь попадает (Должен быть активен).
-		// Bucket: calculateDeterministicBucket(1000, "AUTO_HIT") = 7. AutoPercent: 10. (7 < 10, HIT)
-		{
-			Slug: "AUTO_HIT", IsManuallyAssigned: true, ExpiresAt: nil, AutoPercent: 10,
-		},
-		// 4. Автоматический сегмент, в который пользователь не попадает.
-		// Bucket: calculateDeterministicBucket(1000, "AUTO_MISS") = 17. AutoPercent: 10. (17 >= 10, MISS)
-		{
-			Slug: "AUTO_MISS", IsManuallyAssigned: false, ExpiresAt: nil, AutoPercent: 10,
-		},
-		// 5. Ручной, но АКТИВНЫЙ TTL сегмент (Должен быть активен)
-		{
-			Slug: "MANUAL_ACTIVE_TTL", IsManuallyAssigned: true, ExpiresAt: &timeFuture, AutoPercent: 10,
-		},
-	}
-	// Активны только: 1 (Permanent), 3 (Auto Hit), 5 (Active TTL)
-	expectedSlugs := []string{"MANUAL_PERMANENT", "AUTO_HIT", "MANUAL_ACTIVE_TTL"}
-	mockRepo := &MockSegmentRepo{
-		getAllSegmentsData: func(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error) {
-			if userID == 1000 {
-				return testData, nil
-			}
-			return nil, errors.New("user not found from mock db")
-		},
-	}
-	userService := NewUserService(mockRepo)
-	t.Run("Success_FilteringTTLAndHashing", func(t *testing.T) {
-		activeSegments, err := userService.GetUserSegments(ctx, 1000)
-		if err != nil {
-			t.Fatalf("Expected no error, got: %v", err)
-		}
-		var actualSlugs []string
-		for _, activeSegment := range activeSegments {
-			actualSlugs = append(actualSlugs, activeSegment.Slug)
-		}
-		// Сортируем оба списка для надежного сравнения, так как порядок может быть не гарантирован.
-		sort.Strings(actualSlugs)
-		sort.Strings(expectedSlugs)
-		if !reflect.DeepEqual(actualSlugs, expectedSlugs) {
-			t.Errorf("Incorrect segments returned.\nExpected: %v\nGot: %v", expectedSlugs, actualSlugs)
-		}
-	})
-	t.Run("Error_InvalidUserID", func(t *testing.T) {
-		_, err := userService.GetUserSegments(ctx, 0)
-		if err == nil || !errors.Is(err, apperror.ErrUserIDInvalid) {
-			t.Errorf("Expected ErrUserIDInvalid, got: %v", err)
-		}
-	})
-	t.Run("HashDeterminismCheck", func(t *testing.T) {
-		slug := "TEST_HASH_DETERMINISM"
-		userID := int64(9001)
-		firstBucket := calculateDeterministicBucket(userID, slug)
-		secondBucket := calculateDeterministicBucket(userID, slug)
-		if firstBucket != secondBucket {
-			t.Errorf("Hash function must be deterministic. Got %d and %d", firstBucket, secondBucket)
-		}
-	})
-}
-func TestUserService_UpdateUserSegments(t *testing.T) {
-	mockRepo := &MockSegmentRepo{
-		updateUserSegments: func(ctx context.Context, userID int64, addSlugs []string, removeSlugs []string, expiresAt *time.Time) error {
-			if userID == 1000 {
-				return nil
-			}
-			return errors.New("user not found from mock db")
-		},
-	}
-	userService := NewUserService(mockRepo)
-	t.Run("Error_SlugConflict", func(t *testing.T) {
-		ttlHours := 24
-		ttlHoursF := &ttlHours
-		err := userService.UpdateUserSegments(
-			ctx,
-			1000,
-			[]string{"MANUAL_PERMANENT"},
-			[]string{"MANUAL_PERMANENT"},
-			ttlHoursF,
-		)
-		if err == nil || !errors.Is(err, apperror.ErrSegmentConflict) {
-			t.Fatalf("Expected ErrSegmentConflict, got: %v", err)
-		}
-	})
-	t.Run("Error_SlugValidate", func(t *testing.T) {
-		ttlHours := 24
-		ttlHoursF := &ttlHours
-		err := userService.UpdateUserSegments(
-			ctx,
-			1000,
-			[]string{"averylongslugwithmanywordsanddigits123123123123123123123123123123123123123123123123"},
-			[]string{"VOICE_MESSAGE"},
-			ttlHoursF,
-		)
-		if err == nil || !errors.Is(err, apperror.ErrSlugLength) {
-			t.Fatalf("Expected ErrSlugLength, got: %v", err)
-		}
-	})
-	t.Run("Error_BulkLimit", func(t *testing.T) {
-		ttlHours := 24
-		ttlHoursF := &ttlHours
-		var addSlugs []string
-		for i := 0; i < 101; i++ {
-			addSlugs = append(addSlugs, "1234")
-		}
-		err := userService.UpdateUserSegments(
-			ctx,
-			1000,
-			addSlugs,
-			[]string{"VOICE_MESSAGE"},
-			ttlHoursF,
-		)
-		if err == nil || !errors.Is(err, apperror.ErrTooManySegments) {
-			t.Fatalf("Expected ErrTooManySegments, got: %v", err)
-		}
-	})
-}
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"progression1/internal/repository"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (m *MockSegmentRepo) CreateSegment(ctx context.Context, segment model.SegmentDTO, audit model.AuditDTO) error {
+	m.created = segment
+	return nil
+}
+func (m *MockSegmentRepo) DeleteSegment(ctx context.Context, slug string, audit model.AuditDTO) error {
+	m.audit = audit
+	return nil
+}
+func (m *MockSegmentRepo) UpdateSegment(ctx context.Context, slug string, patch model.SegmentPatchDTO) error {
+	m.patched = patch
+	return nil
+}
+func (m *MockSegmentRepo) GetSegmentConfigHistory(ctx context.Context, slug string) ([]model.SegmentConfigHistoryDTO, error) {
+	return nil, nil
+}
+func (m *MockSegmentRepo) GetAllSegments(ctx context.Context, filter model.SegmentFilterDTO) ([]model.SegmentInfoDTO, error) {
+	return nil, nil
+}
+func (m *MockSegmentRepo) StreamHistory(ctx context.Context, filter model.HistoryFilterDTO, fn func(model.HistoryTableDTO) error) error {
+	sent := 0
+	for _, entry := range m.history {
+		if filter.After != nil && !entry.Created_at.After(filter.After.Created_at) &&
+			(!entry.Created_at.Equal(filter.After.Created_at) || entry.ID <= filter.After.ID) {
+			continue
+		}
+		if filter.Limit > 0 && sent == filter.Limit {
+			break
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+		sent++
+	}
+	return nil
+}
+func (m *MockSegmentRepo) SegmentExists(ctx context.Context, slug string) (bool, error) {
+	return m.segmentExists, nil
+}
+func (m *MockSegmentRepo) AddUserToSegment(ctx context.Context, userID int64, slug string, audit model.AuditDTO) error {
+	m.audit = audit
+	return nil
+}
+func (m *MockSegmentRepo) ImportUserSegments(ctx context.Context, userIDs []int64, slugs []string, expiresAt *time.Time, audit model.AuditDTO) error {
+	m.imported = append(m.imported, userIDs...)
+	m.importExpiresAt = expiresAt
+	return nil
+}
+func (m *MockSegmentRepo) StreamSegmentMembers(ctx context.Context, slug string, afterUserID int64, limit int, fn func(model.SegmentMemberDTO) error) error {
+	for _, member := range m.members {
+		if member.User_id <= afterUserID {
+			continue
+		}
+		if err := fn(member); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (m *MockSegmentRepo) GetSegmentsDataAsOf(ctx context.Context, userID int64, asOf time.Time) ([]model.SegmentUserDataDTO, error) {
+	m.asOf = asOf
+	return m.segmentsAsOf, nil
+}
+func (m *MockSegmentRepo) GetAllSegmentsData(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error) {
+	return m.getAllSegmentsData(ctx, userID)
+}
+func (m *MockSegmentRepo) UpdateUserSegments(ctx context.Context, userID int64, add []model.SegmentAssignmentDTO, removeSlugs []string, extend []model.SegmentAssignmentDTO, audit model.AuditDTO) error {
+	m.audit = audit
+	return m.updateUserSegments(ctx, userID, add, removeSlugs, extend)
+}
+
+func (m *MockSegmentRepo) GetRolloutPlan(ctx context.Context, slug string) (model.RolloutPlanDTO, error) {
+	return model.RolloutPlanDTO{}, nil
+}
+func (m *MockSegmentRepo) SetRolloutSteps(ctx context.Context, slug string, steps []model.RolloutStepDTO) error {
+	return nil
+}
+func (m *MockSegmentRepo) SetRolloutPaused(ctx context.Context, slug string, paused bool) error {
+	return nil
+}
+
+func (m *MockSegmentRepo) CreateLayer(ctx context.Context, layer model.LayerDTO) error { return nil }
+func (m *MockSegmentRepo) GetLayers(ctx context.Context) ([]model.LayerDTO, error)     { return nil, nil }
+func (m *MockSegmentRepo) LayerExists(ctx context.Context, slug string) (bool, error) {
+	return true, nil
+}
+func (m *MockSegmentRepo) GetLayerSegments(ctx context.Context, layer string) ([]model.LayerSegmentDTO, error) {
+	return m.layerSegments, nil
+}
+func (m *MockSegmentRepo) GetSegmentLayer(ctx context.Context, slug string) (string, error) {
+	return m.segmentLayer, nil
+}
+
+func (m *MockSegmentRepo) GetUserAttributes(ctx context.Context, userID int64) (map[string]any, error) {
+	return m.userAttributes, nil
+}
+func (m *MockSegmentRepo) GetUsersAttributes(ctx context.Context, userIDs []int64) (map[int64]map[string]any, error) {
+	m.usersAttributesCalls++
+	return m.usersAttributes, nil
+}
+func (m *MockSegmentRepo) GetSegmentsConfig(ctx context.Context) ([]model.SegmentUserDataDTO, error) {
+	return m.segmentsConfig, nil
+}
+func (m *MockSegmentRepo) GetUsersAssignments(ctx context.Context, userIDs []int64) ([]model.UserAssignmentDTO, error) {
+	return m.assignments, nil
+}
+func (m *MockSegmentRepo) SetUserAttributes(ctx context.Context, userID int64, attrs map[string]any) error {
+	return nil
+}
+func (m *MockSegmentRepo) MergeUserAttributes(ctx context.Context, userID int64, attrs map[string]any) error {
+	return nil
+}
+func (m *MockSegmentRepo) DeleteExpiredAssignments(ctx context.Context, limit int) (int, error) {
+	return m.deleteExpiredAssignments(ctx, limit)
+}
+func (m *MockSegmentRepo) GetSegmentData(ctx context.Context, slug string) (model.SegmentUserDataDTO, error) {
+	return m.segmentData, nil
+}
+func (m *MockSegmentRepo) GetUserSegmentData(ctx context.Context, userID int64, slug string) (model.SegmentUserDataDTO, error) {
+	if m.segmentData.Slug != slug {
+		return model.SegmentUserDataDTO{}, apperror.ErrSegmentNotFound
+	}
+	return m.segmentData, nil
+}
+func (m *MockSegmentRepo) GetSegmentStats(ctx context.Context, slug string, from, to time.Time) (model.SegmentStatsDTO, error) {
+	m.statsFrom, m.statsTo = from, to
+	stats := m.stats
+	stats.Slug = slug
+	return stats, nil
+}
+func (m *MockSegmentRepo) ReshuffleSegment(ctx context.Context, slug string, audit model.AuditDTO, batchSize int, changes repository.ReshuffleChangesFunc) (model.ReshuffleResultDTO, error) {
+	m.reshuffledSalt = "a1b2"
+	result := model.ReshuffleResultDTO{Segment_slug: slug}
+	for start := 0; start < len(m.users); start += batchSize {
+		page := m.users[start:min(start+batchSize, len(m.users))]
+		added, removed, err := changes(m.segmentData, m.reshuffledSalt, page)
+		if err != nil {
+			return result, err
+		}
+		result.Added += len(added)
+		result.Removed += len(removed)
+		m.reshufflePages++
+	}
+	return result, nil
+}
+func (m *MockSegmentRepo) ListUsers(ctx context.Context, afterID int64, limit int) ([]model.UserAttributesDTO, error) {
+	var page []model.UserAttributesDTO
+	for _, user := range m.users {
+		if user.UserID > afterID && len(page) < limit {
+			page = append(page, user)
+		}
+	}
+	return page, nil
+}
+func (m *MockSegmentRepo) CountUsers(ctx context.Context) (int64, error) {
+	return int64(len(m.users)), nil
+}
+
+func (m *MockSegmentRepo) GetMaterializations(ctx context.Context) ([]model.MaterializationDTO, error) {
+	return m.materializations, nil
+}
+func (m *MockSegmentRepo) StartMaterialization(ctx context.Context, slug, previousKey, key string) (bool, error) {
+	return true, nil
+}
+func (m *MockSegmentRepo) ApplyMaterialization(ctx context.Context, page model.MaterializationPageDTO) (bool, error) {
+	m.materializationPages = append(m.materializationPages, page)
+	return true, nil
+}
+
+func (m *MockSegmentRepo) GetSegmentOverrides(ctx context.Context, slug string) (model.SegmentOverridesDTO, error) {
+	return m.overrides, nil
+}
+func (m *MockSegmentRepo) UpdateSegmentOverrides(ctx context.Context, slug string, patch model.SegmentOverridesPatchDTO, audit model.AuditDTO) error {
+	return nil
+}
+
+type MockSegmentRepo struct {
+	getAllSegmentsData func(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error)
+	updateUserSegments func(ctx context.Context, userID int64, add []model.SegmentAssignmentDTO, removeSlugs []string, extend []model.SegmentAssignmentDTO) error
+	layerSegments      []model.LayerSegmentDTO
+	segmentLayer       string
+	userAttributes     map[string]any
+	users              []model.UserAttributesDTO
+	created            model.SegmentDTO
+	segmentData        model.SegmentUserDataDTO
+	reshuffledSalt     string
+	reshufflePages     int
+	history            []model.HistoryTableDTO
+	audit              model.AuditDTO
+	segmentsAsOf       []model.SegmentUserDataDTO
+	asOf               time.Time
+	imported           []int64
+	importExpiresAt    *time.Time
+	segmentExists      bool
+	members            []model.SegmentMemberDTO
+	stats              model.SegmentStatsDTO
+	statsFrom          time.Time
+	statsTo            time.Time
+	segmentsConfig     []model.SegmentUserDataDTO
+	assignments        []model.UserAssignmentDTO
+	usersAttributes    map[int64]map[string]any
+
+	materializations     []model.MaterializationDTO
+	overrides            model.SegmentOverridesDTO
+	patched              model.SegmentPatchDTO
+	materializationPages []model.MaterializationPageDTO
+
+	usersAttributesCalls int
+
+	deleteExpiredAssignments func(ctx context.Context, limit int) (int, error)
+}
+
+var (
+	ctx        = context.Background()
+	timeNow    = time.Now()
+	timeFuture = timeNow.Add(time.Hour * 24)
+	timePast   = timeNow.Add(time.Hour * -24)
+)
+
+func TestSlugValidate(t *testing.T) {
+	tests := []struct {
+		name    string // Имя теста для t.Run
+		input   string // Входной slug
+		wantErr bool   // Ожидаем ли мы ошибку (true/false)
+	}{
+		// Успешные сценарии
+		{name: "ValidSimple", input: "AVITO_VOICE", wantErr: false},
+		{name: "ValidDigits", input: "test1000", wantErr: false},
+		{name: "ValidMinLength", input: "abc", wantErr: false},
+
+		// Ошибочные сценарии
+		{name: "ValidMaxLength", input: "a-very-long-slug-with-many-words-and-digits-123", wantErr: true},
+		{name: "ErrorEmpty", input: "", wantErr: true},
+		{name: "ErrorTooShort", input: "ab", wantErr: true},
+		{name: "ErrorSpecialChars", input: "bad-slug!", wantErr: true},
+		{name: "ErrorSpaces", input: "with spaces", wantErr: true},
+		{name: "ErrorReservedHistory", input: "history", wantErr: true},
+		{name: "ErrorReservedImport", input: "import", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := slugValidate(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("slugValidate(%s) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+		})
+	}
+}
+func TestPercentValidate(t *testing.T) {
+	int1 := 50.0
+	int2 := -1.0
+	int3 := 101.0
+	int1t := &int1
+	int2t := &int2
+	int3t := &int3
+	basisPoint := 0.05
+	tooPrecise := 0.005
+	tests := []struct {
+		name    string   // Имя теста для t.Run
+		input   *float64 // Входной slug
+		wantErr bool     // Ожидаем ли мы ошибку (true/false)
+	}{
+		// Успешные сценарии
+		{name: "ValidSimple", input: int1t, wantErr: false},
+		{name: "ValidSimple", input: nil, wantErr: false},
+		{name: "ValidBasisPoints", input: &basisPoint, wantErr: false},
+
+		// Ошибочные сценарии
+		{name: "ErrorAbove", input: int2t, wantErr: true},
+		{name: "ErrorLess", input: int3t, wantErr: true},
+		{name: "ErrorPrecision", input: &tooPrecise, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := percentValidate(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("percentValidate(%v) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+		})
+	}
+}
+func TestUserService_GetUserSegments(t *testing.T) {
+	testData := []model.SegmentUserDataDTO{
+		// 1. Ручной, постоянный сегмент (Активен)
+		{
+			Slug: "MANUAL_PERMANENT", IsManuallyAssigned: true, ExpiresAt: nil, AutoPercent: 10,
+		},
+		// 2. Ручной, но ПРОСРОЧЕННЫЙ сегмент (Неактивен - фильтруется)
+		{
+			Slug: "MANUAL_EXPIRED_TTL", IsManuallyAssigned: true, ExpiresAt: &timePast, AutoPercent: 0,
+		},
+		// 3. Автоматический сегмент, в который пользователь попадает (Должен быть активен).
+		// Bucket: calculateDeterministicBucket(1000, "AUTO_HIT") = 7. AutoPercent: 10. (7 < 10, HIT)
+		{
+			Slug: "AUTO_HIT", IsManuallyAssigned: true, ExpiresAt: nil, AutoPercent: 10,
+		},
+		// 4. Автоматический сегмент, в который пользователь не попадает.
+		// Bucket: calculateDeterministicBucket(1000, "AUTO_MISS") = 17. AutoPercent: 10. (17 >= 10, MISS)
+		{
+			Slug: "AUTO_MISS", IsManuallyAssigned: false, ExpiresAt: nil, AutoPercent: 10,
+		},
+		// 5. Ручной, но АКТИВНЫЙ TTL сегмент (Должен быть активен)
+		{
+			Slug: "MANUAL_ACTIVE_TTL", IsManuallyAssigned: true, ExpiresAt: &timeFuture, AutoPercent: 10,
+		},
+	}
+	// Активны только: 1 (Permanent), 3 (Auto Hit), 5 (Active TTL)
+	expectedSlugs := []string{"MANUAL_PERMANENT", "AUTO_HIT", "MANUAL_ACTIVE_TTL"}
+	mockRepo := &MockSegmentRepo{
+		getAllSegmentsData: func(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error) {
+			if userID == 1000 {
+				return testData, nil
+			}
+			return nil, errors.New("user not found from mock db")
+		},
+	}
+	userService := NewUserService(mockRepo)
+	t.Run("Success_FilteringTTLAndHashing", func(t *testing.T) {
+		activeSegments, err := userService.GetUserSegments(ctx, 1000, nil)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		var actualSlugs []string
+		for _, activeSegment := range activeSegments {
+			actualSlugs = append(actualSlugs, activeSegment.Slug)
+		}
+		// Сортируем оба списка для надежного сравнения, так как порядок может быть не гарантирован.
+		sort.Strings(actualSlugs)
+		sort.Strings(expectedSlugs)
+		if !reflect.DeepEqual(actualSlugs, expectedSlugs) {
+			t.Errorf("Incorrect segments returned.\nExpected: %v\nGot: %v", expectedSlugs, actualSlugs)
+		}
+	})
+	t.Run("Error_InvalidUserID", func(t *testing.T) {
+		_, err := userService.GetUserSegments(ctx, 0, nil)
+		if err == nil || !errors.Is(err, apperror.ErrUserIDInvalid) {
+			t.Errorf("Expected ErrUserIDInvalid, got: %v", err)
+		}
+	})
+	t.Run("HashDeterminismCheck", func(t *testing.T) {
+		slug := "TEST_HASH_DETERMINISM"
+		userID := int64(9001)
+		firstBucket := calculateDeterministicBucket(userID, slug)
+		secondBucket := calculateDeterministicBucket(userID, slug)
+		if firstBucket != secondBucket {
+			t.Errorf("Hash function must be deterministic. Got %d and %d", firstBucket, secondBucket)
+		}
+	})
+}
+func TestUserService_UpdateUserSegments(t *testing.T) {
+	mockRepo := &MockSegmentRepo{
+		updateUserSegments: func(ctx context.Context, userID int64, add []model.SegmentAssignmentDTO, removeSlugs []string, extend []model.SegmentAssignmentDTO) error {
+			if userID == 1000 {
+				return nil
+			}
+			return errors.New("user not found from mock db")
+		},
+	}
+	userService := NewUserService(mockRepo)
+	t.Run("Error_SlugConflict", func(t *testing.T) {
+		ttlHours := 24
+		ttlHoursF := &ttlHours
+		err := userService.UpdateUserSegments(
+			ctx,
+			1000,
+			model.SegmentUpdateUserDTO{AddSlugs: []string{"MANUAL_PERMANENT"}, RemoveSlugs: []string{"MANUAL_PERMANENT"}, TTLHours: ttlHoursF},
+			model.AuditDTO{},
+		)
+		if err == nil || !errors.Is(err, apperror.ErrSegmentConflict) {
+			t.Fatalf("Expected ErrSegmentConflict, got: %v", err)
+		}
+	})
+	t.Run("Error_SlugValidate", func(t *testing.T) {
+		ttlHours := 24
+		ttlHoursF := &ttlHours
+		err := userService.UpdateUserSegments(
+			ctx,
+			1000,
+			model.SegmentUpdateUserDTO{AddSlugs: []string{"averylongslugwithmanywordsanddigits123123123123123123123123123123123123123123123123"}, RemoveSlugs: []string{"VOICE_MESSAGE"}, TTLHours: ttlHoursF},
+			model.AuditDTO{},
+		)
+		if err == nil || !errors.Is(err, apperror.ErrSlugLength) {
+			t.Fatalf("Expected ErrSlugLength, got: %v", err)
+		}
+	})
+	t.Run("Error_BulkLimit", func(t *testing.T) {
+		ttlHours := 24
+		ttlHoursF := &ttlHours
+		var addSlugs []string
+		for i := 0; i < 101; i++ {
+			addSlugs = append(addSlugs, "1234")
+		}
+		err := userService.UpdateUserSegments(
+			ctx,
+			1000,
+			model.SegmentUpdateUserDTO{AddSlugs: addSlugs, RemoveSlugs: []string{"VOICE_MESSAGE"}, TTLHours: ttlHoursF},
+			model.AuditDTO{},
+		)
+		if err == nil || !errors.Is(err, apperror.ErrTooManySegments) {
+			t.Fatalf("Expected ErrTooManySegments, got: %v", err)
+		}
+	})
+}
+func TestUserService_UpdateUserSegments_TTL(t *testing.T) {
+	var gotAdd, gotExtend []model.SegmentAssignmentDTO
+	mockRepo := &MockSegmentRepo{
+		getAllSegmentsData: func(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error) {
+			return nil, nil
+		},
+		updateUserSegments: func(ctx context.Context, userID int64, add []model.SegmentAssignmentDTO, removeSlugs []string, extend []model.SegmentAssignmentDTO) error {
+			gotAdd, gotExtend = add, extend
+			return nil
+		},
+	}
+	userService := NewUserService(mockRepo)
+	ttl, tooLong, zero := 24, 720, 0
+	tests := []struct {
+		name    string
+		input   model.SegmentUpdateUserDTO
+		wantErr error
+	}{
+		{name: "ErrorTTLTooLong", input: model.SegmentUpdateUserDTO{AddSlugs: []string{"AVITO_VOICE"}, TTLHours: &tooLong}, wantErr: apperror.ErrTTLRange},
+		{name: "ErrorTTLZero", input: model.SegmentUpdateUserDTO{Add: []model.SegmentAssignmentDTO{{Slug: "AVITO_VOICE", TTL_hours: &zero}}}, wantErr: apperror.ErrTTLRange},
+		{name: "ErrorExpiresAtPast", input: model.SegmentUpdateUserDTO{Add: []model.SegmentAssignmentDTO{{Slug: "AVITO_VOICE", Expires_at: &timePast}}}, wantErr: apperror.ErrExpiresAtPast},
+		{name: "ErrorTTLAndExpiresAt", input: model.SegmentUpdateUserDTO{Add: []model.SegmentAssignmentDTO{{Slug: "AVITO_VOICE", TTL_hours: &ttl, Expires_at: &timeFuture}}}, wantErr: apperror.ErrTTLConflict},
+		{name: "ErrorEmptyUpdate", input: model.SegmentUpdateUserDTO{Update: []model.SegmentAssignmentDTO{{Slug: "AVITO_VOICE"}}}, wantErr: apperror.ErrTTLUpdateEmpty},
+		{name: "ErrorUpdateAndRemove", input: model.SegmentUpdateUserDTO{RemoveSlugs: []string{"AVITO_VOICE"}, Update: []model.SegmentAssignmentDTO{{Slug: "AVITO_VOICE", Clear_ttl: true}}}, wantErr: apperror.ErrSegmentDuplicate},
+		{name: "ErrorAddAndRemove", input: model.SegmentUpdateUserDTO{Add: []model.SegmentAssignmentDTO{{Slug: "AVITO_VOICE"}}, RemoveSlugs: []string{"AVITO_VOICE"}}, wantErr: apperror.ErrSegmentConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := userService.UpdateUserSegments(ctx, 1000, tt.input, model.AuditDTO{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("UpdateUserSegments() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	t.Run("Success_PerSlugExpiry", func(t *testing.T) {
+		err := userService.UpdateUserSegments(ctx, 1000, model.SegmentUpdateUserDTO{
+			AddSlugs: []string{"AVITO_VOICE"},
+			TTLHours: &ttl,
+			Add:      []model.SegmentAssignmentDTO{{Slug: "AVITO_CHAT", Expires_at: &timeFuture}, {Slug: "AVITO_FOREVER"}},
+			Update:   []model.SegmentAssignmentDTO{{Slug: "AVITO_OLD", Clear_ttl: true}, {Slug: "AVITO_TRIAL", TTL_hours: &ttl}},
+		}, model.AuditDTO{})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(gotAdd) != 3 || len(gotExtend) != 2 {
+			t.Fatalf("Expected 3 added and 2 updated assignments, got: %v, %v", gotAdd, gotExtend)
+		}
+		if gotAdd[0].Expires_at == nil || gotAdd[0].Expires_at.Sub(timeNow) < 23*time.Hour {
+			t.Errorf("Expected ttl_hours to resolve to expires_at in 24h, got: %v", gotAdd[0].Expires_at)
+		}
+		if !gotAdd[1].Expires_at.Equal(timeFuture) || gotAdd[2].Expires_at != nil {
+			t.Errorf("Expected explicit expires_at and permanent assignment, got: %v, %v", gotAdd[1].Expires_at, gotAdd[2].Expires_at)
+		}
+		if gotExtend[0].Expires_at != nil || gotExtend[1].Expires_at == nil {
+			t.Errorf("Expected cleared and extended TTL, got: %v, %v", gotExtend[0].Expires_at, gotExtend[1].Expires_at)
+		}
+	})
+	t.Run("Success_UpdateOnlyAudit", func(t *testing.T) {
+		audit := model.AuditDTO{Actor: "support", Reason: "trial extended"}
+		err := userService.UpdateUserSegments(ctx, 1000, model.SegmentUpdateUserDTO{
+			Update: []model.SegmentAssignmentDTO{{Slug: "AVITO_TRIAL", TTL_hours: &ttl}},
+		}, audit)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(gotExtend) != 1 || mockRepo.audit != audit {
+			t.Errorf("Expected TTL update with audit %v, got: %v, %v", audit, gotExtend, mockRepo.audit)
+		}
+	})
+}
+func TestUserService_DeleteSegment(t *testing.T) {
+	mockRepo := &MockSegmentRepo{}
+	userService := NewUserService(mockRepo)
+	t.Run("Success", func(t *testing.T) {
+		audit := model.AuditDTO{Actor: "admin-panel", Reason: "experiment finished"}
+		if err := userService.DeleteSegment(ctx, "AVITO_VOICE", audit); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if mockRepo.audit != audit {
+			t.Errorf("Expected audit %v to reach repository, got: %v", audit, mockRepo.audit)
+		}
+	})
+	t.Run("Error_ReasonLength", func(t *testing.T) {
+		err := userService.DeleteSegment(ctx, "AVITO_VOICE", model.AuditDTO{Reason: strings.Repeat("x", 501)})
+		if !errors.Is(err, apperror.ErrReasonLength) {
+			t.Fatalf("Expected ErrReasonLength, got: %v", err)
+		}
+	})
+	t.Run("Error_SlugValidate", func(t *testing.T) {
+		err := userService.DeleteSegment(ctx, "bad-slug!", model.AuditDTO{})
+		if err == nil || !errors.Is(err, apperror.ErrSlugRegex) {
+			t.Fatalf("Expected ErrSlugRegex, got: %v", err)
+		}
+	})
+}
+func TestMetadataValidate(t *testing.T) {
+	var manyTags []string
+	for i := 0; i < 21; i++ {
+		manyTags = append(manyTags, "tag")
+	}
+	tests := []struct {
+		name    string
+		input   model.SegmentDTO
+		wantErr error
+	}{
+		{name: "ValidEmpty", input: model.SegmentDTO{Slug: "AVITO_VOICE"}, wantErr: nil},
+		{name: "ValidFull", input: model.SegmentDTO{Slug: "AVITO_VOICE", Description: "voice messages", Owner: "messenger", Tags: []string{"ab", "voice"}}, wantErr: nil},
+		{name: "ErrorTooManyTags", input: model.SegmentDTO{Slug: "AVITO_VOICE", Tags: manyTags}, wantErr: apperror.ErrTooManyTags},
+		{name: "ErrorEmptyTag", input: model.SegmentDTO{Slug: "AVITO_VOICE", Tags: []string{""}}, wantErr: apperror.ErrTagInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := metadataValidate(tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("metadataValidate(%v) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+		})
+	}
+}
+func TestUserService_UpdateSegment(t *testing.T) {
+	userService := NewUserService(&MockSegmentRepo{})
+	percent := 50.0
+	tooBig := 101.0
+	t.Run("Success", func(t *testing.T) {
+		if err := userService.UpdateSegment(ctx, "AVITO_VOICE", model.SegmentPatchDTO{Auto_percent: &percent}); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+	})
+	t.Run("Error_EmptyPatch", func(t *testing.T) {
+		err := userService.UpdateSegment(ctx, "AVITO_VOICE", model.SegmentPatchDTO{})
+		if err == nil || !errors.Is(err, apperror.ErrEmptyPatch) {
+			t.Fatalf("Expected ErrEmptyPatch, got: %v", err)
+		}
+	})
+	t.Run("Error_PercentAbove", func(t *testing.T) {
+		err := userService.UpdateSegment(ctx, "AVITO_VOICE", model.SegmentPatchDTO{Auto_percent: &tooBig})
+		if err == nil || !errors.Is(err, apperror.ErrPercentAbove) {
+			t.Fatalf("Expected ErrPercentAbove, got: %v", err)
+		}
+	})
+	t.Run("Error_ActiveWindow", func(t *testing.T) {
+		err := userService.UpdateSegment(ctx, "AVITO_VOICE", model.SegmentPatchDTO{Active_from: &timeFuture, Active_until: &timePast})
+		if err == nil || !errors.Is(err, apperror.ErrActiveWindow) {
+			t.Fatalf("Expected ErrActiveWindow, got: %v", err)
+		}
+	})
+	t.Run("Success_ClearActiveWindow", func(t *testing.T) {
+		mockRepo := &MockSegmentRepo{}
+		patch := model.SegmentPatchDTO{Clear_active_from: true, Clear_active_until: true}
+		if err := NewUserService(mockRepo).UpdateSegment(ctx, "AVITO_VOICE", patch); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if mockRepo.patched != patch {
+			t.Errorf("Expected clear flags to reach repository, got: %+v", mockRepo.patched)
+		}
+	})
+	t.Run("Error_ActiveClearConflict", func(t *testing.T) {
+		err := userService.UpdateSegment(ctx, "AVITO_VOICE", model.SegmentPatchDTO{Active_until: &timeFuture, Clear_active_until: true})
+		if !errors.Is(err, apperror.ErrActiveClear) {
+			t.Fatalf("Expected ErrActiveClear, got: %v", err)
+		}
+	})
+}
+func TestUserService_GetUserSegments_ActiveWindow(t *testing.T) {
+	mockRepo := &MockSegmentRepo{
+		getAllSegmentsData: func(ctx context.Context, userID int64) ([]model.SegmentUserDataDTO, error) {
+			return []model.SegmentUserDataDTO{
+				{Slug: "MANUAL_SCHEDULED", IsManuallyAssigned: true, ActiveFrom: &timeFuture},
+				{Slug: "MANUAL_ENDED", IsManuallyAssigned: true, ActiveUntil: &timePast},
+				{Slug: "MANUAL_ACTIVE", IsManuallyAssigned: true, ActiveFrom: &timePast, ActiveUntil: &timeFuture},
+				{Slug: "AUTO_HIT", AutoPercent: 10, ActiveUntil: &timePast},
+			}, nil
+		},
+	}
+	segments, err := NewUserService(mockRepo).GetUserSegments(ctx, 1000, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(segments) != 1 || segments[0].Slug != "MANUAL_ACTIVE" {
+		t.Errorf("Expected only MANUAL_ACTIVE, got: %v", segments)
+	}
+}
+
+// legacyBucket — процентный бакет до перехода на базисные пункты
+func legacyBucket(userID int64, slug string) int {
+	h := fnv.New32a()
+	h.Write([]byte(fmt.Sprintf("%d:%s", userID, slug)))
+	return int(h.Sum32()) % 100
+}
+
+func TestAutoBucketHit_BasisPoints(t *testing.T) {
+	// Целые проценты дают ту же выборку, что и прежние бакеты 0..99
+	for _, percent := range []float64{1, 10, 50, 99} {
+		for userID := int64(1); userID <= 2000; userID++ {
+			segment := model.SegmentUserDataDTO{Slug: "AVITO_VOICE", AutoPercent: percent}
+			if got, want := autoBucketHit(userID, segment), legacyBucket(userID, "AVITO_VOICE") < int(percent); got != want {
+				t.Fatalf("autoBucketHit(%d, %v%%) = %v, legacy = %v", userID, percent, got, want)
+			}
+		}
+	}
+	// Дробный процент выбирает подмножество ближайшего большего целого процента
+	hits := 0
+	for userID := int64(1); userID <= 200000; userID++ {
+		if !autoBucketHit(userID, model.SegmentUserDataDTO{Slug: "AVITO_VOICE", AutoPercent: 0.05}) {
+			continue
+		}
+		hits++
+		if legacyBucket(userID, "AVITO_VOICE") != 0 {
+			t.Fatalf("User %d in 0.05%% is outside 1%%", userID)
+		}
+	}
+	if hits < 50 || hits > 150 {
+		t.Errorf("Expected about 100 of 200000 users in 0.05%%, got %d", hits)
+	}
+}
+
+func TestExplainSegment(t *testing.T) {
+	// legacyBucket(1000, "AUTO_MISS") = 30
+	tests := []struct {
+		name       string
+		segment    model.SegmentUserDataDTO
+		attrs      map[string]string
+		wantMember bool
+		wantReason string
+	}{
+		{name: "Manual", segment: model.SegmentUserDataDTO{Slug: "MANUAL", IsManuallyAssigned: true, ExpiresAt: &timeFuture}, wantMember: true, wantReason: model.ReasonManual},
+		{name: "ExpiredTTL", segment: model.SegmentUserDataDTO{Slug: "MANUAL", IsManuallyAssigned: true, ExpiresAt: &timePast, AutoPercent: 100}, wantReason: model.ReasonExpired},
+		{name: "Inactive", segment: model.SegmentUserDataDTO{Slug: "ENDED", AutoPercent: 100, ActiveUntil: &timePast}, wantReason: model.ReasonInactive},
+		{name: "Excluded", segment: model.SegmentUserDataDTO{Slug: "AUTO_ALL", AutoPercent: 100, Override: model.OverrideExclude}, wantReason: model.ReasonOverrideExclude},
+		{name: "Included", segment: model.SegmentUserDataDTO{Slug: "AUTO_MISS", Override: model.OverrideInclude}, wantMember: true, wantReason: model.ReasonOverrideInclude},
+		{name: "RuleNotMatched", segment: model.SegmentUserDataDTO{Slug: "RULE", AutoPercent: 100, TargetingRule: testRule}, attrs: map[string]string{"country": "KZ"}, wantReason: model.ReasonRuleNotMatched},
+		{name: "NoAutoPercent", segment: model.SegmentUserDataDTO{Slug: "AUTO_MISS"}, wantReason: model.ReasonNoAutoPercent},
+		{name: "BucketMiss", segment: model.SegmentUserDataDTO{Slug: "AUTO_MISS", AutoPercent: 10}, wantReason: model.ReasonBucketMiss},
+		{name: "BucketHit", segment: model.SegmentUserDataDTO{Slug: "AUTO_MISS", AutoPercent: 31}, wantMember: true, wantReason: model.ReasonBucketHit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := explainSegment(1000, tt.segment, tt.attrs, timeNow)
+			if decision.Member != tt.wantMember || decision.Reason != tt.wantReason {
+				t.Errorf("explainSegment() = %v/%s, want %v/%s", decision.Member, decision.Reason, tt.wantMember, tt.wantReason)
+			}
+		})
+	}
+	decision := explainSegment(1000, model.SegmentUserDataDTO{Slug: "AUTO_MISS", AutoPercent: 10}, nil, timeNow)
+	if decision.Bucket == nil || int(*decision.Bucket) != legacyBucket(1000, "AUTO_MISS") {
+		t.Errorf("Expected bucket in percent to match the legacy bucket, got: %v", decision.Bucket)
+	}
+}
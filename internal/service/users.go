@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+)
+
+// attributesValidate проверяет атрибуты пользователя; allowNull разрешает null
+// как признак удаления атрибута при частичном обновлении
+func attributesValidate(attrs map[string]any, allowNull bool) error {
+	if len(attrs) > 100 {
+		return apperror.ErrTooManyAttributes
+	}
+	for key, value := range attrs {
+		if key == "" || len(key) > 50 {
+			return apperror.ErrAttributeKey
+		}
+		switch value.(type) {
+		case string, float64, bool:
+		case nil:
+			if !allowNull {
+				return fmt.Errorf("%w: %s", apperror.ErrAttributeValue, key)
+			}
+		default:
+			return fmt.Errorf("%w: %s", apperror.ErrAttributeValue, key)
+		}
+	}
+	return nil
+}
+
+// userAttributes объединяет сохранённые атрибуты пользователя с переданными в запросе;
+// переданные в запросе имеют приоритет
+func (s *UserService) userAttributes(ctx context.Context, userID int64, requestAttrs map[string]any) (map[string]string, error) {
+	stored, err := s.segRepo.GetUserAttributes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]any, len(stored)+len(requestAttrs))
+	maps.Copy(merged, stored)
+	maps.Copy(merged, requestAttrs)
+	return normalizeAttributes(merged)
+}
+
+func (s *UserService) GetUserAttributes(ctx context.Context, userID int64) (model.UserAttributesDTO, error) {
+	if userID <= 0 {
+		return model.UserAttributesDTO{}, apperror.ErrUserIDInvalid
+	}
+	attrs, err := s.segRepo.GetUserAttributes(ctx, userID)
+	if err != nil {
+		return model.UserAttributesDTO{}, err
+	}
+	return model.UserAttributesDTO{UserID: userID, Attributes: attrs}, nil
+}
+
+// SetUserAttributes полностью заменяет сохранённые атрибуты пользователя
+func (s *UserService) SetUserAttributes(ctx context.Context, userID int64, attrs map[string]any) error {
+	if userID <= 0 {
+		return apperror.ErrUserIDInvalid
+	}
+	if attrs == nil {
+		attrs = map[string]any{}
+	}
+	if err := attributesValidate(attrs, false); err != nil {
+		return err
+	}
+	return s.segRepo.SetUserAttributes(ctx, userID, attrs)
+}
+
+// MergeUserAttributes дополняет сохранённые атрибуты; null удаляет атрибут
+func (s *UserService) MergeUserAttributes(ctx context.Context, userID int64, attrs map[string]any) error {
+	if userID <= 0 {
+		return apperror.ErrUserIDInvalid
+	}
+	if len(attrs) == 0 {
+		return apperror.ErrEmptyPatch
+	}
+	if err := attributesValidate(attrs, true); err != nil {
+		return err
+	}
+	return s.segRepo.MergeUserAttributes(ctx, userID, attrs)
+}
+
+// knownUsersBatchSize — размер страницы при обходе реестра пользователей
+const knownUsersBatchSize = 1000
@@ -0,0 +1,114 @@
+package https
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+)
+
+// @Summary Получить атрибуты пользователя
+// @Description Возвращает сохранённые атрибуты пользователя, используемые правилами таргетинга
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param user_id path int true "ID пользователя"
+// @Success 200 {object} model.UserAttributesDTO "Успешная операция"
+// @Failure 400 {object} model.ErrorDTO "Невалидный ID пользователя"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /users/{user_id}/attributes [get]
+func (h *HTTPHandlers) HandleGetUserAttributes(w http.ResponseWriter, r *http.Request) {
+	userID, err := geIDfFromPath(r.URL.Path)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	attrs, err := h.UserService.GetUserAttributes(r.Context(), userID)
+	if err != nil {
+		writeJSONError(w, attributesErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(attrs); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+// @Summary Заменить атрибуты пользователя
+// @Description Полностью заменяет сохранённые атрибуты пользователя. Значения — строки, числа или булевы.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param user_id path int true "ID пользователя"
+// @Param input body model.UserContextDTO true "Атрибуты пользователя"
+// @Success 200 {string} string "Успешная операция"
+// @Failure 400 {object} model.ErrorDTO "Невалидный запрос"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /users/{user_id}/attributes [put]
+func (h *HTTPHandlers) HandleSetUserAttributes(w http.ResponseWriter, r *http.Request) {
+	userID, err := geIDfFromPath(r.URL.Path)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var dto model.UserContextDTO
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if err := h.UserService.SetUserAttributes(r.Context(), userID, dto.Attributes); err != nil {
+		writeJSONError(w, attributesErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode("user attributes successfully updated"); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+// @Summary Дополнить атрибуты пользователя
+// @Description Добавляет или меняет переданные атрибуты пользователя, остальные не трогает. Значение null удаляет атрибут.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param user_id path int true "ID пользователя"
+// @Param input body model.UserContextDTO true "Изменяемые атрибуты пользователя"
+// @Success 200 {string} string "Успешная операция"
+// @Failure 400 {object} model.ErrorDTO "Невалидный запрос"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /users/{user_id}/attributes [patch]
+func (h *HTTPHandlers) HandleMergeUserAttributes(w http.ResponseWriter, r *http.Request) {
+	userID, err := geIDfFromPath(r.URL.Path)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var dto model.UserContextDTO
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if err := h.UserService.MergeUserAttributes(r.Context(), userID, dto.Attributes); err != nil {
+		writeJSONError(w, attributesErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode("user attributes successfully updated"); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+func attributesErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, apperror.ErrUserIDInvalid),
+		errors.Is(err, apperror.ErrEmptyPatch),
+		errors.Is(err, apperror.ErrTooManyAttributes),
+		errors.Is(err, apperror.ErrAttributeKey),
+		errors.Is(err, apperror.ErrAttributeValue):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
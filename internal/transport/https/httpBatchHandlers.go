@@ -0,0 +1,42 @@
+package https
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+)
+
+// @Summary Получить сегменты нескольких пользователей
+// @Description Вычисляет активные сегменты до 1000 пользователей за один запрос; результат совпадает с GET /user/{user_id} без атрибутов в запросе (правила таргетинга проверяются по атрибутам из реестра). Повторяющиеся ID учитываются один раз.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param input body model.BatchUserSegmentsRequestDTO true "ID пользователей"
+// @Success 200 {object} model.BatchUserSegmentsDTO "Активные сегменты по ID пользователя"
+// @Failure 400 {object} model.ErrorDTO "Невалидный запрос"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /users/segments [post]
+func (h *HTTPHandlers) HandleGetUsersSegments(w http.ResponseWriter, r *http.Request) {
+	var dto model.BatchUserSegmentsRequestDTO
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	users, err := h.UserService.GetUsersSegments(r.Context(), dto.User_ids)
+	if err != nil {
+		if errors.Is(err, apperror.ErrBatchSize) || errors.Is(err, apperror.ErrUserIDInvalid) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		} else {
+			slog.Error("failed to get users segments", "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(model.BatchUserSegmentsDTO{Users: users}); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
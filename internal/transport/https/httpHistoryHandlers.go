@@ -0,0 +1,180 @@
+package https
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"progression1/internal/service"
+	"strconv"
+	"time"
+)
+
+// @Summary Получить историю операций с сегментами
+// @Description История добавления/удаления сегментов по возрастанию (created_at, id). Фильтры необязательны и комбинируются; 'year' и 'month' — сокращение для диапазона завершённого месяца. Без 'cursor' и 'limit' ответ, как и прежде, — массив всех подходящих записей без ограничения. С 'cursor' или 'limit' ответ постраничный: {items, next_cursor}, по умолчанию 100 записей на странице; для следующей страницы передайте next_cursor в 'cursor' с теми же фильтрами, на последней странице next_cursor отсутствует. Ответ передаётся потоком, без буферизации.
+// @Tags segment
+// @Produce json
+// @Param user_id query int false "ID пользователя"
+// @Param slug query string false "Slug сегмента"
+// @Param operation query string false "Операция (ADDED, REMOVED, AUTO_ADDED, ...)"
+// @Param actor query string false "Кто внёс изменение (значение X-Actor)"
+// @Param from query string false "Начало диапазона включительно (RFC3339)"
+// @Param to query string false "Конец диапазона не включительно (RFC3339)"
+// @Param year query int false "Год для фильтрации истории (например, 2024)"
+// @Param month query int false "Месяц для фильтрации истории (1-12)"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Param limit query int false "Размер страницы (1-1000, по умолчанию 100)"
+// @Success 200 {object} model.HistoryPageDTO "Страница истории (с cursor или limit); без них — массив model.HistoryTableDTO"
+// @Failure 400 {object} model.ErrorDTO "Невалидные фильтры, период или курсор"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /segments/history [get]
+func (h *HTTPHandlers) HandleGetH(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter, err := historyFilterFromQuery(q)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Без cursor и limit сохраняется прежний ответ — массив записей без разбиения на страницы
+	paged := q.Has("cursor") || q.Has("limit")
+	stream := &historyStream{w: w, enc: json.NewEncoder(w), array: !paged}
+	var next string
+	if paged {
+		next, err = h.UserService.StreamHistory(r.Context(), filter, q.Get("cursor"), stream.write)
+	} else {
+		err = h.UserService.StreamAllHistory(r.Context(), filter, stream.write)
+	}
+	if err != nil {
+		if stream.started {
+			// Статус уже отправлен — обрываем ответ, чтобы клиент не принял его за полный
+			slog.Error("history stream interrupted", "error", err)
+			panic(http.ErrAbortHandler)
+		}
+		if errors.Is(err, apperror.ErrHistoryCursor) ||
+			errors.Is(err, apperror.ErrHistoryLimit) ||
+			errors.Is(err, apperror.ErrHistoryRange) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		} else {
+			slog.Error("failed to get history", "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "failed to get history")
+		}
+		return
+	}
+	if err := stream.finish(next); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+func historyFilterFromQuery(q url.Values) (model.HistoryFilterDTO, error) {
+	var filter model.HistoryFilterDTO
+	if v := q.Get("user_id"); v != "" {
+		userID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || userID <= 0 {
+			return filter, apperror.ErrUserIDInvalid
+		}
+		filter.User_ID = &userID
+	}
+	filter.Slug = q.Get("slug")
+	filter.Operation = q.Get("operation")
+	filter.Actor = q.Get("actor")
+	for _, bound := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		v := q.Get(bound.name)
+		if v == "" {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("'%s' must be an RFC3339 timestamp", bound.name)
+		}
+		*bound.dst = &ts
+	}
+	if q.Has("year") || q.Has("month") {
+		if filter.From != nil || filter.To != nil {
+			return filter, errors.New("'year' and 'month' cannot be combined with 'from' and 'to'")
+		}
+		year, err := strconv.Atoi(q.Get("year"))
+		if err != nil {
+			return filter, err
+		}
+		month, err := strconv.Atoi(q.Get("month"))
+		if err != nil {
+			return filter, err
+		}
+		from, to, err := service.MonthRange(year, month)
+		if err != nil {
+			return filter, err
+		}
+		filter.From, filter.To = &from, &to
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return filter, apperror.ErrHistoryLimit
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+// historyStream пишет страницу истории в формате model.HistoryPageDTO (или, если array,
+// массивом записей) по мере чтения. Заголовки отправляются с первой записью, поэтому ошибки
+// до неё ещё можно вернуть статусом.
+type historyStream struct {
+	w       http.ResponseWriter
+	enc     *json.Encoder
+	array   bool
+	started bool
+}
+
+func (s *historyStream) write(entry model.HistoryTableDTO) error {
+	sep := ","
+	if !s.started {
+		sep = s.begin()
+	}
+	if _, err := io.WriteString(s.w, sep); err != nil {
+		return err
+	}
+	return s.enc.Encode(entry)
+}
+
+// begin отправляет заголовки и возвращает начало ответа
+func (s *historyStream) begin() string {
+	s.started = true
+	s.w.Header().Set("Content-Type", "application/json")
+	if s.array {
+		return "["
+	}
+	return `{"items":[`
+}
+
+func (s *historyStream) finish(next string) error {
+	end := "]"
+	if !s.started {
+		end = s.begin() + end
+	}
+	if s.array {
+		_, err := io.WriteString(s.w, end+"\n")
+		return err
+	}
+	if _, err := io.WriteString(s.w, end); err != nil {
+		return err
+	}
+	if next != "" {
+		if _, err := io.WriteString(s.w, `,"next_cursor":`); err != nil {
+			return err
+		}
+		if err := s.enc.Encode(next); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(s.w, "}\n")
+	return err
+}
@@ -0,0 +1,95 @@
+package https
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"strconv"
+	"strings"
+)
+
+// @Summary Импортировать пользователей в сегменты из CSV
+// @Description Добавляет пользователей из загруженного файла во все перечисленные сегменты. Файл передаётся частью 'file' в multipart/form-data и читается потоком; ID пользователя берётся из первой колонки (разделители ",", ";" или табуляция), первая нечисловая строка считается заголовком. Строки с невалидным ID отклоняются и перечисляются в ответе (первые 100). Каждое назначение пишется в историю с операцией ADDED. Повторный импорт безопасен: существующим назначениям обновляется срок действия.
+// @Tags user
+// @Accept multipart/form-data
+// @Produce json
+// @Param segments query string true "SLUG сегментов через запятую"
+// @Param ttl_hours query int false "Время жизни назначений в часах (1-719)"
+// @Param reason query string false "Причина изменения (сохраняется в истории)"
+// @Param X-Actor header string false "Кто вносит изменение (сохраняется в истории)"
+// @Param file formData file true "CSV-файл с ID пользователей"
+// @Success 200 {object} model.ImportResultDTO "Итог импорта"
+// @Failure 400 {object} model.ErrorDTO "Невалидные параметры, сегмент слоя или нет файла"
+// @Failure 404 {object} model.ErrorDTO "Сегмент не найден"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера; уже импортированные пачки сохраняются"
+// @Router /segments/import [post]
+func (h *HTTPHandlers) HandleImportSegmentUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	request := model.ImportRequestDTO{}
+	for _, value := range q["segments"] {
+		for _, slug := range strings.Split(value, ",") {
+			if slug = strings.TrimSpace(slug); slug != "" {
+				request.Segments = append(request.Segments, slug)
+			}
+		}
+	}
+	if v := q.Get("ttl_hours"); v != "" {
+		ttl, err := strconv.Atoi(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, apperror.ErrTTLRange.Error())
+			return
+		}
+		request.TTL_hours = &ttl
+	}
+	file, err := importFilePart(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer file.Close()
+	result, err := h.UserService.ImportSegmentUsers(r.Context(), file, request, requestAudit(r, q.Get("reason")))
+	if err != nil {
+		status := segmentErrorStatus(err)
+		if errors.Is(err, apperror.ErrImportNoSegments) ||
+			errors.Is(err, apperror.ErrImportLayer) ||
+			errors.Is(err, apperror.ErrSegmentDuplicate) ||
+			errors.Is(err, apperror.ErrTooManySegments) ||
+			errors.Is(err, apperror.ErrTTLRange) {
+			status = http.StatusBadRequest
+		}
+		if status == http.StatusInternalServerError {
+			slog.Error("segment import failed", "error", err, "segments", request.Segments, "accepted", result.Accepted, "rejected", result.Rejected)
+		}
+		writeJSONError(w, status, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+// importFilePart возвращает часть 'file' multipart-запроса без буферизации тела
+func importFilePart(r *http.Request) (io.ReadCloser, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, errors.New("expected multipart/form-data request with a 'file' part")
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("multipart request has no 'file' part")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
@@ -0,0 +1,54 @@
+package https
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"progression1/internal/model"
+)
+
+// @Summary Создать слой
+// @Description Создаёт слой взаимоисключающих экспериментов. Пользователь попадает максимум в один сегмент слоя.
+// @Tags layer
+// @Accept json
+// @Produce json
+// @Param input body model.LayerDTO true "Параметры слоя"
+// @Success 200 {string} string "Успешная операция"
+// @Failure 400 {object} model.ErrorDTO "Невалидный запрос"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /layers [post]
+func (h *HTTPHandlers) HandleCreateLayer(w http.ResponseWriter, r *http.Request) {
+	var dto model.LayerDTO
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if err := h.UserService.CreateLayer(r.Context(), dto); err != nil {
+		writeJSONError(w, segmentErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode("layer successfully added"); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+// @Summary Получить все слои
+// @Description Получает все слои с диапазонами бакетов, занятыми их сегментами
+// @Tags layer
+// @Accept json
+// @Produce json
+// @Success 200 {array} model.LayerDTO "Успешная операция"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /layers [get]
+func (h *HTTPHandlers) HandleGetLayers(w http.ResponseWriter, r *http.Request) {
+	layers, err := h.UserService.GetLayers(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to fetch layers")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(layers); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
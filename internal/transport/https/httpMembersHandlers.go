@@ -0,0 +1,160 @@
+package https
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"strconv"
+	"time"
+)
+
+// @Summary Выгрузить участников сегмента
+// @Description Потоком отдаёт пользователей, вручную назначенных в сегмент (с действующим сроком), по возрастанию user_id: NDJSON (по объекту на строку) или CSV (user_id;assigned_at;expires_at). Для постраничной выгрузки передайте limit и в следующем запросе after = user_id последней строки; страница короче limit — последняя. Без limit выгружаются все участники.
+// @Tags segment
+// @Produce json
+// @Produce text/csv
+// @Param slug path string true "SLUG сегмента"
+// @Param format query string false "Формат: ndjson (по умолчанию) или csv"
+// @Param after query int false "Выгружать пользователей с user_id больше указанного"
+// @Param limit query int false "Размер страницы (1-100000)"
+// @Success 200 {array} model.SegmentMemberDTO "Участники сегмента (NDJSON или CSV)"
+// @Failure 400 {object} model.ErrorDTO "Невалидные параметры"
+// @Failure 404 {object} model.ErrorDTO "Сегмент не найден"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /segments/{slug}/users [get]
+func (h *HTTPHandlers) HandleGetSegmentMembers(w http.ResponseWriter, r *http.Request) {
+	slug, _ := getSlugFromPath(r.URL.Path)
+	q := r.URL.Query()
+	var afterUserID int64
+	if v := q.Get("after"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, apperror.ErrUserIDInvalid.Error())
+			return
+		}
+		afterUserID = parsed
+	}
+	var limit int
+	if v := q.Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			writeJSONError(w, http.StatusBadRequest, apperror.ErrMembersLimit.Error())
+			return
+		}
+		limit = parsed
+	}
+	var stream membersStream
+	switch q.Get("format") {
+	case "", "ndjson":
+		stream = &ndjsonMembersStream{w: w, enc: json.NewEncoder(w)}
+	case "csv":
+		stream = &csvMembersStream{w: w, writer: csv.NewWriter(w)}
+	default:
+		writeJSONError(w, http.StatusBadRequest, apperror.ErrMembersFormat.Error())
+		return
+	}
+	err := h.UserService.StreamSegmentMembers(r.Context(), slug, afterUserID, limit, stream.write)
+	if err == nil {
+		err = stream.finish()
+	}
+	if err != nil {
+		if stream.started() {
+			// Статус уже отправлен — обрываем ответ, чтобы клиент не принял его за полный
+			slog.Error("segment members stream interrupted", "error", err)
+			panic(http.ErrAbortHandler)
+		}
+		if errors.Is(err, apperror.ErrMembersLimit) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		status := segmentErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			slog.Error("failed to get segment members", "error", err)
+			writeJSONError(w, status, "failed to get segment members")
+			return
+		}
+		writeJSONError(w, status, err.Error())
+	}
+}
+
+// membersStream пишет участников сегмента по мере чтения; заголовки отправляются
+// с первой записью (или в finish для пустого сегмента)
+type membersStream interface {
+	write(member model.SegmentMemberDTO) error
+	finish() error
+	started() bool
+}
+
+type ndjsonMembersStream struct {
+	w     http.ResponseWriter
+	enc   *json.Encoder
+	begun bool
+}
+
+func (s *ndjsonMembersStream) begin() {
+	s.begun = true
+	s.w.Header().Set("Content-Type", "application/x-ndjson")
+}
+
+func (s *ndjsonMembersStream) write(member model.SegmentMemberDTO) error {
+	if !s.begun {
+		s.begin()
+	}
+	return s.enc.Encode(member)
+}
+
+func (s *ndjsonMembersStream) finish() error {
+	if !s.begun {
+		s.begin()
+		s.w.WriteHeader(http.StatusOK)
+	}
+	return nil
+}
+
+func (s *ndjsonMembersStream) started() bool { return s.begun }
+
+type csvMembersStream struct {
+	w      http.ResponseWriter
+	writer *csv.Writer
+	begun  bool
+}
+
+func (s *csvMembersStream) begin() error {
+	s.begun = true
+	s.w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	s.writer.Comma = ';'
+	return s.writer.Write([]string{"user_id", "assigned_at", "expires_at"})
+}
+
+func (s *csvMembersStream) write(member model.SegmentMemberDTO) error {
+	if !s.begun {
+		if err := s.begin(); err != nil {
+			return err
+		}
+	}
+	return s.writer.Write([]string{strconv.FormatInt(member.User_id, 10), csvTime(member.Assigned_at), csvTime(member.Expires_at)})
+}
+
+func (s *csvMembersStream) finish() error {
+	if !s.begun {
+		if err := s.begin(); err != nil {
+			return err
+		}
+	}
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+func (s *csvMembersStream) started() bool { return s.begun }
+
+// csvTime форматирует необязательное время для CSV; пустая ячейка — значения нет
+func csvTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
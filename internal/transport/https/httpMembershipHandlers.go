@@ -0,0 +1,53 @@
+package https
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"progression1/internal/apperror"
+	"strings"
+)
+
+// @Summary Проверить членство пользователя в сегменте
+// @Description Отвечает, состоит ли пользователь в одном сегменте, с источником членства (manual — ручное назначение или явное включение, auto — по auto_percent, rule — по правилу таргетинга) и сроком ручного назначения. Читается только запрошенный сегмент. Query-параметры считаются атрибутами пользователя, как в GET /user/{user_id}.
+// @Tags user
+// @Produce json
+// @Param user_id path int true "ID пользователя"
+// @Param slug path string true "SLUG сегмента"
+// @Success 200 {object} model.UserSegmentMembershipDTO "Членство в сегменте"
+// @Failure 400 {object} model.ErrorDTO "Невалидный ID пользователя или SLUG"
+// @Failure 404 {object} model.ErrorDTO "Сегмент не найден"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /user/{user_id}/segments/{slug} [get]
+func (h *HTTPHandlers) HandleCheckUserSegment(w http.ResponseWriter, r *http.Request) {
+	userID, err := geIDfFromPath(r.URL.Path)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	slug := strings.TrimPrefix(getUserSubPath(r.URL.Path), "segments/")
+	attrs := make(map[string]any)
+	for key, values := range r.URL.Query() {
+		attrs[key] = values[0]
+	}
+	membership, err := h.UserService.CheckUserSegment(r.Context(), userID, slug, attrs)
+	if err != nil {
+		if errors.Is(err, apperror.ErrAttributeValue) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		status := segmentErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			slog.Error("failed to check user segment", "error", err)
+			writeJSONError(w, status, "internal server error")
+			return
+		}
+		writeJSONError(w, status, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(membership); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
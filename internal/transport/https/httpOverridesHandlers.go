@@ -0,0 +1,62 @@
+package https
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"progression1/internal/model"
+)
+
+// @Summary Получить переопределения сегмента
+// @Description Возвращает пользователей, явно включённых в сегмент (include) и исключённых из него (exclude)
+// @Tags segment
+// @Accept json
+// @Produce json
+// @Param slug path string true "SLUG сегмента"
+// @Success 200 {object} model.SegmentOverridesDTO "Успешная операция"
+// @Failure 400 {object} model.ErrorDTO "Невалидный SLUG"
+// @Failure 404 {object} model.ErrorDTO "Сегмент не найден"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /segments/{slug}/overrides [get]
+func (h *HTTPHandlers) HandleGetSegmentOverrides(w http.ResponseWriter, r *http.Request) {
+	slug, _ := getSlugFromPath(r.URL.Path)
+	overrides, err := h.UserService.GetSegmentOverrides(r.Context(), slug)
+	if err != nil {
+		writeJSONError(w, segmentErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(overrides); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+// @Summary Изменить переопределения сегмента
+// @Description Явно включает пользователей в сегмент или исключает из него независимо от auto_percent и правил таргетинга. Каждое изменение пишется в историю (INCLUDE_ADDED, INCLUDE_REMOVED, EXCLUDE_ADDED, EXCLUDE_REMOVED).
+// @Tags segment
+// @Accept json
+// @Produce json
+// @Param slug path string true "SLUG сегмента"
+// @Param input body model.SegmentOverridesPatchDTO true "Изменения переопределений"
+// @Param X-Actor header string false "Кто вносит изменение (сохраняется в истории)"
+// @Success 200 {string} string "Успешная операция"
+// @Failure 400 {object} model.ErrorDTO "Невалидный запрос"
+// @Failure 404 {object} model.ErrorDTO "Сегмент не найден"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /segments/{slug}/overrides [patch]
+func (h *HTTPHandlers) HandleUpdateSegmentOverrides(w http.ResponseWriter, r *http.Request) {
+	slug, _ := getSlugFromPath(r.URL.Path)
+	var dto model.SegmentOverridesPatchDTO
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if err := h.UserService.UpdateSegmentOverrides(r.Context(), slug, dto, requestAudit(r, dto.Reason)); err != nil {
+		writeJSONError(w, segmentErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode("segment overrides successfully updated"); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
@@ -0,0 +1,72 @@
+package https
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"strings"
+	"time"
+)
+
+// @Summary Сформировать CSV-отчёт по истории
+// @Description Формирует CSV-файл с историей операций за месяц (user_id;segment;operation;created_at;actor;reason) и возвращает ссылку на него. Ссылка действует в течение срока хранения отчётов.
+// @Tags segment
+// @Accept json
+// @Produce json
+// @Param input body model.ReportRequestDTO true "Год и месяц отчёта"
+// @Success 200 {object} model.ReportDTO "Ссылка на отчёт"
+// @Failure 400 {object} model.ErrorDTO "Невалидный период"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /segments/history/report [post]
+func (h *HTTPHandlers) HandleCreateHistoryReport(w http.ResponseWriter, r *http.Request) {
+	var dto model.ReportRequestDTO
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	name, err := h.ReportService.CreateHistoryReport(r.Context(), dto.Year, dto.Month)
+	if err != nil {
+		if errors.Is(err, apperror.ErrReportPeriod) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		} else {
+			slog.Error("failed to create report", "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "failed to create report")
+		}
+		return
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	resp := model.ReportDTO{
+		URL:        scheme + "://" + r.Host + "/reports/" + name,
+		Expires_at: time.Now().Add(h.ReportService.Retention()).UTC(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+// @Summary Скачать CSV-отчёт
+// @Description Отдаёт CSV-файл, сформированный POST /segments/history/report
+// @Tags segment
+// @Produce text/csv
+// @Param name path string true "Имя файла отчёта"
+// @Success 200 {file} file "CSV-отчёт"
+// @Failure 404 {object} model.ErrorDTO "Отчёт не найден или срок его хранения истёк"
+// @Router /reports/{name} [get]
+func (h *HTTPHandlers) HandleDownloadReport(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/reports/")
+	path, err := h.ReportService.ReportPath(name)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	http.ServeFile(w, r, path)
+}
@@ -0,0 +1,90 @@
+package https
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"progression1/internal/model"
+)
+
+// @Summary Получить план раскатки сегмента
+// @Description Возвращает шаги плана раскатки, состояние паузы и действующий сейчас auto_percent
+// @Tags rollout
+// @Accept json
+// @Produce json
+// @Param slug path string true "SLUG сегмента"
+// @Success 200 {object} model.RolloutPlanDTO "Успешная операция"
+// @Failure 400 {object} model.ErrorDTO "Невалидный SLUG"
+// @Failure 404 {object} model.ErrorDTO "Сегмент не найден"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /segments/{slug}/rollout [get]
+func (h *HTTPHandlers) HandleGetRolloutPlan(w http.ResponseWriter, r *http.Request) {
+	slug, _ := getSlugFromPath(r.URL.Path)
+	plan, err := h.UserService.GetRolloutPlan(r.Context(), slug)
+	if err != nil {
+		writeJSONError(w, segmentErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(plan); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+// @Summary Задать план раскатки сегмента
+// @Description Полностью заменяет шаги плана раскатки. Пустой список шагов удаляет план.
+// @Tags rollout
+// @Accept json
+// @Produce json
+// @Param slug path string true "SLUG сегмента"
+// @Param input body model.RolloutStepsDTO true "Шаги плана раскатки"
+// @Success 200 {string} string "Успешная операция"
+// @Failure 400 {object} model.ErrorDTO "Невалидный запрос"
+// @Failure 404 {object} model.ErrorDTO "Сегмент не найден"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /segments/{slug}/rollout [put]
+func (h *HTTPHandlers) HandleSetRolloutSteps(w http.ResponseWriter, r *http.Request) {
+	slug, _ := getSlugFromPath(r.URL.Path)
+	var dto model.RolloutStepsDTO
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if err := h.UserService.SetRolloutSteps(r.Context(), slug, dto.Steps); err != nil {
+		writeJSONError(w, segmentErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode("rollout plan successfully updated"); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+// @Summary Поставить план раскатки на паузу или возобновить
+// @Description На паузе действует процент последнего шага, наступившего до момента паузы
+// @Tags rollout
+// @Accept json
+// @Produce json
+// @Param slug path string true "SLUG сегмента"
+// @Param input body model.RolloutPauseDTO true "Состояние паузы"
+// @Success 200 {string} string "Успешная операция"
+// @Failure 400 {object} model.ErrorDTO "Невалидный запрос"
+// @Failure 404 {object} model.ErrorDTO "Сегмент не найден"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /segments/{slug}/rollout [patch]
+func (h *HTTPHandlers) HandleSetRolloutPaused(w http.ResponseWriter, r *http.Request) {
+	slug, _ := getSlugFromPath(r.URL.Path)
+	var dto model.RolloutPauseDTO
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if err := h.UserService.SetRolloutPaused(r.Context(), slug, dto.Paused); err != nil {
+		writeJSONError(w, segmentErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode("rollout plan successfully updated"); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
@@ -1,84 +1,218 @@
-package https
-
-import (
-	"context"
-	"database/sql"
-	"fmt"
-	"log/slog"
-	"net/http"
-	"path/filepath"
-	"strconv"
-	"time"
-
-	httpSwagger "github.com/swaggo/http-swagger/v2"
-)
-
-func NewHTTPServer(httpHandler *HTTPHandlers, addr string) *http.Server {
-	mux := http.NewServeMux()
-	docsDir := filepath.Join(".", "docs")
-	mux.Handle("/swagger/", http.StripPrefix("/swagger/", http.FileServer(http.Dir(docsDir))))
-	mux.HandleFunc("/swagger/index.html", httpSwagger.Handler(
-		httpSwagger.URL("/swagger/swagger.json"),
-	))
-	mux.HandleFunc("/segments", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			httpHandler.HandleAddSegment(w, r)
-		case http.MethodGet:
-			httpHandler.HandleGetAllSegments(w, r)
-		default:
-			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
-		}
-	})
-	mux.HandleFunc("/segments/history", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
-			return
-		}
-		httpHandler.HandleGetH(w, r)
-	})
-	mux.HandleFunc("/user/", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			httpHandler.HandleAddUserToSegment(w, r)
-		case http.MethodGet:
-			httpHandler.HandleGetUserSegments(w, r)
-		case http.MethodPatch:
-			httpHandler.HandleUpdateUserSegments(w, r)
-		default:
-			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
-		}
-	})
-	return &http.Server{
-		Handler: mux,
-		Addr:    addr,
-	}
-}
-
-func StartServer(ctx context.Context, srv *http.Server, db *sql.DB, shutdownTimeouts string) error {
-	shutdownTimeouti, err := strconv.Atoi(shutdownTimeouts)
-	if err != nil {
-		return fmt.Errorf("convertation from .env file failed: %w", err)
-	}
-	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			slog.Default().Error("server ListenAndServe failed", "error", err)
-		}
-	}()
-	slog.Default().Info("server ListenAndServe successfully", "addr", srv.Addr)
-	<-ctx.Done()
-	slog.Default().Info("shutting down server gracefully", "shutdownTimeout", shutdownTimeouti)
-	shutdownTimeoutDuration := time.Second * time.Duration(shutdownTimeouti)
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeoutDuration)
-	defer cancel()
-	if err := srv.Shutdown(shutdownCtx); err != nil {
-		return fmt.Errorf("shutdown: %w", err)
-	}
-	slog.Default().Info("server successfully shut down")
-	slog.Default().Info("closing database connection")
-	if err := db.Close(); err != nil {
-		return fmt.Errorf("error closing database: %w", err)
-	}
-	slog.Default().Info("database closed")
-	return nil
-}
+package https
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	httpSwagger "github.com/swaggo/http-swagger/v2"
+)
+
+func NewHTTPServer(httpHandler *HTTPHandlers, addr string) *http.Server {
+	mux := http.NewServeMux()
+	docsDir := filepath.Join(".", "docs")
+	mux.Handle("/swagger/", http.StripPrefix("/swagger/", http.FileServer(http.Dir(docsDir))))
+	mux.HandleFunc("/swagger/index.html", httpSwagger.Handler(
+		httpSwagger.URL("/swagger/swagger.json"),
+	))
+	mux.HandleFunc("/segments", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			httpHandler.HandleAddSegment(w, r)
+		case http.MethodGet:
+			httpHandler.HandleGetAllSegments(w, r)
+		default:
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+	mux.HandleFunc("/segments/", func(w http.ResponseWriter, r *http.Request) {
+		_, sub := getSlugFromPath(r.URL.Path)
+		switch {
+		case sub == "" && r.Method == http.MethodDelete:
+			httpHandler.HandleDeleteSegment(w, r)
+		case sub == "" && r.Method == http.MethodPatch:
+			httpHandler.HandleUpdateSegment(w, r)
+		case sub == "config" && r.Method == http.MethodGet:
+			httpHandler.HandleGetSegmentConfigHistory(w, r)
+		case sub == "rollout" && r.Method == http.MethodGet:
+			httpHandler.HandleGetRolloutPlan(w, r)
+		case sub == "rollout" && r.Method == http.MethodPut:
+			httpHandler.HandleSetRolloutSteps(w, r)
+		case sub == "rollout" && r.Method == http.MethodPatch:
+			httpHandler.HandleSetRolloutPaused(w, r)
+		case sub == "overrides" && r.Method == http.MethodGet:
+			httpHandler.HandleGetSegmentOverrides(w, r)
+		case sub == "overrides" && r.Method == http.MethodPatch:
+			httpHandler.HandleUpdateSegmentOverrides(w, r)
+		case sub == "reshuffle" && r.Method == http.MethodPost:
+			httpHandler.HandleReshuffleSegment(w, r)
+		case sub == "users" && r.Method == http.MethodGet:
+			httpHandler.HandleGetSegmentMembers(w, r)
+		case sub == "stats" && r.Method == http.MethodGet:
+			httpHandler.HandleGetSegmentStats(w, r)
+		case sub == "" || sub == "config" || sub == "rollout" || sub == "overrides" || sub == "reshuffle" || sub == "users" || sub == "stats":
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		default:
+			writeJSONError(w, http.StatusNotFound, "not found")
+		}
+	})
+	mux.HandleFunc("/layers", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			httpHandler.HandleCreateLayer(w, r)
+		case http.MethodGet:
+			httpHandler.HandleGetLayers(w, r)
+		default:
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+	mux.HandleFunc("/segments/history", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		httpHandler.HandleGetH(w, r)
+	})
+	mux.HandleFunc("/segments/history/report", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		httpHandler.HandleCreateHistoryReport(w, r)
+	})
+	mux.HandleFunc("/segments/import", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		httpHandler.HandleImportSegmentUsers(w, r)
+	})
+	mux.HandleFunc("/reports/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		httpHandler.HandleDownloadReport(w, r)
+	})
+	mux.HandleFunc("/user/", func(w http.ResponseWriter, r *http.Request) {
+		sub := getUserSubPath(r.URL.Path)
+		switch {
+		case sub == "" && r.Method == http.MethodPost:
+			httpHandler.HandleAddUserToSegment(w, r)
+		case sub == "" && r.Method == http.MethodGet:
+			httpHandler.HandleGetUserSegments(w, r)
+		case sub == "" && r.Method == http.MethodPatch:
+			httpHandler.HandleUpdateUserSegments(w, r)
+		case sub == "segments" && r.Method == http.MethodGet:
+			httpHandler.HandleGetUserSegments(w, r)
+		case sub == "segments" && r.Method == http.MethodPost:
+			httpHandler.HandleEvaluateUserSegments(w, r)
+		case strings.HasPrefix(sub, "segments/") && r.Method == http.MethodGet:
+			httpHandler.HandleCheckUserSegment(w, r)
+		case sub == "explain" && r.Method == http.MethodGet:
+			httpHandler.HandleExplainUserSegments(w, r)
+		case sub == "" || sub == "segments" || strings.HasPrefix(sub, "segments/") || sub == "explain":
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		default:
+			writeJSONError(w, http.StatusNotFound, "not found")
+		}
+	})
+	mux.HandleFunc("/users/segments", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		httpHandler.HandleGetUsersSegments(w, r)
+	})
+	mux.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
+		if getUserSubPath(r.URL.Path) != "attributes" {
+			writeJSONError(w, http.StatusNotFound, "not found")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			httpHandler.HandleGetUserAttributes(w, r)
+		case http.MethodPut:
+			httpHandler.HandleSetUserAttributes(w, r)
+		case http.MethodPatch:
+			httpHandler.HandleMergeUserAttributes(w, r)
+		default:
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+	return &http.Server{
+		Handler: mux,
+		Addr:    addr,
+	}
+}
+
+// NewAdminServer создаёт внутренний сервер с метриками процесса (expvar), в том числе
+// кэша каталога сегментов (segment_cache); он слушает отдельный адрес и наружу не публикуется
+func NewAdminServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	return &http.Server{
+		Handler: mux,
+		Addr:    addr,
+	}
+}
+
+// StartAdminServer обслуживает внутренние запросы до отмены ctx, затем останавливает сервер
+func StartAdminServer(ctx context.Context, srv *http.Server) {
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Default().Error("admin server ListenAndServe failed", "error", err)
+		}
+	}()
+	slog.Default().Info("admin server ListenAndServe successfully", "addr", srv.Addr)
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), adminShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Default().Error("admin server shutdown failed", "error", err)
+	}
+}
+
+// adminShutdownTimeout — сколько ждать завершения запросов к внутреннему серверу
+const adminShutdownTimeout = 5 * time.Second
+
+// StartServer обслуживает запросы до отмены ctx, затем останавливает сервер;
+// stopWorkers вызывается после остановки сервера и до закрытия базы данных
+func StartServer(ctx context.Context, srv *http.Server, db *sql.DB, shutdownTimeouts string, stopWorkers func()) error {
+	shutdownTimeouti, err := strconv.Atoi(shutdownTimeouts)
+	if err != nil {
+		return fmt.Errorf("convertation from .env file failed: %w", err)
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Default().Error("server ListenAndServe failed", "error", err)
+		}
+	}()
+	slog.Default().Info("server ListenAndServe successfully", "addr", srv.Addr)
+	<-ctx.Done()
+	slog.Default().Info("shutting down server gracefully", "shutdownTimeout", shutdownTimeouti)
+	shutdownTimeoutDuration := time.Second * time.Duration(shutdownTimeouti)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeoutDuration)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	slog.Default().Info("server successfully shut down")
+	if stopWorkers != nil {
+		slog.Default().Info("waiting for background workers")
+		stopWorkers()
+	}
+	slog.Default().Info("closing database connection")
+	if err := db.Close(); err != nil {
+		return fmt.Errorf("error closing database: %w", err)
+	}
+	slog.Default().Info("database closed")
+	return nil
+}
@@ -0,0 +1,42 @@
+package https
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"progression1/internal/apperror"
+	"strconv"
+)
+
+// @Summary Получить статистику сегмента
+// @Description Возвращает число действующих ручных участников (и из них — с TTL), оценку численности по действующему auto_percent от реестра пользователей или USER_BASE_TOTAL и динамику ручного членства по дням (UTC) из истории (ADDED, REMOVED, EXPIRED; материализованные AUTO_* не учитываются): добавленные, удалённые и участники на конец дня. Правило таргетинга в оценке не учитывается.
+// @Tags segment
+// @Produce json
+// @Param slug path string true "SLUG сегмента"
+// @Param days query int false "Число дней динамики, включая текущий (1-365, по умолчанию 30)"
+// @Success 200 {object} model.SegmentStatsDTO "Успешная операция"
+// @Failure 400 {object} model.ErrorDTO "Невалидный SLUG или days"
+// @Failure 404 {object} model.ErrorDTO "Сегмент не найден"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /segments/{slug}/stats [get]
+func (h *HTTPHandlers) HandleGetSegmentStats(w http.ResponseWriter, r *http.Request) {
+	slug, _ := getSlugFromPath(r.URL.Path)
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, apperror.ErrStatsDays.Error())
+			return
+		}
+		days = parsed
+	}
+	stats, err := h.UserService.GetSegmentStats(r.Context(), slug, days)
+	if err != nil {
+		writeJSONError(w, segmentErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
@@ -1,239 +1,481 @@
-package https
-
-import (
-	"encoding/json"
-	"errors"
-	"log/slog"
-	"net/http"
-	"progression1/internal/model"
-	"progression1/internal/service"
-	"strconv"
-	"strings"
-)
-
-type HTTPHandlers struct {
-	UserService *service.UserService
-}
-
-func NewHTTPHandlers(UserService *service.UserService) *HTTPHandlers {
-	return &HTTPHandlers{
-		UserService: UserService,
-	}
-}
-
-// @Summary Получить историю операций с сегментами
-// @Description Получает историю добавления/удаления сегментов у пользователя. Если указаны параметры 'year' и 'month', возвращает данные за период. Иначе возвращает всю доступную историю.
-// @Tags segment
-// @Accept json
-// @Produce json
-// @Param year query int false "Год для фильтрации истории (например, 2024)"
-// @Param month query int false "Месяц для фильтрации истории (1-12)"
-// @Success 200 {array} model.HistoryTableDTO "Успешная операция. Возвращает список записей истории."
-// @Failure 400 {object} model.ErrorDTO "Невалидный запрос: неверный формат года/месяца или невалидный период."
-// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
-// @Router /segments/history [get]
-func (h *HTTPHandlers) HandleGetH(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Query() != nil {
-		q := r.URL.Query()
-		yearStr := q.Get("year")
-		monthStr := q.Get("month")
-		year, err := strconv.Atoi(yearStr)
-		if err != nil {
-			writeJSONError(w, http.StatusBadRequest, err.Error())
-			return
-		}
-		month, err := strconv.Atoi(monthStr)
-		if err != nil {
-			writeJSONError(w, http.StatusBadRequest, err.Error())
-			return
-		}
-		historyTables, err := h.UserService.GetHForPeriod(r.Context(), year, month)
-		if err != nil {
-			writeJSONError(w, http.StatusBadRequest, err.Error())
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(historyTables); err != nil {
-			slog.Warn("failed to encode response", "warn", err)
-		}
-	} else {
-		historyTables, err := h.UserService.GetHTable(r.Context())
-		if err != nil {
-			writeJSONError(w, http.StatusBadRequest, err.Error())
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(historyTables); err != nil {
-			slog.Warn("failed to encode response", "warn", err)
-		}
-	}
-}
-
-// @Summary Добавить сегмент
-// @Description Добавляет сегмент
-// @Tags segment
-// @Accept json
-// @Produce json
-// @Param input body model.SegmentDTO true "Параметры для добавления сегмента"
-// @Success 200 {string} string "Успешная операция"
-// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
-// @Failure 400 {object} model.ErrorDTO "Невалидный запрос или конфликт"
-// @Router /segments [post]
-func (h *HTTPHandlers) HandleAddSegment(w http.ResponseWriter, r *http.Request) {
-	var dto model.SegmentDTO
-	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
-		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
-		return
-	}
-	if err := h.UserService.CreateSegment(r.Context(), dto.Slug, dto.Auto_percent); err != nil {
-		writeJSONError(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode("slug successfully added"); err != nil {
-		slog.Warn("failed to encode response", "warn", err)
-	}
-}
-
-// @Summary Получить все сегменты
-// @Description Получает все существующие сегменты
-// @Tags segment
-// @Accept json
-// @Produce json
-// @Success 200 {array} string "Успешная операция"
-// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера при получении сегментов"
-// @Router /segments [get]
-func (h *HTTPHandlers) HandleGetAllSegments(w http.ResponseWriter, r *http.Request) {
-	slugs, err := h.UserService.GetAllSegments(r.Context())
-	if err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "failed to fetch segments")
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(slugs); err != nil {
-		slog.Warn("failed to encode response", "warn", err)
-	}
-}
-
-func geIDfFromPath(path string) (int64, error) {
-	if !strings.HasPrefix(path, "/users/") {
-		return 0, errors.New("invalid path")
-	}
-	// Извлекаем ID: /users/1000/segments → "1000"
-	parts := strings.Split(strings.TrimPrefix(path, "/users/"), "/")
-	if len(parts) == 0 || parts[0] == "" {
-		return 0, errors.New("missing user id")
-	}
-	userID, err := strconv.ParseInt(parts[0], 10, 64)
-	if err != nil {
-		return 0, err
-	}
-	return userID, nil
-}
-
-// @Summary Обновить сегменты пользователю
-// @Description Добавляет или удаляет сегменты у пользователя, опционально устанавливая TTL.
-// @Tags user
-// @Accept json
-// @Produce json
-// @Param input body model.SegmentUpdateUserDTO true "Параметры для добавления/удаления сегментов у пользователя"
-// @Param user_id path int true "ID пользователя"
-// @Success 200 {string} string "Операция прошла успешно"
-// @Failure 400 {object} model.ErrorDTO "Невалидный запрос или конфликт"
-// @Router /user/{user_id} [patch]
-func (h *HTTPHandlers) HandleUpdateUserSegments(w http.ResponseWriter, r *http.Request) {
-	userID, err := geIDfFromPath(r.URL.Path)
-	if err != nil {
-		writeJSONError(w, http.StatusBadRequest, err.Error())
-		return
-	}
-	var dto model.SegmentUpdateUserDTO
-	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
-		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
-		return
-	}
-	if err := h.UserService.UpdateUserSegments(r.Context(), userID, dto.AddSlugs, dto.RemoveSlugs, dto.TTLHours); err != nil {
-		writeJSONError(w, http.StatusBadRequest, err.Error())
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode("user segments successfully updated"); err != nil {
-		slog.Warn("failed to encode response", "warn", err)
-	}
-}
-
-// @Summary Добавить пользователя к сегменту
-// @Description Добавляет пользователя к существующему сегменту
-// @Tags user
-// @Accept json
-// @Produce json
-// @Param input body model.SegmentDTO true "Параметры для добавления/удаления сегментов"
-// @Param user_id path int true "ID пользователя"
-// @Success 200 {object} model.UserResponseDTO "Успешная операция"
-// @Failure 400 {object} model.ErrorDTO "Невалидный запрос"
-// @Router /user/{user_id} [post]
-func (h *HTTPHandlers) HandleAddUserToSegment(w http.ResponseWriter, r *http.Request) {
-	userID, err := geIDfFromPath(r.URL.Path)
-	if err != nil {
-		writeJSONError(w, http.StatusBadRequest, err.Error())
-		return
-	}
-	var dto model.SegmentDTO
-	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
-		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
-		return
-	}
-	if err := h.UserService.AddUserToSegment(r.Context(), userID, dto.Slug); err != nil {
-		writeJSONError(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	var resp model.UserResponseDTO = model.UserResponseDTO{
-		Received: true,
-		UserID:   userID,
-		Slug:     dto.Slug,
-	}
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		slog.Warn("failed to encode response", "warn", err)
-	}
-}
-
-// @Summary Получить сегменты пользователя
-// @Description Получает активные сегменты пользователя
-// @Tags user
-// @Accept json
-// @Produce json
-// @Param user_id path int true "ID пользователя"
-// @Success 200 {array} model.SegmentUserDataDTO "Список активных сегментов пользователя"
-// @Failure 400 {object} model.ErrorDTO "Невалидный ID пользователя"
-// @Failure 404 {object} model.ErrorDTO "Пользователь не найден"
-// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
-// @Router /user/{user_id} [get]
-func (h *HTTPHandlers) HandleGetUserSegments(w http.ResponseWriter, r *http.Request) {
-	userID, err := geIDfFromPath(r.URL.Path)
-	if err != nil {
-		writeJSONError(w, http.StatusBadRequest, err.Error())
-		return
-	}
-	slugs, err := h.UserService.GetUserSegments(r.Context(), userID)
-	if err != nil {
-		if errors.Is(err, errors.New("user not found")) {
-			writeJSONError(w, http.StatusNotFound, err.Error())
-		} else if errors.Is(err, errors.New("segment not found")) {
-			writeJSONError(w, http.StatusBadRequest, err.Error())
-		} else {
-			writeJSONError(w, http.StatusInternalServerError, "internal server error")
-		}
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(slugs); err != nil {
-		slog.Warn("failed to encode response", "warn", err)
-	}
-}
-
-func writeJSONError(w http.ResponseWriter, status int, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(model.ErrorDTO{Error: message})
-}
+package https
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"progression1/internal/apperror"
+	"progression1/internal/model"
+	"progression1/internal/service"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type HTTPHandlers struct {
+	UserService   *service.UserService
+	ReportService *service.ReportService
+}
+
+func NewHTTPHandlers(UserService *service.UserService, ReportService *service.ReportService) *HTTPHandlers {
+	return &HTTPHandlers{
+		UserService:   UserService,
+		ReportService: ReportService,
+	}
+}
+
+// @Summary Добавить сегмент
+// @Description Добавляет сегмент. С "materialize": true известные пользователи (реестр users), попадающие в auto_percent и правило таргетинга (с учётом include/exclude переопределений), записываются в сегмент фоновой задачей с операцией AUTO_ADDED в истории; при изменении конфигурации или переопределений сегмента эти строки пересчитываются.
+// @Tags segment
+// @Accept json
+// @Produce json
+// @Param input body model.SegmentDTO true "Параметры для добавления сегмента"
+// @Param X-Actor header string false "Кто вносит изменение (сохраняется в истории)"
+// @Success 200 {string} string "Успешная операция"
+// @Failure 400 {object} model.ErrorDTO "Невалидный запрос"
+// @Failure 404 {object} model.ErrorDTO "Слой не найден"
+// @Failure 409 {object} model.ErrorDTO "Сегмент с таким slug уже существует"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /segments [post]
+func (h *HTTPHandlers) HandleAddSegment(w http.ResponseWriter, r *http.Request) {
+	var dto model.SegmentDTO
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if err := h.UserService.CreateSegment(r.Context(), dto, requestAudit(r, dto.Reason)); err != nil {
+		status := segmentErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			slog.Error("failed to create segment", "error", err)
+			writeJSONError(w, status, "failed to create segment")
+			return
+		}
+		writeJSONError(w, status, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode("slug successfully added"); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+// @Summary Получить все сегменты
+// @Description Получает все существующие сегменты с метаданными и состоянием жизненного цикла (scheduled, active, ended). Список можно отфильтровать по тегу и владельцу.
+// @Tags segment
+// @Accept json
+// @Produce json
+// @Param tag query string false "Тег сегмента"
+// @Param owner query string false "Владелец сегмента"
+// @Success 200 {array} model.SegmentInfoDTO "Успешная операция"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера при получении сегментов"
+// @Router /segments [get]
+func (h *HTTPHandlers) HandleGetAllSegments(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := model.SegmentFilterDTO{
+		Tag:   q.Get("tag"),
+		Owner: q.Get("owner"),
+	}
+	slugs, err := h.UserService.GetAllSegments(r.Context(), filter)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to fetch segments")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(slugs); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+// @Summary Удалить сегмент
+// @Description Удаляет сегмент вместе со всеми назначениями пользователей. Для каждого затронутого пользователя в историю пишется операция REMOVED.
+// @Tags segment
+// @Accept json
+// @Produce json
+// @Param slug path string true "SLUG сегмента"
+// @Param reason query string false "Причина удаления (сохраняется в истории)"
+// @Param X-Actor header string false "Кто вносит изменение (сохраняется в истории)"
+// @Success 200 {string} string "Успешная операция"
+// @Failure 400 {object} model.ErrorDTO "Невалидный SLUG"
+// @Failure 404 {object} model.ErrorDTO "Сегмент не найден"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /segments/{slug} [delete]
+func (h *HTTPHandlers) HandleDeleteSegment(w http.ResponseWriter, r *http.Request) {
+	slug, _ := getSlugFromPath(r.URL.Path)
+	if err := h.UserService.DeleteSegment(r.Context(), slug, requestAudit(r, r.URL.Query().Get("reason"))); err != nil {
+		writeJSONError(w, segmentErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode("slug successfully deleted"); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+// @Summary Изменить сегмент
+// @Description Меняет auto_percent и окно активности (active_from/active_until) сегмента. clear_active_from и clear_active_until снимают соответствующую границу окна. Пользователи, уже попавшие в сегмент, остаются в нём при увеличении процента. Каждое изменение пишется в аудит конфигурации.
+// @Tags segment
+// @Accept json
+// @Produce json
+// @Param slug path string true "SLUG сегмента"
+// @Param input body model.SegmentPatchDTO true "Новые параметры сегмента"
+// @Success 200 {string} string "Успешная операция"
+// @Failure 400 {object} model.ErrorDTO "Невалидный запрос"
+// @Failure 404 {object} model.ErrorDTO "Сегмент не найден"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /segments/{slug} [patch]
+func (h *HTTPHandlers) HandleUpdateSegment(w http.ResponseWriter, r *http.Request) {
+	slug, _ := getSlugFromPath(r.URL.Path)
+	var dto model.SegmentPatchDTO
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if err := h.UserService.UpdateSegment(r.Context(), slug, dto); err != nil {
+		writeJSONError(w, segmentErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode("segment successfully updated"); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+// @Summary Получить аудит конфигурации сегмента
+// @Description Возвращает историю изменений auto_percent сегмента, начиная с создания
+// @Tags segment
+// @Accept json
+// @Produce json
+// @Param slug path string true "SLUG сегмента"
+// @Success 200 {array} model.SegmentConfigHistoryDTO "Успешная операция"
+// @Failure 400 {object} model.ErrorDTO "Невалидный SLUG"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /segments/{slug}/config [get]
+func (h *HTTPHandlers) HandleGetSegmentConfigHistory(w http.ResponseWriter, r *http.Request) {
+	slug, _ := getSlugFromPath(r.URL.Path)
+	entries, err := h.UserService.GetSegmentConfigHistory(r.Context(), slug)
+	if err != nil {
+		writeJSONError(w, segmentErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+// @Summary Перемешать выборку сегмента
+// @Description Меняет соль бакетирования сегмента: при том же auto_percent в сегмент попадает новая случайная выборка пользователей. Вход и выход известных пользователей пишутся в историю (RESHUFFLE_ADDED, RESHUFFLE_REMOVED); у сегмента с materialize автоматические строки пересчитывает фоновая материализация (AUTO_ADDED, AUTO_REMOVED). Ручные назначения и переопределения не меняются. Сегменты слоёв не перемешиваются.
+// @Tags segment
+// @Accept json
+// @Produce json
+// @Param slug path string true "SLUG сегмента"
+// @Param reason query string false "Причина перемешивания (сохраняется в истории)"
+// @Param X-Actor header string false "Кто вносит изменение (сохраняется в истории)"
+// @Success 200 {object} model.ReshuffleResultDTO "Успешная операция"
+// @Failure 400 {object} model.ErrorDTO "Невалидный SLUG или сегмент слоя"
+// @Failure 404 {object} model.ErrorDTO "Сегмент не найден"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /segments/{slug}/reshuffle [post]
+func (h *HTTPHandlers) HandleReshuffleSegment(w http.ResponseWriter, r *http.Request) {
+	slug, _ := getSlugFromPath(r.URL.Path)
+	result, err := h.UserService.ReshuffleSegment(r.Context(), slug, requestAudit(r, r.URL.Query().Get("reason")))
+	if err != nil {
+		writeJSONError(w, segmentErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+// Извлекаем SLUG и подресурс: /segments/AVITO_TEST/config → "AVITO_TEST", "config"
+func getSlugFromPath(path string) (string, string) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/segments/"), "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// segmentErrorStatus сопоставляет ошибки сервиса с HTTP-статусом
+func segmentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, apperror.ErrSegmentNotFound),
+		errors.Is(err, apperror.ErrLayerNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, apperror.ErrSegmentExists),
+		errors.Is(err, apperror.ErrLayerConflict):
+		return http.StatusConflict
+	case errors.Is(err, apperror.ErrEmptySlug),
+		errors.Is(err, apperror.ErrSlugLength),
+		errors.Is(err, apperror.ErrSlugRegex),
+		errors.Is(err, apperror.ErrSlugReserved),
+		errors.Is(err, apperror.ErrPercentAbove),
+		errors.Is(err, apperror.ErrPercentLess),
+		errors.Is(err, apperror.ErrPercentPrecision),
+		errors.Is(err, apperror.ErrLayerPercent),
+		errors.Is(err, apperror.ErrEmptyPatch),
+		errors.Is(err, apperror.ErrActiveWindow),
+		errors.Is(err, apperror.ErrActiveClear),
+		errors.Is(err, apperror.ErrLayerOverflow),
+		errors.Is(err, apperror.ErrLayerOffset),
+		errors.Is(err, apperror.ErrLayerReshuffle),
+		errors.Is(err, apperror.ErrLayerRule),
+		errors.Is(err, apperror.ErrDescriptionLength),
+		errors.Is(err, apperror.ErrOwnerLength),
+		errors.Is(err, apperror.ErrTooManyTags),
+		errors.Is(err, apperror.ErrTagInvalid),
+		errors.Is(err, apperror.ErrRuleTooManyConditions),
+		errors.Is(err, apperror.ErrRuleAttribute),
+		errors.Is(err, apperror.ErrRuleOperator),
+		errors.Is(err, apperror.ErrRuleValue),
+		errors.Is(err, apperror.ErrUserIDInvalid),
+		errors.Is(err, apperror.ErrTooManyOverrides),
+		errors.Is(err, apperror.ErrOverrideConflict),
+		errors.Is(err, apperror.ErrTooManyRolloutSteps),
+		errors.Is(err, apperror.ErrRolloutStepTime),
+		errors.Is(err, apperror.ErrRolloutStepsDuplicate),
+		errors.Is(err, apperror.ErrActorLength),
+		errors.Is(err, apperror.ErrReasonLength),
+		errors.Is(err, apperror.ErrStatsDays):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func geIDfFromPath(path string) (int64, error) {
+	var rest string
+	switch {
+	case strings.HasPrefix(path, "/user/"):
+		rest = strings.TrimPrefix(path, "/user/")
+	case strings.HasPrefix(path, "/users/"):
+		rest = strings.TrimPrefix(path, "/users/")
+	default:
+		return 0, errors.New("invalid path")
+	}
+	// Извлекаем ID: /user/1000/segments → "1000"
+	parts := strings.Split(rest, "/")
+	if len(parts) == 0 || parts[0] == "" {
+		return 0, errors.New("missing user id")
+	}
+	userID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// requestAudit берёт actor из заголовка X-Actor; reason передаётся в теле или query запроса
+func requestAudit(r *http.Request, reason string) model.AuditDTO {
+	return model.AuditDTO{Actor: strings.TrimSpace(r.Header.Get("X-Actor")), Reason: reason}
+}
+
+// Извлекаем подресурс пользователя: /user/1000/segments → "segments"
+func getUserSubPath(path string) string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "/user/"), "/users/")
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// @Summary Обновить сегменты пользователю
+// @Description Добавляет или удаляет сегменты у пользователя. Срок действия задаётся общим ttl_hours для addslugs или для каждого сегмента в add (ttl_hours от 1 до 719 либо expires_at в будущем). update продлевает (ttl_hours, expires_at) или снимает (clear_ttl) TTL существующего назначения. Некорректный срок отклоняется с ошибкой.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param input body model.SegmentUpdateUserDTO true "Параметры для добавления/удаления сегментов у пользователя"
+// @Param user_id path int true "ID пользователя"
+// @Param X-Actor header string false "Кто вносит изменение (сохраняется в истории)"
+// @Success 200 {string} string "Операция прошла успешно"
+// @Failure 400 {object} model.ErrorDTO "Невалидный запрос или конфликт"
+// @Router /user/{user_id} [patch]
+func (h *HTTPHandlers) HandleUpdateUserSegments(w http.ResponseWriter, r *http.Request) {
+	userID, err := geIDfFromPath(r.URL.Path)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var dto model.SegmentUpdateUserDTO
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if err := h.UserService.UpdateUserSegments(r.Context(), userID, dto, requestAudit(r, dto.Reason)); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode("user segments successfully updated"); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+// @Summary Добавить пользователя к сегменту
+// @Description Добавляет пользователя к существующему сегменту
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param input body model.SegmentDTO true "Параметры для добавления/удаления сегментов"
+// @Param user_id path int true "ID пользователя"
+// @Param X-Actor header string false "Кто вносит изменение (сохраняется в истории)"
+// @Success 200 {object} model.UserResponseDTO "Успешная операция"
+// @Failure 400 {object} model.ErrorDTO "Невалидный запрос"
+// @Router /user/{user_id} [post]
+func (h *HTTPHandlers) HandleAddUserToSegment(w http.ResponseWriter, r *http.Request) {
+	userID, err := geIDfFromPath(r.URL.Path)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var dto model.SegmentDTO
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if err := h.UserService.AddUserToSegment(r.Context(), userID, dto.Slug, requestAudit(r, dto.Reason)); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	var resp model.UserResponseDTO = model.UserResponseDTO{
+		Received: true,
+		UserID:   userID,
+		Slug:     dto.Slug,
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+// @Summary Получить сегменты пользователя
+// @Description Получает активные сегменты пользователя. Query-параметры считаются атрибутами пользователя для сегментов с правилами таргетинга (например, ?country=RU&platform=ios). С as_of сегменты восстанавливаются на указанный момент: ручные назначения (с их сроком действия) и переопределения — по истории операций, auto_percent, окно активности, правило, план раскатки и слой — по действовавшей тогда конфигурации; атрибуты пользователя берутся текущие.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param user_id path int true "ID пользователя"
+// @Param as_of query string false "Момент времени в прошлом (RFC3339)"
+// @Success 200 {array} model.SegmentUserDataDTO "Список активных сегментов пользователя"
+// @Failure 400 {object} model.ErrorDTO "Невалидный ID пользователя или as_of"
+// @Failure 404 {object} model.ErrorDTO "Пользователь не найден"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /user/{user_id} [get]
+// @Router /user/{user_id}/segments [get]
+func (h *HTTPHandlers) HandleGetUserSegments(w http.ResponseWriter, r *http.Request) {
+	userID, err := geIDfFromPath(r.URL.Path)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	var asOf *time.Time
+	if v := q.Get("as_of"); v != "" {
+		ts, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "'as_of' must be an RFC3339 timestamp")
+			return
+		}
+		asOf = &ts
+		q.Del("as_of")
+	}
+	attrs := make(map[string]any)
+	for key, values := range q {
+		attrs[key] = values[0]
+	}
+	h.writeUserSegments(w, r, userID, attrs, asOf)
+}
+
+// @Summary Получить сегменты пользователя по контексту
+// @Description Получает активные сегменты пользователя с учётом атрибутов из тела запроса для сегментов с правилами таргетинга
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param user_id path int true "ID пользователя"
+// @Param input body model.UserContextDTO true "Атрибуты пользователя"
+// @Success 200 {array} model.SegmentUserDataDTO "Список активных сегментов пользователя"
+// @Failure 400 {object} model.ErrorDTO "Невалидный запрос"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /user/{user_id}/segments [post]
+func (h *HTTPHandlers) HandleEvaluateUserSegments(w http.ResponseWriter, r *http.Request) {
+	userID, err := geIDfFromPath(r.URL.Path)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var dto model.UserContextDTO
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	h.writeUserSegments(w, r, userID, dto.Attributes, nil)
+}
+
+// @Summary Объяснить сегменты пользователя
+// @Description Возвращает решение по каждому сегменту с причиной: manual, expired_ttl, bucket_hit/bucket_miss (бакет против auto_percent), rule_not_matched, no_auto_percent, inactive, override_include/override_exclude. Query-параметры считаются атрибутами пользователя, как в GET /user/{user_id}.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param user_id path int true "ID пользователя"
+// @Success 200 {array} model.SegmentDecisionDTO "Решения по сегментам"
+// @Failure 400 {object} model.ErrorDTO "Невалидный ID пользователя"
+// @Failure 500 {object} model.ErrorDTO "Внутренняя ошибка сервера"
+// @Router /user/{user_id}/explain [get]
+func (h *HTTPHandlers) HandleExplainUserSegments(w http.ResponseWriter, r *http.Request) {
+	userID, err := geIDfFromPath(r.URL.Path)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	attrs := make(map[string]any)
+	for key, values := range r.URL.Query() {
+		attrs[key] = values[0]
+	}
+	decisions, err := h.UserService.ExplainUserSegments(r.Context(), userID, attrs)
+	if err != nil {
+		if errors.Is(err, apperror.ErrUserIDInvalid) || errors.Is(err, apperror.ErrAttributeValue) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		} else {
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(decisions); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+// writeUserSegments отдаёт сегменты пользователя на текущий момент или, если задан asOf, на момент asOf
+func (h *HTTPHandlers) writeUserSegments(w http.ResponseWriter, r *http.Request, userID int64, attrs map[string]any, asOf *time.Time) {
+	var (
+		slugs []model.SegmentUserDataDTO
+		err   error
+	)
+	if asOf != nil {
+		slugs, err = h.UserService.GetUserSegmentsAsOf(r.Context(), userID, attrs, *asOf)
+	} else {
+		slugs, err = h.UserService.GetUserSegments(r.Context(), userID, attrs)
+	}
+	if err != nil {
+		if errors.Is(err, apperror.ErrUserIDInvalid) || errors.Is(err, apperror.ErrAttributeValue) || errors.Is(err, apperror.ErrAsOfFuture) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		} else {
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(slugs); err != nil {
+		slog.Warn("failed to encode response", "warn", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(model.ErrorDTO{Error: message})
+}